@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	cryptotls "crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// headerFlags collects repeated -H "Name: value" flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header must be formatted as \"Name: value\": %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// runGet fetches a resource from any Braid server and prints its current version
+func runGet(args []string) error {
+	client, url, err := newCommandClient("get", args)
+	if err != nil {
+		return err
+	}
+
+	update, err := client.Get(context.Background(), url)
+	if err != nil {
+		return err
+	}
+	printUpdate(os.Stdout, *update)
+	return nil
+}
+
+// runSubscribe subscribes to a resource on any Braid server and prints every
+// update as it arrives, until the stream ends or the command is interrupted
+func runSubscribe(args []string) error {
+	client, url, err := newCommandClient("subscribe", args)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sub, err := client.Subscribe(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	for update := range sub.Updates {
+		printUpdate(os.Stdout, update)
+		fmt.Println()
+	}
+	return sub.Err()
+}
+
+// newCommandClient parses the flags of a client command and returns a Braid
+// client set up by them along with the URL to request
+func newCommandClient(name string, args []string) (*braidproto.Client, string, error) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	options := addRequestFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: braid-mock %s [flags] <url>\n", name)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return nil, "", fmt.Errorf("expected a single URL")
+	}
+
+	client := braidproto.NewClient(options.httpClient())
+	options.setHeaders(client.Header)
+	return client, flags.Arg(0), nil
+}
+
+// requestOptions are the flags of commands making requests to a server
+type requestOptions struct {
+	headers  headerFlags
+	insecure bool
+}
+
+// addRequestFlags defines the flags of commands making requests to a server
+func addRequestFlags(flags *flag.FlagSet) *requestOptions {
+	options := &requestOptions{}
+	flags.Var(&options.headers, "H", "Extra request header, e.g. -H \"Authorization: Bearer token\" (repeatable)")
+	flags.BoolVar(&options.insecure, "k", false, "Skip TLS certificate verification")
+	return options
+}
+
+// httpClient returns the HTTP client to make requests with
+func (o *requestOptions) httpClient() *http.Client {
+	if !o.insecure {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &cryptotls.Config{InsecureSkipVerify: true}
+	return &http.Client{Transport: transport}
+}
+
+// setHeaders adds the extra request headers to header
+func (o *requestOptions) setHeaders(header http.Header) {
+	for _, h := range o.headers {
+		name, value, _ := strings.Cut(h, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+}
+
+// printUpdate prints the versions and content of an update, indenting JSON
+func printUpdate(w io.Writer, update braidproto.Update) {
+	if update.URL != "" {
+		fmt.Fprintf(w, "URL: %s\n", update.URL)
+	}
+	fmt.Fprintf(w, "Version: %s\n", braidproto.FormatVersions(update.Version))
+	if len(update.Parents) > 0 {
+		fmt.Fprintf(w, "Parents: %s\n", braidproto.FormatVersions(update.Parents))
+	}
+	if update.MergeType != "" {
+		fmt.Fprintf(w, "Merge-Type: %s\n", update.MergeType)
+	}
+
+	if len(update.Patches) == 0 {
+		fmt.Fprintln(w, prettyContent(update.Body))
+		return
+	}
+	for _, patch := range update.Patches {
+		fmt.Fprintf(w, "Patch %s %s\n", patch.Unit, patch.Range)
+		fmt.Fprintln(w, prettyContent(patch.Content))
+	}
+}
+
+// prettyContent indents content if it is JSON and returns it unchanged otherwise
+func prettyContent(content string) string {
+	content = strings.TrimRight(content, "\r\n")
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(content), "", "  "); err != nil {
+		return content
+	}
+	return buf.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// runExport downloads the traffic recorded by a running server as HAR, for
+// inspection in browser devtools, e.g. braid-mock export har traffic.har
+func runExport(args []string) error {
+	if len(args) == 0 || args[0] != "har" {
+		fmt.Fprintln(os.Stderr, "Usage: braid-mock export har [flags] <file.har>")
+		return fmt.Errorf("unknown format, expected har")
+	}
+
+	flags := flag.NewFlagSet("export har", flag.ExitOnError)
+	adminURL := flags.String("admin", defaultAdminURL, "URL of the server's admin API")
+	clearAfter := flags.Bool("clear", false, "Clear the recorded traffic after exporting it")
+	options := addRequestFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: braid-mock export har [flags] <file.har>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a single file")
+	}
+
+	resp, err := adminRequest(options, http.MethodGet, *adminURL+"/har", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return fmt.Errorf("error downloading HAR: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	log.Printf("Traffic saved to %s", flags.Arg(0))
+
+	if *clearAfter {
+		resp, err := adminRequest(options, http.MethodDelete, *adminURL+"/har", nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		log.Printf("Recorded traffic cleared")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/server"
+)
+
+// hostRoute serves the requests for hosts matching a pattern
+type hostRoute struct {
+	pattern string
+	handler http.Handler
+}
+
+// startHostServers starts a server for every configured host, serving its
+// own root directory with its own watchers and subscriptions. It returns a
+// handler routing requests to them by Host header, and requests for other
+// hosts to the default handler, and a function closing the servers.
+func startHostServers(cfg *config.Config, fallback http.Handler) (http.Handler, func(), error) {
+	var servers []*server.BraidMockServer
+	closeAll := func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+
+	var routes []hostRoute
+	for _, host := range cfg.Hosts {
+		// Mounts and schedules refer to resources of the default root
+		// directory or bucket
+		hostCfg := *cfg
+		hostCfg.RootDir = host.RootDir
+		hostCfg.Bucket = config.BucketConfig{}
+		hostCfg.Hosts = nil
+		hostCfg.Mounts = nil
+		hostCfg.Schedules = nil
+
+		// Files and shared backends are the host's own, so hosts don't
+		// lock each other's state file or share versions across roots
+		name := hostName(host.Host)
+		hostCfg.Braid.StateFile = hostFile(cfg.Braid.StateFile, name)
+		hostCfg.Admin.AuditFile = hostFile(cfg.Admin.AuditFile, name)
+		hostCfg.Redis.Prefix = cfg.Redis.Prefix + ":" + name
+		if cfg.NATS.ClusterSubject != "" {
+			hostCfg.NATS.ClusterSubject = cfg.NATS.ClusterSubject + "." + name
+		}
+		if cfg.MQTT.ClientID != "" {
+			hostCfg.MQTT.ClientID = cfg.MQTT.ClientID + "-" + name
+		}
+
+		braidServer, err := server.NewBraidMockServer(&hostCfg)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("error serving host %s: %w", host.Host, err)
+		}
+		servers = append(servers, braidServer)
+		if err := braidServer.SetupWatchers(); err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("error watching host %s: %w", host.Host, err)
+		}
+
+		routes = append(routes, hostRoute{pattern: host.Host, handler: braidServer.SetupRoutes()})
+		log.Printf("Serving host %s from directory: %s", host.Host, host.RootDir)
+	}
+
+	if len(routes) == 0 {
+		return fallback, closeAll, nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		for _, route := range routes {
+			if matched, _ := path.Match(route.pattern, host); matched {
+				route.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	}), closeAll, nil
+}
+
+// hostName returns a host pattern with characters other than letters,
+// digits, dots and dashes replaced, to name the files and keys of its server
+func hostName(pattern string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, pattern)
+}
+
+// hostFile returns the file a host's server uses instead of one of the
+// default server, e.g. state.api.local.db for state.db, or none if unset
+func hostFile(file, name string) string {
+	if file == "" {
+		return ""
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + name + ext
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/har"
+	"gihan9a/braidmock/internal/openapi"
+)
+
+// importUsage describes the formats mock files are imported from
+const importUsage = `Usage: braid-mock import openapi [flags] <spec>
+       braid-mock import har [flags] <file.har>`
+
+// importedFixture is a mock file to write, described by where it came from
+type importedFixture struct {
+	Resource string
+	Type     string // Extension of non-JSON resources such as "txt", empty for JSON
+	Source   string // Operation or request the body was taken from
+	Body     []byte
+}
+
+// runImport generates mock files from an API spec or from traffic recorded
+// by a browser, e.g. braid-mock import openapi spec.yaml
+func runImport(args []string) error {
+	if len(args) == 0 || (args[0] != "openapi" && args[0] != "har") {
+		fmt.Fprintln(os.Stderr, importUsage)
+		return fmt.Errorf("unknown format, expected openapi or har")
+	}
+	format := args[0]
+
+	flags := flag.NewFlagSet("import "+format, flag.ExitOnError)
+	configFile := flags.String("config", "config.yml", "Path to configuration file")
+	dir := flags.String("d", "", "Directory to write .braid mock files to (overrides config)")
+	force := flags.Bool("force", false, "Overwrite existing mock files")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), importUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a single file")
+	}
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		cfg, _ = config.LoadConfig("")
+	}
+	if *dir != "" {
+		cfg.RootDir = *dir
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var fixtures []importedFixture
+	switch format {
+	case "openapi":
+		generated, err := openapi.Fixtures(data)
+		if err != nil {
+			return err
+		}
+		for _, fixture := range generated {
+			fixtures = append(fixtures, importedFixture{fixture.Resource, fixture.Type, fixture.Method + " " + fixture.Path, fixture.Body})
+		}
+	case "har":
+		recorded, err := har.Fixtures(data)
+		if err != nil {
+			return err
+		}
+		for _, fixture := range recorded {
+			fixtures = append(fixtures, importedFixture{fixture.Resource, "", fixture.Method + " " + fixture.URL, fixture.Body})
+		}
+	}
+
+	written := 0
+	for _, fixture := range fixtures {
+		file := filepath.Join(cfg.RootDir, mockFileName(cfg, fixture))
+		if rel, err := filepath.Rel(cfg.RootDir, file); err != nil || strings.HasPrefix(rel, "..") {
+			log.Printf("Skipping %s, outside of %s", fixture.Source, cfg.RootDir)
+			continue
+		}
+		if _, err := os.Stat(file); err == nil && !*force {
+			log.Printf("Skipping %s, %s exists", fixture.Source, file)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, fixture.Body, 0644); err != nil {
+			return err
+		}
+		log.Printf("Wrote %s from %s", file, fixture.Source)
+		written++
+	}
+
+	log.Printf("Imported %d of %d resources into %s", written, len(fixtures), cfg.RootDir)
+	return nil
+}
+
+// mockFileName returns the name of the mock file of an imported resource,
+// relative to the mock directory
+func mockFileName(cfg *config.Config, fixture importedFixture) string {
+	name := strings.TrimPrefix(fixture.Resource, "/")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += cfg.IndexName
+	}
+	name += ".braid"
+	if fixture.Type != "" {
+		name += "." + fixture.Type
+	}
+	return filepath.FromSlash(name)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// Ways the load generator can produce the updates it measures
+const (
+	loadWriteNone  = ""      // Only observe updates made by others
+	loadWriteAdmin = "admin" // Write through the admin API of this mock
+	loadWritePut   = "put"   // Write with Braid PUT requests, as to real Braid servers
+)
+
+// runLoadtest opens many concurrent subscriptions to a resource, measures
+// how long updates take to reach them and how many are lost, and prints a
+// report, e.g. braid-mock loadtest -subscribers 500 -resource /users/me
+func runLoadtest(args []string) error {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := flags.String("target", "http://localhost:3000", "Base URL of the Braid server, this mock or any other")
+	resource := flags.String("resource", "", "Path of the resource to subscribe to")
+	subscribers := flags.Int("subscribers", 10, "Number of concurrent subscriptions")
+	duration := flags.Duration("duration", 10*time.Second, "How long to measure updates for")
+	write := flags.String("write", loadWriteNone, "Write updates to measure: \"admin\" through this mock's admin API, \"put\" with Braid PUT requests, or empty to only observe updates")
+	interval := flags.Duration("interval", time.Second, "Time between written updates")
+	adminURL := flags.String("admin", "", "URL of the server's admin API (default <target>/__admin)")
+	options := addRequestFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: braid-mock loadtest [flags] -resource <path>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *resource == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return fmt.Errorf("expected a resource")
+	}
+	if *subscribers < 1 {
+		return fmt.Errorf("invalid number of subscribers: %d", *subscribers)
+	}
+	if *write != loadWriteNone && *write != loadWriteAdmin && *write != loadWritePut {
+		return fmt.Errorf("invalid write mode: %s", *write)
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("invalid interval: %v", *interval)
+	}
+	if *adminURL == "" {
+		*adminURL = strings.TrimSuffix(*target, "/") + "/__admin"
+	}
+	resourceURL := strings.TrimSuffix(*target, "/") + "/" + strings.TrimPrefix(*resource, "/")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Every subscription gets its own connection, as separate clients would
+	httpClient := options.httpClient()
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.MaxIdleConnsPerHost = *subscribers
+	client := braidproto.NewClient(&http.Client{Transport: transport})
+	options.setHeaders(client.Header)
+
+	// Open the subscriptions, measuring once all have their initial state
+	log.Printf("Opening %d subscriptions to %s", *subscribers, resourceURL)
+	tracker := newLoadTracker()
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var ready, done sync.WaitGroup
+	ready.Add(*subscribers)
+	done.Add(*subscribers)
+	for i := 0; i < *subscribers; i++ {
+		go func() {
+			defer done.Done()
+			tracker.subscribe(streamCtx, client, resourceURL, ready.Done)
+		}()
+	}
+	ready.Wait()
+	connected, failed := tracker.connections()
+	if connected == 0 {
+		return fmt.Errorf("no subscription could be opened: %v", tracker.firstError())
+	}
+	log.Printf("%d subscriptions open, %d failed, measuring for %v", connected, failed, *duration)
+
+	// Write updates until the time is up, or only observe them
+	var original []byte
+	if *write != loadWriteNone {
+		update, err := client.Get(ctx, resourceURL)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", *resource, err)
+		}
+		original = []byte(update.Body)
+	}
+	deadline := time.After(*duration)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	written := 0
+measure:
+	for {
+		if *write != loadWriteNone {
+			written++
+			body := fmt.Sprintf(`{"loadtest":%d,"sent":%q}`, written, time.Now().Format(time.RFC3339Nano))
+			tracker.wrote(time.Now())
+			if err := writeLoadUpdate(options, *write, *adminURL, resourceURL, *resource, []byte(body), fmt.Sprintf("loadtest-%d", written)); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break measure
+		case <-deadline:
+			break measure
+		case <-ticker.C:
+		}
+	}
+
+	// Give the last update time to arrive before closing the subscriptions
+	if *write != loadWriteNone {
+		select {
+		case <-ctx.Done():
+		case <-time.After(*interval):
+		}
+	}
+	cancel()
+	done.Wait()
+
+	if original != nil {
+		if err := writeLoadUpdate(options, *write, *adminURL, resourceURL, *resource, original, "loadtest-restore"); err != nil {
+			log.Printf("Error restoring %s: %v", *resource, err)
+		}
+	}
+
+	tracker.report(os.Stdout, *subscribers)
+	return nil
+}
+
+// writeLoadUpdate replaces the body of the resource under test
+func writeLoadUpdate(options *requestOptions, mode, adminURL, resourceURL, resource string, body []byte, version string) error {
+	target := resourceURL
+	if mode == loadWriteAdmin {
+		target = adminURL + "/resource?resource=" + url.QueryEscape(resource)
+	}
+	req, err := http.NewRequest(http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	options.setHeaders(req.Header)
+	req.Header.Set("Content-Type", "application/json")
+	if mode == loadWritePut {
+		req.Header.Set("Version", braidproto.FormatVersions([]string{version}))
+	}
+
+	resp, err := options.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", resource, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error writing %s: unexpected status: %s: %s", resource, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// loadTracker collects the measurements of a load test. Each new version is
+// attributed to the oldest write not seen yet, or when not writing, to the
+// moment the first subscriber received it, so latencies measure fan-out.
+type loadTracker struct {
+	mu           sync.Mutex
+	connectTimes []time.Duration
+	failures     []error
+	disconnects  int
+	pending      []time.Time          // Writes whose version hasn't been received yet
+	origins      map[string]time.Time // When each version was written or first received
+	versions     []string             // Versions received, in order
+	deliveries   map[string]int       // Subscribers each version was delivered to
+	latencies    []time.Duration
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{
+		origins:    make(map[string]time.Time),
+		deliveries: make(map[string]int),
+	}
+}
+
+// subscribe opens a subscription and records the updates it receives after
+// its initial state until ctx is cancelled, calling ready once it is open
+// or has failed
+func (t *loadTracker) subscribe(ctx context.Context, client *braidproto.Client, resourceURL string, ready func()) {
+	started := time.Now()
+	sub, err := client.Subscribe(ctx, resourceURL)
+	if err == nil {
+		// The initial state isn't an update to measure
+		if _, ok := <-sub.Updates; !ok {
+			err = fmt.Errorf("stream closed before the initial state: %v", sub.Err())
+		}
+	}
+	t.mu.Lock()
+	if err != nil {
+		t.failures = append(t.failures, err)
+	} else {
+		t.connectTimes = append(t.connectTimes, time.Since(started))
+	}
+	t.mu.Unlock()
+	ready()
+	if err != nil {
+		return
+	}
+
+	for update := range sub.Updates {
+		t.receive(braidproto.FormatVersions(update.Version), time.Now())
+	}
+	if ctx.Err() == nil {
+		t.mu.Lock()
+		t.disconnects++
+		t.mu.Unlock()
+	}
+}
+
+// wrote records that an update was written
+func (t *loadTracker) wrote(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, at)
+}
+
+// receive records a subscriber receiving a version
+func (t *loadTracker) receive(version string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	origin, ok := t.origins[version]
+	if !ok {
+		origin = at
+		if len(t.pending) > 0 {
+			origin, t.pending = t.pending[0], t.pending[1:]
+		}
+		t.origins[version] = origin
+		t.versions = append(t.versions, version)
+	}
+	t.deliveries[version]++
+	t.latencies = append(t.latencies, at.Sub(origin))
+}
+
+// connections returns how many subscriptions opened and failed
+func (t *loadTracker) connections() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.connectTimes), len(t.failures)
+}
+
+// firstError returns the error the first failed subscription failed with
+func (t *loadTracker) firstError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.failures) == 0 {
+		return nil
+	}
+	return t.failures[0]
+}
+
+// report prints the measurements. Updates count as lost for every open
+// subscription they weren't delivered to, and written updates no
+// subscription received are lost for all of them.
+func (t *loadTracker) report(w io.Writer, subscribers int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	connected := len(t.connectTimes)
+	expected := (len(t.versions) + len(t.pending)) * connected
+	delivered := 0
+	for _, version := range t.versions {
+		delivered += t.deliveries[version]
+	}
+	lost := max(expected-delivered, 0)
+
+	fmt.Fprintf(w, "Subscriptions: %d of %d opened, %d failed, %d closed by the server\n", connected, subscribers, len(t.failures), t.disconnects)
+	if len(t.failures) > 0 {
+		fmt.Fprintf(w, "First failure: %v\n", t.failures[0])
+	}
+	fmt.Fprintf(w, "Connect time:  %s\n", formatDurations(t.connectTimes))
+	fmt.Fprintf(w, "Updates:       %d versions, %d written and never received\n", len(t.versions), len(t.pending))
+	lossRate := 0.0
+	if expected > 0 {
+		lossRate = float64(lost) / float64(expected) * 100
+	}
+	fmt.Fprintf(w, "Deliveries:    %d of %d, %d lost (%.1f%%)\n", delivered, expected, lost, lossRate)
+	fmt.Fprintf(w, "Latency:       %s\n", formatDurations(t.latencies))
+}
+
+// formatDurations summarizes durations by their percentiles
+func formatDurations(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "-"
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))].Round(time.Microsecond)
+	}
+	return fmt.Sprintf("min %v  p50 %v  p95 %v  p99 %v  max %v", percentile(0), percentile(0.5), percentile(0.95), percentile(0.99), percentile(1))
+}
@@ -1,16 +1,50 @@
 package main
 
 import (
+	"context"
+	cryptotls "crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"gihan9a/braidmock/internal/config"
 	"gihan9a/braidmock/internal/server"
 	"gihan9a/braidmock/internal/tls"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
 )
 
+// commands are run instead of the server when named by the first
+// argument, e.g. braid-mock subscribe http://localhost:3000/user/me
+var commands = map[string]func(args []string) error{
+	"get":       runGet,
+	"subscribe": runSubscribe,
+	"patch":     runPatch,
+	"snapshot":  runSnapshot,
+	"restore":   runRestore,
+	"import":    runImport,
+	"export":    runExport,
+	"loadtest":  runLoadtest,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("Error running %s: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	// Parse command line flags and get configuration
 	cfg, err := config.ParseFlags()
 	if err != nil {
@@ -19,37 +53,224 @@ func main() {
 
 	// Set up the TLS certificate if needed
 	if cfg.TLS.Enabled && cfg.TLS.GenerateCert {
-		if err := tls.EnsureCertificate(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+		certOptions := tls.CertOptions{
+			DNSNames:    cfg.TLS.DNSNames,
+			IPAddresses: cfg.TLS.IPAddresses,
+			Validity:    time.Duration(cfg.TLS.ValidityDays) * 24 * time.Hour,
+			KeyType:     cfg.TLS.KeyType,
+		}
+		if err := tls.EnsureCertificate(cfg.TLS.CertFile, cfg.TLS.KeyFile, certOptions); err != nil {
 			log.Fatalf("Failed to set up TLS certificate: %v", err)
 		}
 	}
 
+	// Stop serving on SIGINT or SIGTERM. Requests, including subscriptions,
+	// are canceled with it so shutting down doesn't wait for them.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Create server
 	braidServer, err := server.NewBraidMockServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
-	defer braidServer.Close()
 
 	// Set up watchers for the directory
 	if err := braidServer.SetupWatchers(); err != nil {
 		log.Fatalf("Failed to set up file watchers: %v", err)
 	}
 
+	// Show the terminal UI instead of the log, quitting it stopping the server
+	if cfg.TUI {
+		ui := server.NewTUI(braidServer)
+		go func() {
+			if err := ui.Run(); err != nil {
+				log.Printf("Failed to start terminal UI: %v", err)
+				return
+			}
+			stop()
+		}()
+	}
+
 	// Set up HTTP router
 	router := braidServer.SetupRoutes()
 
-	// Start server with or without TLS
+	// Serve other hosts from their own directories
+	router, closeHosts, err := startHostServers(cfg, router)
+	if err != nil {
+		log.Fatalf("Failed to set up hosts: %v", err)
+	}
+
+	// Start server with or without TLS, until one of its listeners fails
+	var httpServers []*http.Server
+	var h3Server *http3.Server
+	track := func(httpServer *http.Server) {
+		httpServer.BaseContext = func(net.Listener) context.Context { return ctx }
+		httpServers = append(httpServers, httpServer)
+	}
+	errs := make(chan error, 3)
+	serve := func(listen func() error) {
+		go func() { errs <- listen() }()
+	}
 	addr := fmt.Sprintf(":%d", cfg.Port)
 	if cfg.TLS.Enabled {
-		log.Printf("Braid mock server running at https://localhost%s", addr)
-		log.Printf("Serving .braid files from directory: %s", cfg.RootDir)
+		tlsAddr := addr
+		if cfg.TLS.Port != 0 {
+			tlsAddr = fmt.Sprintf(":%d", cfg.TLS.Port)
+		}
+		httpServer := newHTTPServer(cfg, tlsAddr, router)
+		httpServer.TLSConfig = &cryptotls.Config{}
+		track(httpServer)
+
+		// Require client certificates if a client CA bundle is configured
+		if cfg.TLS.ClientCAFile != "" {
+			tlsConfig, err := tls.ClientAuthConfig(cfg.TLS.ClientCAFile, cfg.TLS.AllowedSubjects)
+			if err != nil {
+				log.Fatalf("Failed to set up client certificate authentication: %v", err)
+			}
+			httpServer.TLSConfig = tlsConfig
+		}
+
+		// Serve HTTP/3 over QUIC on the same port, advertising it to TCP clients
+		if cfg.TLS.HTTP3 {
+			h3Server, err = newHTTP3Server(tlsAddr, router, httpServer.TLSConfig, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err != nil {
+				log.Fatalf("Failed to set up HTTP/3: %v", err)
+			}
+			httpServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h3Server.SetQUICHeaders(w.Header())
+				router.ServeHTTP(w, r)
+			})
+			serve(h3Server.ListenAndServe)
+			log.Printf("Serving HTTP/3 on UDP %s", tlsAddr)
+		}
+
+		if err := configureHTTP2(cfg, httpServer); err != nil {
+			log.Fatalf("Failed to set up HTTP/2: %v", err)
+		}
+
+		// Serve plain HTTP alongside HTTPS when TLS has its own port
+		if tlsAddr != addr {
+			var handler http.Handler = router
+			if cfg.TLS.RedirectHTTP {
+				handler = redirectToHTTPS(cfg.TLS.Port)
+				log.Printf("Redirecting http://localhost%s to HTTPS", addr)
+			} else {
+				log.Printf("Braid mock server running at http://localhost%s", addr)
+			}
+			plainServer := newHTTPServer(cfg, addr, handler)
+			track(plainServer)
+			serve(plainServer.ListenAndServe)
+		}
+
+		log.Printf("Braid mock server running at https://localhost%s", tlsAddr)
+		logMockSource(cfg)
 		log.Printf("Using TLS certificate: %s", cfg.TLS.CertFile)
 		log.Printf("Using TLS key: %s", cfg.TLS.KeyFile)
-		log.Fatal(http.ListenAndServeTLS(addr, cfg.TLS.CertFile, cfg.TLS.KeyFile, router))
+		if cfg.TLS.ClientCAFile != "" {
+			log.Printf("Requiring client certificates signed by: %s", cfg.TLS.ClientCAFile)
+		}
+		serve(func() error { return httpServer.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile) })
 	} else {
+		httpServer := newHTTPServer(cfg, addr, router)
+		track(httpServer)
 		log.Printf("Braid mock server running at http://localhost%s", addr)
-		log.Printf("Serving .braid files from directory: %s", cfg.RootDir)
-		log.Fatal(http.ListenAndServe(addr, router))
+		logMockSource(cfg)
+		serve(httpServer.ListenAndServe)
+	}
+
+	failed := false
+	select {
+	case <-ctx.Done():
+		log.Printf("Shutting down")
+	case err := <-errs:
+		log.Printf("Server error: %v", err)
+		failed = true
+	}
+	stop()
+
+	// Stop accepting requests and wait for those in progress, then close the
+	// servers, persisting their state
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error shutting down: %v", err)
+		}
+	}
+	if h3Server != nil {
+		h3Server.Close()
+	}
+	closeHosts()
+	braidServer.Close()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// shutdownTimeout is how long shutting down waits for requests in progress
+const shutdownTimeout = 10 * time.Second
+
+// newHTTPServer creates a server for handler on addr, timing out slow
+// clients and limiting the size of request headers as configured
+func newHTTPServer(cfg *config.Config, addr string, handler http.Handler) *http.Server {
+	httpServer := &http.Server{Addr: addr, Handler: handler}
+	if cfg.Limits.ReadHeaderTimeout > 0 {
+		httpServer.ReadHeaderTimeout = time.Duration(cfg.Limits.ReadHeaderTimeout) * time.Second
+	}
+	if cfg.Limits.IdleTimeout > 0 {
+		httpServer.IdleTimeout = time.Duration(cfg.Limits.IdleTimeout) * time.Second
+	}
+	if cfg.Limits.MaxHeaderSize > 0 {
+		httpServer.MaxHeaderBytes = cfg.Limits.MaxHeaderSize
+	}
+	return httpServer
+}
+
+// configureHTTP2 lets TLS clients multiplex subscriptions over HTTP/2,
+// unless it is disabled, when they only get HTTP/1.1
+func configureHTTP2(cfg *config.Config, httpServer *http.Server) error {
+	if cfg.TLS.DisableHTTP2 {
+		httpServer.TLSNextProto = map[string]func(*http.Server, *cryptotls.Conn, http.Handler){}
+		return nil
+	}
+	return http2.ConfigureServer(httpServer, &http2.Server{})
+}
+
+// newHTTP3Server creates an HTTP/3 server sharing the TLS settings of the HTTPS listener
+func newHTTP3Server(addr string, handler http.Handler, tlsConfig *cryptotls.Config, certFile, keyFile string) (*http3.Server, error) {
+	cert, err := cryptotls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	quicTLSConfig := tlsConfig.Clone()
+	quicTLSConfig.Certificates = []cryptotls.Certificate{cert}
+
+	return &http3.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: quicTLSConfig,
+	}, nil
+}
+
+// redirectToHTTPS permanently redirects requests to the same URL on the HTTPS port
+func redirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		target := "https://" + net.JoinHostPort(host, strconv.Itoa(port)) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// logMockSource logs where the mock files are served from
+func logMockSource(cfg *config.Config) {
+	if cfg.Bucket.URL != "" {
+		log.Printf("Serving .braid files from bucket: %s", cfg.Bucket.URL)
+		return
 	}
+	log.Printf("Serving .braid files from directory: %s", cfg.RootDir)
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	cryptotls "crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/server"
+	"gihan9a/braidmock/internal/tls"
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// startTLSServer serves a mock of a single resource over TLS as main does,
+// returning its URL and a client trusting its certificate
+func startTLSServer(t *testing.T, cfg *config.Config) (string, *http.Client) {
+	t.Helper()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := tls.EnsureCertificate(certFile, keyFile, tls.CertOptions{}); err != nil {
+		t.Fatalf("EnsureCertificate: %v", err)
+	}
+
+	braidServer, err := server.NewBraidMockServerFromFS(cfg, fstest.MapFS{
+		"me.braid": {Data: []byte(`{"name": "Ada"}`)},
+	})
+	if err != nil {
+		t.Fatalf("NewBraidMockServerFromFS: %v", err)
+	}
+	t.Cleanup(braidServer.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := newHTTPServer(cfg, listener.Addr().String(), braidServer.SetupRoutes())
+	httpServer.TLSConfig = &cryptotls.Config{}
+	if err := configureHTTP2(cfg, httpServer); err != nil {
+		t.Fatalf("configureHTTP2: %v", err)
+	}
+	go httpServer.ServeTLS(listener, certFile, keyFile)
+	t.Cleanup(func() { httpServer.Close() })
+
+	pem, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &cryptotls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	return "https://" + listener.Addr().String(), client
+}
+
+func TestSubscriptionProtocol(t *testing.T) {
+	tests := []struct {
+		name         string
+		disableHTTP2 bool
+		wantMajor    int
+	}{
+		{name: "HTTP/2", wantMajor: 2},
+		{name: "disable_http2", disableHTTP2: true, wantMajor: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, _ := config.LoadConfig("")
+			cfg.TLS.Enabled = true
+			cfg.TLS.DisableHTTP2 = test.disableHTTP2
+			url, client := startTLSServer(t, cfg)
+
+			req, err := http.NewRequest(http.MethodGet, url+"/me", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Subscribe", "true")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("subscribing: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.ProtoMajor != test.wantMajor {
+				t.Errorf("subscription served over %s, want HTTP/%d", resp.Proto, test.wantMajor)
+			}
+			if resp.StatusCode != braidproto.StatusSubscribed {
+				t.Fatalf("subscription status %d, want %d", resp.StatusCode, braidproto.StatusSubscribed)
+			}
+
+			update, err := braidproto.NewDecoder(resp.Body).Decode()
+			if err != nil {
+				t.Fatalf("reading the initial update: %v", err)
+			}
+			if update.Body != `{"name": "Ada"}` {
+				t.Errorf("initial update body %q", update.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/server"
+
+	"github.com/wI2L/jsondiff"
+)
+
+// runPatch changes a value in the mock file of a resource, or applies a JSON
+// Patch document to it, so a running server sends the change to subscribers
+func runPatch(args []string) error {
+	flags := flag.NewFlagSet("patch", flag.ExitOnError)
+	configFile := flags.String("config", "config.yml", "Path to configuration file")
+	dir := flags.String("d", "", "Directory containing .braid mock files (overrides config)")
+	patchFile := flags.String("patch-file", "", "JSON Patch document to apply instead of setting a single value")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: braid-mock patch [flags] <resource> <json-pointer> <value>")
+		fmt.Fprintln(flags.Output(), "       braid-mock patch [flags] -patch-file <file> <resource>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var resourceID string
+	var patch jsondiff.Patch
+	switch {
+	case *patchFile != "" && flags.NArg() == 1:
+		resourceID = flags.Arg(0)
+		data, err := os.ReadFile(*patchFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return fmt.Errorf("invalid JSON Patch document: %w", err)
+		}
+	case *patchFile == "" && flags.NArg() == 3:
+		resourceID = flags.Arg(0)
+		op, err := setValueOperation(flags.Arg(1), flags.Arg(2))
+		if err != nil {
+			return err
+		}
+		patch = jsondiff.Patch{op}
+	default:
+		flags.Usage()
+		return fmt.Errorf("wrong number of arguments")
+	}
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		cfg, _ = config.LoadConfig("")
+	}
+	if *dir != "" {
+		cfg.RootDir = *dir
+	}
+
+	if err := server.PatchMockFile(cfg, resourceID, patch); err != nil {
+		return err
+	}
+	log.Printf("Patched %s", resourceID)
+	return nil
+}
+
+// setValueOperation returns the operation setting the value at a JSON
+// Pointer. Values that aren't valid JSON are taken as strings, so
+// `patch /user/me /name Ada` needs no quotes.
+func setValueOperation(pointer, value string) (jsondiff.Operation, error) {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		decoded = value
+	}
+	return server.SetOperation(pointer, decoded)
+}
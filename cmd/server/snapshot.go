@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultAdminURL is where snapshot commands find the admin API of a server
+// running with the default settings
+const defaultAdminURL = "http://localhost:3000/__admin"
+
+// runSnapshot downloads a snapshot of all resources of a running server and
+// their version history to a file
+func runSnapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	adminURL := flags.String("admin", defaultAdminURL, "URL of the server's admin API")
+	options := addRequestFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: braid-mock snapshot [flags] <file.tar.gz>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a single file")
+	}
+
+	resp, err := adminRequest(options, http.MethodGet, *adminURL+"/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return fmt.Errorf("error downloading snapshot: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("Snapshot saved to %s", flags.Arg(0))
+	return nil
+}
+
+// runRestore uploads a snapshot to a running server, resetting its resources
+// and their version history to the state they were in when it was taken
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	adminURL := flags.String("admin", defaultAdminURL, "URL of the server's admin API")
+	options := addRequestFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: braid-mock restore [flags] <file.tar.gz>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a single file")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	resp, err := adminRequest(options, http.MethodPost, *adminURL+"/restore", file)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	log.Printf("Snapshot %s restored", flags.Arg(0))
+	return nil
+}
+
+// adminRequest makes a request to the admin API, failing unless it succeeds
+func adminRequest(options *requestOptions, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	options.setHeaders(req.Header)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+
+	resp, err := options.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
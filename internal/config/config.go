@@ -8,30 +8,381 @@ import (
 
 // TLSConfig holds TLS configuration options
 type TLSConfig struct {
-	Enabled      bool
-	CertFile     string
-	KeyFile      string
-	GenerateCert bool
+	Enabled         bool
+	CertFile        string
+	KeyFile         string
+	GenerateCert    bool
+	ClientCAFile    string
+	AllowedSubjects []string
+	DNSNames        []string
+	IPAddresses     []string
+	ValidityDays    int
+	KeyType         string
+	Port            int  // Separate port for HTTPS, leaving the main port serving plain HTTP
+	RedirectHTTP    bool // Redirect plain HTTP requests to HTTPS when a separate port is used
+	DisableHTTP2    bool // Only negotiate HTTP/1.1 on the TLS listener
+	HTTP3           bool // Also serve HTTP/3 over QUIC on the TLS port
 }
 
 // CORSConfig holds CORS configuration options
 type CORSConfig struct {
 	Enabled          bool
-	AllowOrigins     string
+	AllowOrigins     []string // Allowed origins, which may contain * wildcards such as https://*.example.com
 	AllowMethods     string
 	AllowHeaders     string
 	AllowCredentials bool
 	MaxAge           int
+	Rules            []CORSRule // Policies for some paths or origins, the first matching rule applying
+}
+
+// CORSRule overrides the CORS policy for requests under a path prefix, from
+// matching origins. Empty and nil fields keep the global settings.
+type CORSRule struct {
+	Path             string   // Prefix of the request paths the rule applies to, e.g. /auth/, empty for all
+	AllowOrigins     []string // Origins the rule applies to and allows, with * wildcards, empty for the global ones
+	AllowMethods     string
+	AllowHeaders     string
+	AllowCredentials *bool
+	MaxAge           int
+}
+
+// AdminConfig holds admin API configuration options
+type AdminConfig struct {
+	Enabled      bool
+	Prefix       string
+	UIPath       string
+	EditPath     string
+	HAREntries   int    // Exchanges recorded for export as HAR, negative to disable recording
+	AuditEntries int    // Writes to resources kept for the audit endpoint, negative to disable
+	AuditFile    string // JSONL file every write to a resource is appended to, if set
+}
+
+// WebSocketConfig holds WebSocket bridge configuration options
+type WebSocketConfig struct {
+	Enabled bool
+	Path    string
+}
+
+// WebhooksConfig holds webhook notification options
+type WebhooksConfig struct {
+	URLs    []string
+	Retries int
+	Timeout int
+}
+
+// GitConfig holds options for serving mock files as of past git commits
+type GitConfig struct {
+	Enabled        bool
+	ReplayInterval int // Milliseconds between the commits replayed to a subscription
+}
+
+// TemplatesConfig holds options for rendering resources that are templates
+type TemplatesConfig struct {
+	Seed         uint64 // Seed of the fake data in templates, 0 for different data on every render
+	ClientHeader string // Header identifying the client whose state templates keep
+	ClientCookie string // Cookie identifying the client when the header is missing
+}
+
+// SessionsConfig holds options for isolating the writes of test sessions
+// sharing a server
+type SessionsConfig struct {
+	Enabled bool
+	Header  string // Header identifying the session of a request
+	Cookie  string // Cookie identifying the session when the header is missing
+}
+
+// TombstonesConfig holds options for soft-deleting resources
+type TombstonesConfig struct {
+	Enabled bool
+	Body    string // Body of the 410 Gone responses for deleted resources
 }
 
+// NATSConfig holds options for publishing changes to NATS
+type NATSConfig struct {
+	URL            string
+	SubjectPrefix  string
+	ClusterSubject string // Subject instances of the mock share changes over, empty to not share them
+}
+
+// BucketConfig holds options for serving mock files from an S3 or Google
+// Cloud Storage bucket instead of the root directory
+type BucketConfig struct {
+	URL          string // s3://bucket/prefix or gs://bucket/prefix
+	Endpoint     string // S3 API endpoint, empty for the default of the URL's scheme
+	Region       string
+	AccessKey    string // Empty to take credentials from the environment
+	SecretKey    string
+	Insecure     bool // Connect to the endpoint over plain HTTP
+	PollInterval int  // Seconds between checks for changed objects
+}
+
+// RedisConfig holds options for sharing versions and updates between
+// instances through Redis
+type RedisConfig struct {
+	URL    string
+	Prefix string // Prefix of the keys and channel used, so instances of different mocks can share a server
+}
+
+// MQTTConfig holds options for publishing changes to an MQTT broker
+type MQTTConfig struct {
+	Broker      string
+	TopicPrefix string
+	ClientID    string
+	QoS         int
+}
+
+// MountConfig serves mock files from an extra directory under a URL prefix
+type MountConfig struct {
+	Prefix string // URL prefix, e.g. /shared
+	Dir    string // Directory holding the mock files, e.g. ../common-fixtures
+}
+
+// HostConfig serves requests for a host from its own root directory
+type HostConfig struct {
+	Host    string // Host name or pattern such as *.auth.local, without port
+	RootDir string // Directory holding the host's mock files
+}
+
+// ScheduleConfig describes a resource that is rewritten or mutated on an interval
+type ScheduleConfig struct {
+	Resource string
+	Interval int                       // Milliseconds between changes
+	Files    []string                  // Files whose content the resource cycles through, one per change
+	Set      map[string]interface{}    // Values set at JSON Pointers on every change, "$now" being the current time
+	Mutate   map[string]MutationConfig // Values changed at JSON Pointers on every change
+}
+
+// MutationConfig describes how a value of a scheduled resource changes on
+// every change, exactly one of Walk, Pick and Increment being set
+type MutationConfig struct {
+	Walk      float64       // Largest random step of a number
+	Min       *float64      // Lower bound of a random walk
+	Max       *float64      // Upper bound of a random walk
+	Decimals  int           // Decimal places changed numbers are rounded to
+	Pick      []interface{} // Values picked from at random
+	Increment float64       // Amount added to a number
+}
+
+// LimitsConfig holds limits on requests protecting a shared mock from
+// misbehaving clients such as fuzzers. Sizes and timeouts of -1 disable them.
+type LimitsConfig struct {
+	MaxBodySize       int64 // Bytes of a request body, larger ones are refused with 413
+	MaxHeaderSize     int   // Bytes of a request's headers, larger ones are refused with 431
+	MaxURLLength      int   // Bytes of a request's URL, longer ones are refused with 414
+	ReadHeaderTimeout int   // Seconds a connection has to send the headers of a request
+	ReadTimeout       int   // Seconds a connection has to send the body of a request
+	IdleTimeout       int   // Seconds a keep-alive connection is kept open between requests
+}
+
+// ErrorsConfig holds fixture files served as the bodies of error responses
+type ErrorsConfig struct {
+	NotFound    string // Body of 404 responses for missing resources
+	ServerError string // Body of 5xx responses
+}
+
+// Ways of handling a trailing slash in request paths
+const (
+	TrailingSlashIgnore   = "ignore"   // /users and /users/ both resolve to users.braid or users/index.braid
+	TrailingSlashStrict   = "strict"   // /users resolves only to users.braid and /users/ only to users/index.braid
+	TrailingSlashRedirect = "redirect" // Like ignore, but redirects to the path matching the mock file
+)
+
+// Parts of the server that authentication applies to
+const (
+	AuthScopeAll   = "all"   // Both mock resources and the admin interface
+	AuthScopeMock  = "mock"  // Only mock resources
+	AuthScopeAdmin = "admin" // Only the admin API, dashboard and editor
+)
+
+// AuthConfig holds authentication options
+type AuthConfig struct {
+	Scope        string
+	Users        map[string]string // Passwords by username for basic auth
+	APIKeys      []string
+	APIKeyHeader string
+	JWT          JWTConfig
+	RulesFile    string // File of rules simulating auth outcomes per path and token
+}
+
+// JWTConfig holds options for validating Bearer JWTs
+type JWTConfig struct {
+	Secret   string // HMAC secret for signed tokens
+	JWKSURL  string // URL of a JSON Web Key Set for asymmetrically signed tokens
+	Issuer   string
+	Audience string
+}
+
+// Behaviors when a client refers to a version the server doesn't know
+const (
+	UnknownVersionError    = "error"    // Respond with 309 Version Unknown
+	UnknownVersionSnapshot = "snapshot" // Ignore the versions and send the current state
+)
+
+// Formats of patches sent for changes to JSON resources
+const (
+	PatchFormatOperations = "operations"  // One patch per JSON Patch operation, with the operation as unit and a JSON Pointer range
+	PatchFormatJSON       = "json"        // Braid json range patches such as "json .a.b[0]"
+	PatchFormatJSONPatch  = "json-patch"  // A single RFC 6902 JSON Patch document
+	PatchFormatMergePatch = "merge-patch" // A single RFC 7386 JSON Merge Patch document
+)
+
+// IsPatchFormat reports whether a name is a supported patch format
+func IsPatchFormat(name string) bool {
+	switch name {
+	case PatchFormatOperations, PatchFormatJSON, PatchFormatJSONPatch, PatchFormatMergePatch:
+		return true
+	}
+	return false
+}
+
+// BraidConfig holds Braid protocol behavior options
+type BraidConfig struct {
+	UnknownVersion string
+	SSE            bool
+	HistorySize    int
+	HistoryBytes   int    // Bytes of updates and past bodies kept per resource, -1 disables the limit
+	StateFile      string // bbolt file persisting versions and history across restarts, empty keeps them in memory
+	MergeType      string
+	ContentTypes   map[string]string // Media types of typed mock files by extension
+	SnapshotOnly   bool              // Always send full bodies instead of patches
+	PatchFormat    string
+	Diff           DiffConfig
+	Compression    CompressionConfig
+	LargeSize      int // Resources larger than this many bytes are streamed and never diffed, -1 disables
+	Backpressure   BackpressureConfig
+	Subscriptions  SubscriptionsConfig
+	Chaos          ChaosConfig
+	Flush          FlushConfig
+}
+
+// Ways subscription streams are flushed to clients
+const (
+	FlushIdle  = "idle"  // Flush once no more updates are queued
+	FlushFrame = "frame" // Flush after every frame
+	FlushBytes = "bytes" // Flush only once enough bytes are buffered
+	FlushDelay = "delay" // Wait a fixed time before every flush
+)
+
+// FlushConfig holds options for when subscription streams are flushed, to
+// test clients against servers that buffer aggressively or flush every update
+type FlushConfig struct {
+	Mode  string
+	Bytes int // Bytes buffered before a flush in bytes mode
+	Delay int // Milliseconds waited before every flush in delay mode
+}
+
+// ChaosConfig holds options for disrupting subscriptions at random, to
+// shake out client reconnect and resync bugs during soak tests
+type ChaosConfig struct {
+	TerminatePercent int // Percentage of open subscriptions abruptly terminated per minute
+	FlushDelay       int // Maximum milliseconds a flush to a subscription is delayed by
+}
+
+// SubscriptionsConfig holds limits on how many subscriptions are accepted
+// and how long they are kept open
+type SubscriptionsConfig struct {
+	MaxDuration    int // Seconds after which a subscription is closed, 0 for no limit
+	IdleTimeout    int // Seconds without updates after which a subscription is closed, 0 for no limit
+	MaxTotal       int // Concurrent subscription streams across all resources, 0 for no limit
+	MaxPerResource int // Concurrent subscribers to a single resource, 0 for no limit
+	RetryAfter     int // Seconds clients refused for exceeding a limit are told to wait
+}
+
+// Ways of handling subscribers that read updates more slowly than they are produced
+const (
+	BackpressureBuffer     = "buffer"     // Queue updates, making the notifier wait briefly for room before disconnecting
+	BackpressureCoalesce   = "coalesce"   // Replace a full queue's updates to a resource with its latest state
+	BackpressureDisconnect = "disconnect" // Send a warning and close the subscription when its queue is full
+)
+
+// BackpressureConfig holds options for subscribers that can't keep up with updates
+type BackpressureConfig struct {
+	Policy     string
+	BufferSize int // Updates queued per subscription stream before the policy applies
+}
+
+// CompressionConfig holds options for compressing individual updates in subscription streams
+type CompressionConfig struct {
+	Enabled bool
+	MinSize int // Bodies and patches smaller than this are sent uncompressed
+}
+
+// DiffConfig holds options for computing JSON patches
+type DiffConfig struct {
+	LCS         bool // Diff arrays by longest common subsequence, producing element insertions and removals
+	Invertible  bool // Precede removals and replacements with a test of the old value
+	Equivalent  bool // Treat arrays with the same elements in a different order as equal
+	Rationalize bool // Replace whole objects when that is smaller than patching their members
+}
+
+// ResourceConfig holds settings for resources whose paths match a pattern
+type ResourceConfig struct {
+	Path         string
+	MergeType    string
+	ContentType  string
+	SnapshotOnly bool
+	PatchFormat  string
+	Template     bool
+	PageSize     int    // Items per page of a JSON array resource, 0 to serve it whole
+	PageEnvelope bool   // Wrap pages in an object with paging metadata
+	Schema       string // JSON Schema file the resource and writes to it must match
+	Script       string // Lua script computing the body the resource is served with
+	Access       string // Who may change the resource over HTTP
+}
+
+// Access levels of resources, deciding who may change them over HTTP. Edits
+// to mock files, scheduled changes and snapshot restores aren't affected.
+const (
+	AccessWritable  = "writable"   // Clients and the admin API alike
+	AccessAdminOnly = "admin_only" // Only the admin API and editor, not clients
+	AccessReadOnly  = "read_only"  // Neither clients nor the admin API
+)
+
 // Config holds the application configuration
 type Config struct {
-	RootDir       string
-	Port          int
-	ProxyURL      *url.URL
-	InsecureProxy bool
-	TLS           TLSConfig
-	CORS          CORSConfig
+	RootDir           string
+	Port              int
+	HashAlgorithm     string
+	CompressResponses bool              // Compress regular responses with gzip or deflate
+	Headers           map[string]string // Static headers added to every response
+	IndexName         string            // Name of the mock file directory paths resolve to, without the .braid suffix
+	TrailingSlash     string            // How trailing slashes in request paths are handled
+	CaseInsensitive   bool              // Match request paths to mock files regardless of case
+	JSONFixtures      bool              // Also serve <name>.json files as JSON resources
+	Ignore            []string          // Glob patterns of files and directories that aren't watched or served
+	HideDotfiles      bool              // Don't watch or serve files and directories whose names start with a dot
+	StrictPaths       bool              // Log requests for paths outside the mock directories or hidden by the server
+	WatchPoll         bool              // Detect file changes by polling instead of file system events
+	PollInterval      int               // Polling interval in milliseconds
+	CacheResources    bool              // Keep resources in memory instead of reading them on every request
+	Stateful          bool              // Let clients change resources, e.g. POST items to collections
+	IndexPath         string            // Path of the JSON listing of all resources
+	TUI               bool              // Show the terminal UI instead of the log, set with the -tui flag
+	TraceWire         bool              // Log every byte written to subscriptions and when it is flushed
+	PrintDiffs        bool              // Log the patches of every change to a resource, colored on terminals
+	ProxyURL          *url.URL
+	InsecureProxy     bool
+	TLS               TLSConfig
+	CORS              CORSConfig
+	Admin             AdminConfig
+	Auth              AuthConfig
+	Braid             BraidConfig
+	WebSocket         WebSocketConfig
+	Webhooks          WebhooksConfig
+	Git               GitConfig
+	Templates         TemplatesConfig
+	Sessions          SessionsConfig
+	Tombstones        TombstonesConfig
+	NATS              NATSConfig
+	MQTT              MQTTConfig
+	Redis             RedisConfig
+	Bucket            BucketConfig
+	Limits            LimitsConfig
+	Errors            ErrorsConfig
+	Mounts            []MountConfig
+	Hosts             []HostConfig
+	Resources         []ResourceConfig
+	Schedules         []ScheduleConfig
 }
 
 // ParseFlags parses command line flags and merges with config file
@@ -44,6 +395,11 @@ func ParseFlags() (*Config, error) {
 	// Simple flags for overriding config file
 	dirFlag := flag.String("d", "", "Directory containing .braid mock files (overrides config)")
 	portFlag := flag.Int("p", 0, "Port to listen on (overrides config)")
+	watchPollFlag := flag.Bool("watch-poll", false, "Poll for file changes instead of using file system events (overrides config)")
+	traceWireFlag := flag.Bool("trace-wire", false, "Log every byte written to subscriptions and when it is flushed (overrides config)")
+	printDiffsFlag := flag.Bool("print-diffs", false, "Print the patches of every change to a resource to the console (overrides config)")
+	strictPathsFlag := flag.Bool("strict-paths", false, "Log requests for paths outside the mock directories or hidden by the server (overrides config)")
+	tuiFlag := flag.Bool("tui", false, "Show a terminal UI of resources, subscribers and updates instead of the log")
 
 	// Parse flags
 	flag.Parse()
@@ -76,5 +432,19 @@ func ParseFlags() (*Config, error) {
 		config.Port = *portFlag
 	}
 
+	if *watchPollFlag {
+		config.WatchPoll = true
+	}
+	if *traceWireFlag {
+		config.TraceWire = true
+	}
+	if *printDiffsFlag {
+		config.PrintDiffs = true
+	}
+	if *strictPathsFlag {
+		config.StrictPaths = true
+	}
+	config.TUI = *tuiFlag
+
 	return config, nil
 }
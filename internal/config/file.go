@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,8 +13,25 @@ import (
 // FileConfig represents the structure of the configuration file
 type FileConfig struct {
 	Server struct {
-		Port    int    `yaml:"port"`
-		RootDir string `yaml:"root_dir"`
+		Port              int               `yaml:"port"`
+		RootDir           string            `yaml:"root_dir"`
+		HashAlgorithm     string            `yaml:"hash_algorithm"`
+		CompressResponses bool              `yaml:"compress_responses"`
+		Headers           map[string]string `yaml:"headers"`
+		IndexName         string            `yaml:"index_name"`
+		TrailingSlash     string            `yaml:"trailing_slash"`
+		CaseInsensitive   bool              `yaml:"case_insensitive"`
+		JSONFixtures      bool              `yaml:"json_fixtures"`
+		Ignore            []string          `yaml:"ignore"`
+		HideDotfiles      bool              `yaml:"hide_dotfiles"`
+		StrictPaths       bool              `yaml:"strict_paths"`
+		WatchPoll         bool              `yaml:"watch_poll"`
+		PollInterval      int               `yaml:"poll_interval"`
+		CacheResources    bool              `yaml:"cache_resources"`
+		Stateful          bool              `yaml:"stateful"`
+		IndexPath         string            `yaml:"index_path"`
+		TraceWire         bool              `yaml:"trace_wire"`
+		PrintDiffs        bool              `yaml:"print_diffs"`
 	} `yaml:"server"`
 
 	Proxy struct {
@@ -21,10 +40,20 @@ type FileConfig struct {
 	} `yaml:"proxy"`
 
 	TLS struct {
-		Enabled      bool   `yaml:"enabled"`
-		CertFile     string `yaml:"cert_file"`
-		KeyFile      string `yaml:"key_file"`
-		GenerateCert bool   `yaml:"generate_cert"`
+		Enabled         bool     `yaml:"enabled"`
+		CertFile        string   `yaml:"cert_file"`
+		KeyFile         string   `yaml:"key_file"`
+		GenerateCert    bool     `yaml:"generate_cert"`
+		ClientCAFile    string   `yaml:"client_ca_file"`
+		AllowedSubjects []string `yaml:"allowed_subjects"`
+		DNSNames        []string `yaml:"dns_names"`
+		IPAddresses     []string `yaml:"ip_addresses"`
+		ValidityDays    int      `yaml:"validity_days"`
+		KeyType         string   `yaml:"key_type"`
+		Port            int      `yaml:"port"`
+		RedirectHTTP    bool     `yaml:"redirect_http"`
+		DisableHTTP2    bool     `yaml:"disable_http2"`
+		HTTP3           bool     `yaml:"http3"`
 	} `yaml:"tls"`
 
 	CORS struct {
@@ -34,7 +63,196 @@ type FileConfig struct {
 		AllowHeaders     string `yaml:"allow_headers"`
 		AllowCredentials bool   `yaml:"allow_credentials"`
 		MaxAge           int    `yaml:"max_age"`
+		Rules            []struct {
+			Path             string `yaml:"path"`
+			AllowOrigins     string `yaml:"allow_origins"`
+			AllowMethods     string `yaml:"allow_methods"`
+			AllowHeaders     string `yaml:"allow_headers"`
+			AllowCredentials *bool  `yaml:"allow_credentials"`
+			MaxAge           int    `yaml:"max_age"`
+		} `yaml:"rules"`
 	} `yaml:"cors"`
+
+	Admin struct {
+		Enabled      bool   `yaml:"enabled"`
+		Prefix       string `yaml:"prefix"`
+		UIPath       string `yaml:"ui_path"`
+		EditPath     string `yaml:"edit_path"`
+		HAREntries   int    `yaml:"har_entries"`
+		AuditEntries int    `yaml:"audit_entries"`
+		AuditFile    string `yaml:"audit_file"`
+	} `yaml:"admin"`
+
+	Auth struct {
+		Scope        string            `yaml:"scope"`
+		Users        map[string]string `yaml:"users"`
+		APIKeys      []string          `yaml:"api_keys"`
+		APIKeyHeader string            `yaml:"api_key_header"`
+		RulesFile    string            `yaml:"rules_file"`
+		JWT          struct {
+			Secret   string `yaml:"secret"`
+			JWKSURL  string `yaml:"jwks_url"`
+			Issuer   string `yaml:"issuer"`
+			Audience string `yaml:"audience"`
+		} `yaml:"jwt"`
+	} `yaml:"auth"`
+
+	Braid struct {
+		UnknownVersion string            `yaml:"unknown_version"`
+		SSE            bool              `yaml:"sse"`
+		HistorySize    int               `yaml:"history_size"`
+		HistoryBytes   int               `yaml:"history_bytes"`
+		StateFile      string            `yaml:"state_file"`
+		MergeType      string            `yaml:"merge_type"`
+		ContentTypes   map[string]string `yaml:"content_types"`
+		SnapshotOnly   bool              `yaml:"snapshot_only"`
+		PatchFormat    string            `yaml:"patch_format"`
+		Diff           struct {
+			LCS         bool `yaml:"lcs"`
+			Invertible  bool `yaml:"invertible"`
+			Equivalent  bool `yaml:"equivalent"`
+			Rationalize bool `yaml:"rationalize"`
+		} `yaml:"diff"`
+		Compression struct {
+			Enabled bool `yaml:"enabled"`
+			MinSize int  `yaml:"min_size"`
+		} `yaml:"compression"`
+		LargeSize    int `yaml:"large_size"`
+		Backpressure struct {
+			Policy     string `yaml:"policy"`
+			BufferSize int    `yaml:"buffer_size"`
+		} `yaml:"backpressure"`
+		Subscriptions struct {
+			MaxDuration    int `yaml:"max_duration"`
+			IdleTimeout    int `yaml:"idle_timeout"`
+			MaxTotal       int `yaml:"max_total"`
+			MaxPerResource int `yaml:"max_per_resource"`
+			RetryAfter     int `yaml:"retry_after"`
+		} `yaml:"subscriptions"`
+		Chaos struct {
+			TerminatePercent int `yaml:"terminate_percent"`
+			FlushDelay       int `yaml:"flush_delay"`
+		} `yaml:"chaos"`
+		Flush struct {
+			Mode  string `yaml:"mode"`
+			Bytes int    `yaml:"bytes"`
+			Delay int    `yaml:"delay"`
+		} `yaml:"flush"`
+	} `yaml:"braid"`
+
+	WebSocket struct {
+		Enabled bool   `yaml:"enabled"`
+		Path    string `yaml:"path"`
+	} `yaml:"websocket"`
+
+	Webhooks struct {
+		URLs    []string `yaml:"urls"`
+		Retries int      `yaml:"retries"`
+		Timeout int      `yaml:"timeout"`
+	} `yaml:"webhooks"`
+
+	Git struct {
+		Enabled        bool `yaml:"enabled"`
+		ReplayInterval int  `yaml:"replay_interval"`
+	} `yaml:"git"`
+
+	Templates struct {
+		Seed         uint64 `yaml:"seed"`
+		ClientHeader string `yaml:"client_header"`
+		ClientCookie string `yaml:"client_cookie"`
+	} `yaml:"templates"`
+
+	Sessions struct {
+		Enabled bool   `yaml:"enabled"`
+		Header  string `yaml:"header"`
+		Cookie  string `yaml:"cookie"`
+	} `yaml:"sessions"`
+
+	Tombstones struct {
+		Enabled bool   `yaml:"enabled"`
+		Body    string `yaml:"body"`
+	} `yaml:"tombstones"`
+
+	NATS struct {
+		URL            string `yaml:"url"`
+		SubjectPrefix  string `yaml:"subject_prefix"`
+		ClusterSubject string `yaml:"cluster_subject"`
+	} `yaml:"nats"`
+
+	MQTT struct {
+		Broker      string `yaml:"broker"`
+		TopicPrefix string `yaml:"topic_prefix"`
+		ClientID    string `yaml:"client_id"`
+		QoS         int    `yaml:"qos"`
+	} `yaml:"mqtt"`
+
+	Redis struct {
+		URL    string `yaml:"url"`
+		Prefix string `yaml:"prefix"`
+	} `yaml:"redis"`
+
+	Bucket struct {
+		URL          string `yaml:"url"`
+		Endpoint     string `yaml:"endpoint"`
+		Region       string `yaml:"region"`
+		AccessKey    string `yaml:"access_key"`
+		SecretKey    string `yaml:"secret_key"`
+		Insecure     bool   `yaml:"insecure"`
+		PollInterval int    `yaml:"poll_interval"`
+	} `yaml:"bucket"`
+
+	Limits struct {
+		MaxBodySize       int64 `yaml:"max_body_size"`
+		MaxHeaderSize     int   `yaml:"max_header_size"`
+		MaxURLLength      int   `yaml:"max_url_length"`
+		ReadHeaderTimeout int   `yaml:"read_header_timeout"`
+		ReadTimeout       int   `yaml:"read_timeout"`
+		IdleTimeout       int   `yaml:"idle_timeout"`
+	} `yaml:"limits"`
+
+	Errors struct {
+		NotFound    string `yaml:"not_found"`
+		ServerError string `yaml:"server_error"`
+	} `yaml:"errors"`
+
+	Mounts []struct {
+		Prefix string `yaml:"prefix"`
+		Dir    string `yaml:"dir"`
+	} `yaml:"mounts"`
+
+	Hosts []struct {
+		Host    string `yaml:"host"`
+		RootDir string `yaml:"root_dir"`
+	} `yaml:"hosts"`
+
+	Resources []struct {
+		Path         string `yaml:"path"`
+		MergeType    string `yaml:"merge_type"`
+		ContentType  string `yaml:"content_type"`
+		SnapshotOnly bool   `yaml:"snapshot_only"`
+		PatchFormat  string `yaml:"patch_format"`
+		Template     bool   `yaml:"template"`
+		PageSize     int    `yaml:"page_size"`
+		PageEnvelope bool   `yaml:"page_envelope"`
+		Schema       string `yaml:"schema"`
+		Script       string `yaml:"script"`
+		Access       string `yaml:"access"`
+	} `yaml:"resources"`
+
+	Schedules []struct {
+		Resource string                 `yaml:"resource"`
+		Interval int                    `yaml:"interval"`
+		Files    []string               `yaml:"files"`
+		Set      map[string]interface{} `yaml:"set"`
+		Mutate   map[string]struct {
+			Walk      float64       `yaml:"walk"`
+			Min       *float64      `yaml:"min"`
+			Max       *float64      `yaml:"max"`
+			Decimals  *int          `yaml:"decimals"`
+			Pick      []interface{} `yaml:"pick"`
+			Increment float64       `yaml:"increment"`
+		} `yaml:"mutate"`
+	} `yaml:"schedules"`
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -43,21 +261,107 @@ func LoadConfig(filePath string) (*Config, error) {
 	config := &Config{
 		RootDir:       ".",
 		Port:          3000,
+		HashAlgorithm: "sha256",
+		IndexName:     "index",
+		TrailingSlash: TrailingSlashIgnore,
+		Ignore:        []string{".git", "node_modules"},
+		PollInterval:  1000,
+		IndexPath:     "/__index",
 		InsecureProxy: false,
 		TLS: TLSConfig{
 			Enabled:      false,
 			CertFile:     "cert/cert.pem",
 			KeyFile:      "cert/key.pem",
 			GenerateCert: false,
+			ValidityDays: 365,
+			KeyType:      "rsa",
 		},
 		CORS: CORSConfig{
 			Enabled:          false,
-			AllowOrigins:     "*",
+			AllowOrigins:     []string{"*"},
 			AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS, PATCH",
 			AllowHeaders:     "Content-Type, Authorization, Subscribe, Version, Parents",
 			AllowCredentials: false,
 			MaxAge:           86400,
 		},
+		Admin: AdminConfig{
+			Enabled:      false,
+			Prefix:       "/__admin",
+			UIPath:       "/__ui",
+			EditPath:     "/__edit",
+			HAREntries:   1000,
+			AuditEntries: 1000,
+		},
+		Auth: AuthConfig{
+			Scope:        AuthScopeAll,
+			APIKeyHeader: "X-API-Key",
+		},
+		Braid: BraidConfig{
+			UnknownVersion: UnknownVersionError,
+			SSE:            false,
+			HistorySize:    100,
+			HistoryBytes:   64 << 20,
+			PatchFormat:    PatchFormatOperations,
+			Compression: CompressionConfig{
+				MinSize: 1024,
+			},
+			LargeSize: 16 << 20,
+			Backpressure: BackpressureConfig{
+				Policy:     BackpressureBuffer,
+				BufferSize: 64,
+			},
+			Subscriptions: SubscriptionsConfig{
+				RetryAfter: 5,
+			},
+			Flush: FlushConfig{
+				Mode:  FlushIdle,
+				Bytes: 4096,
+			},
+		},
+		WebSocket: WebSocketConfig{
+			Enabled: false,
+			Path:    "/ws",
+		},
+		Webhooks: WebhooksConfig{
+			Retries: 3,
+			Timeout: 5,
+		},
+		Git: GitConfig{
+			ReplayInterval: 1000,
+		},
+		Templates: TemplatesConfig{
+			ClientHeader: "X-Client-ID",
+			ClientCookie: "client_id",
+		},
+		Sessions: SessionsConfig{
+			Header: "X-Session-ID",
+			Cookie: "session_id",
+		},
+		Tombstones: TombstonesConfig{
+			Body: `{"error": "Resource deleted"}`,
+		},
+		NATS: NATSConfig{
+			SubjectPrefix: "braidmock",
+		},
+		MQTT: MQTTConfig{
+			TopicPrefix: "braidmock",
+			ClientID:    "braidmock",
+			QoS:         0,
+		},
+		Redis: RedisConfig{
+			Prefix: "braidmock",
+		},
+		Bucket: BucketConfig{
+			PollInterval: 10,
+		},
+		Limits: LimitsConfig{
+			MaxBodySize:       16 << 20,
+			MaxHeaderSize:     1 << 20,
+			MaxURLLength:      8192,
+			ReadHeaderTimeout: 10,
+			ReadTimeout:       60,
+			IdleTimeout:       120,
+		},
 	}
 
 	// If no config file specified, return default config
@@ -84,6 +388,53 @@ func LoadConfig(filePath string) (*Config, error) {
 	if fileConfig.Server.RootDir != "" {
 		config.RootDir = fileConfig.Server.RootDir
 	}
+	if fileConfig.Server.HashAlgorithm != "" {
+		config.HashAlgorithm = fileConfig.Server.HashAlgorithm
+	}
+	config.CompressResponses = fileConfig.Server.CompressResponses
+	for name := range fileConfig.Server.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid response header name: %q", name)
+		}
+	}
+	config.Headers = fileConfig.Server.Headers
+	if fileConfig.Server.IndexName != "" {
+		if strings.ContainsAny(fileConfig.Server.IndexName, "/\\") {
+			return nil, fmt.Errorf("invalid index_name: %s", fileConfig.Server.IndexName)
+		}
+		config.IndexName = fileConfig.Server.IndexName
+	}
+	config.CaseInsensitive = fileConfig.Server.CaseInsensitive
+	config.JSONFixtures = fileConfig.Server.JSONFixtures
+	if len(fileConfig.Server.Ignore) > 0 {
+		config.Ignore = fileConfig.Server.Ignore
+	}
+	config.HideDotfiles = fileConfig.Server.HideDotfiles
+	config.StrictPaths = fileConfig.Server.StrictPaths
+	config.WatchPoll = fileConfig.Server.WatchPoll
+	config.CacheResources = fileConfig.Server.CacheResources
+	config.Stateful = fileConfig.Server.Stateful
+	config.TraceWire = fileConfig.Server.TraceWire
+	config.PrintDiffs = fileConfig.Server.PrintDiffs
+	if fileConfig.Server.IndexPath != "" {
+		if !strings.HasPrefix(fileConfig.Server.IndexPath, "/") {
+			return nil, fmt.Errorf("invalid index_path: %s", fileConfig.Server.IndexPath)
+		}
+		config.IndexPath = fileConfig.Server.IndexPath
+	}
+	if fileConfig.Server.PollInterval < 0 {
+		return nil, fmt.Errorf("invalid poll_interval: %d", fileConfig.Server.PollInterval)
+	}
+	if fileConfig.Server.PollInterval != 0 {
+		config.PollInterval = fileConfig.Server.PollInterval
+	}
+	switch fileConfig.Server.TrailingSlash {
+	case "":
+	case TrailingSlashIgnore, TrailingSlashStrict, TrailingSlashRedirect:
+		config.TrailingSlash = fileConfig.Server.TrailingSlash
+	default:
+		return nil, fmt.Errorf("invalid trailing_slash: %s", fileConfig.Server.TrailingSlash)
+	}
 
 	// Proxy settings
 	if fileConfig.Proxy.URL != "" {
@@ -104,11 +455,31 @@ func LoadConfig(filePath string) (*Config, error) {
 		config.TLS.KeyFile = fileConfig.TLS.KeyFile
 	}
 	config.TLS.GenerateCert = fileConfig.TLS.GenerateCert
+	config.TLS.ClientCAFile = fileConfig.TLS.ClientCAFile
+	config.TLS.AllowedSubjects = fileConfig.TLS.AllowedSubjects
+	config.TLS.DNSNames = fileConfig.TLS.DNSNames
+	config.TLS.IPAddresses = fileConfig.TLS.IPAddresses
+	if fileConfig.TLS.ValidityDays != 0 {
+		config.TLS.ValidityDays = fileConfig.TLS.ValidityDays
+	}
+	if fileConfig.TLS.KeyType != "" {
+		config.TLS.KeyType = fileConfig.TLS.KeyType
+	}
+	if fileConfig.TLS.Port != 0 {
+		config.TLS.Port = fileConfig.TLS.Port
+	}
+	config.TLS.RedirectHTTP = fileConfig.TLS.RedirectHTTP
+	config.TLS.DisableHTTP2 = fileConfig.TLS.DisableHTTP2
+	config.TLS.HTTP3 = fileConfig.TLS.HTTP3
 
 	// CORS settings
 	config.CORS.Enabled = fileConfig.CORS.Enabled
 	if fileConfig.CORS.AllowOrigins != "" {
-		config.CORS.AllowOrigins = fileConfig.CORS.AllowOrigins
+		origins, err := parseOriginPatterns(fileConfig.CORS.AllowOrigins)
+		if err != nil {
+			return nil, err
+		}
+		config.CORS.AllowOrigins = origins
 	}
 	if fileConfig.CORS.AllowMethods != "" {
 		config.CORS.AllowMethods = fileConfig.CORS.AllowMethods
@@ -120,10 +491,442 @@ func LoadConfig(filePath string) (*Config, error) {
 	if fileConfig.CORS.MaxAge != 0 {
 		config.CORS.MaxAge = fileConfig.CORS.MaxAge
 	}
+	for _, rule := range fileConfig.CORS.Rules {
+		if rule.Path != "" && !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("invalid CORS rule path: %q", rule.Path)
+		}
+		if rule.MaxAge < 0 {
+			return nil, fmt.Errorf("invalid max_age for CORS rule %s: %d", rule.Path, rule.MaxAge)
+		}
+		origins, err := parseOriginPatterns(rule.AllowOrigins)
+		if err != nil {
+			return nil, err
+		}
+		config.CORS.Rules = append(config.CORS.Rules, CORSRule{
+			Path:             strings.TrimSuffix(rule.Path, "*"),
+			AllowOrigins:     origins,
+			AllowMethods:     rule.AllowMethods,
+			AllowHeaders:     rule.AllowHeaders,
+			AllowCredentials: rule.AllowCredentials,
+			MaxAge:           rule.MaxAge,
+		})
+	}
+
+	// Admin settings
+	config.Admin.Enabled = fileConfig.Admin.Enabled
+	if fileConfig.Admin.Prefix != "" {
+		config.Admin.Prefix = fileConfig.Admin.Prefix
+	}
+	if fileConfig.Admin.UIPath != "" {
+		config.Admin.UIPath = fileConfig.Admin.UIPath
+	}
+	if fileConfig.Admin.EditPath != "" {
+		config.Admin.EditPath = fileConfig.Admin.EditPath
+	}
+	if fileConfig.Admin.HAREntries != 0 {
+		config.Admin.HAREntries = fileConfig.Admin.HAREntries
+	}
+	if fileConfig.Admin.AuditEntries != 0 {
+		config.Admin.AuditEntries = fileConfig.Admin.AuditEntries
+	}
+	config.Admin.AuditFile = fileConfig.Admin.AuditFile
+
+	// Authentication settings, with credentials expanded from the environment
+	switch fileConfig.Auth.Scope {
+	case "":
+	case AuthScopeAll, AuthScopeMock, AuthScopeAdmin:
+		config.Auth.Scope = fileConfig.Auth.Scope
+	default:
+		return nil, fmt.Errorf("invalid auth scope: %s", fileConfig.Auth.Scope)
+	}
+	if len(fileConfig.Auth.Users) > 0 {
+		config.Auth.Users = make(map[string]string)
+		for user, password := range fileConfig.Auth.Users {
+			if password = os.ExpandEnv(password); password != "" {
+				config.Auth.Users[user] = password
+			}
+		}
+	}
+	for _, key := range fileConfig.Auth.APIKeys {
+		if key = os.ExpandEnv(key); key != "" {
+			config.Auth.APIKeys = append(config.Auth.APIKeys, key)
+		}
+	}
+	if fileConfig.Auth.APIKeyHeader != "" {
+		config.Auth.APIKeyHeader = fileConfig.Auth.APIKeyHeader
+	}
+	config.Auth.RulesFile = fileConfig.Auth.RulesFile
+	config.Auth.JWT.Secret = os.ExpandEnv(fileConfig.Auth.JWT.Secret)
+	config.Auth.JWT.JWKSURL = fileConfig.Auth.JWT.JWKSURL
+	config.Auth.JWT.Issuer = fileConfig.Auth.JWT.Issuer
+	config.Auth.JWT.Audience = fileConfig.Auth.JWT.Audience
+
+	// Braid protocol settings
+	switch fileConfig.Braid.UnknownVersion {
+	case "":
+	case UnknownVersionError, UnknownVersionSnapshot:
+		config.Braid.UnknownVersion = fileConfig.Braid.UnknownVersion
+	default:
+		return nil, fmt.Errorf("invalid unknown_version: %s", fileConfig.Braid.UnknownVersion)
+	}
+	config.Braid.SSE = fileConfig.Braid.SSE
+	if fileConfig.Braid.HistorySize != 0 {
+		config.Braid.HistorySize = fileConfig.Braid.HistorySize
+	}
+	if fileConfig.Braid.HistoryBytes != 0 {
+		config.Braid.HistoryBytes = fileConfig.Braid.HistoryBytes
+	}
+	config.Braid.StateFile = fileConfig.Braid.StateFile
+	if fileConfig.Braid.LargeSize != 0 {
+		config.Braid.LargeSize = fileConfig.Braid.LargeSize
+	}
+	config.Braid.MergeType = fileConfig.Braid.MergeType
+	config.Braid.ContentTypes = fileConfig.Braid.ContentTypes
+	config.Braid.SnapshotOnly = fileConfig.Braid.SnapshotOnly
+	if fileConfig.Braid.PatchFormat != "" {
+		if !IsPatchFormat(fileConfig.Braid.PatchFormat) {
+			return nil, fmt.Errorf("invalid patch_format: %s", fileConfig.Braid.PatchFormat)
+		}
+		config.Braid.PatchFormat = fileConfig.Braid.PatchFormat
+	}
+	config.Braid.Diff.LCS = fileConfig.Braid.Diff.LCS
+	config.Braid.Diff.Invertible = fileConfig.Braid.Diff.Invertible
+	config.Braid.Diff.Equivalent = fileConfig.Braid.Diff.Equivalent
+	config.Braid.Diff.Rationalize = fileConfig.Braid.Diff.Rationalize
+	config.Braid.Compression.Enabled = fileConfig.Braid.Compression.Enabled
+	if fileConfig.Braid.Compression.MinSize != 0 {
+		config.Braid.Compression.MinSize = fileConfig.Braid.Compression.MinSize
+	}
+	switch fileConfig.Braid.Backpressure.Policy {
+	case "":
+	case BackpressureBuffer, BackpressureCoalesce, BackpressureDisconnect:
+		config.Braid.Backpressure.Policy = fileConfig.Braid.Backpressure.Policy
+	default:
+		return nil, fmt.Errorf("invalid backpressure policy: %s", fileConfig.Braid.Backpressure.Policy)
+	}
+	if fileConfig.Braid.Backpressure.BufferSize != 0 {
+		if fileConfig.Braid.Backpressure.BufferSize < 0 {
+			return nil, fmt.Errorf("invalid backpressure buffer_size: %d", fileConfig.Braid.Backpressure.BufferSize)
+		}
+		config.Braid.Backpressure.BufferSize = fileConfig.Braid.Backpressure.BufferSize
+	}
+	if fileConfig.Braid.Subscriptions.MaxDuration < 0 {
+		return nil, fmt.Errorf("invalid subscriptions max_duration: %d", fileConfig.Braid.Subscriptions.MaxDuration)
+	}
+	config.Braid.Subscriptions.MaxDuration = fileConfig.Braid.Subscriptions.MaxDuration
+	if fileConfig.Braid.Subscriptions.IdleTimeout < 0 {
+		return nil, fmt.Errorf("invalid subscriptions idle_timeout: %d", fileConfig.Braid.Subscriptions.IdleTimeout)
+	}
+	config.Braid.Subscriptions.IdleTimeout = fileConfig.Braid.Subscriptions.IdleTimeout
+	if fileConfig.Braid.Subscriptions.MaxTotal < 0 {
+		return nil, fmt.Errorf("invalid subscriptions max_total: %d", fileConfig.Braid.Subscriptions.MaxTotal)
+	}
+	config.Braid.Subscriptions.MaxTotal = fileConfig.Braid.Subscriptions.MaxTotal
+	if fileConfig.Braid.Subscriptions.MaxPerResource < 0 {
+		return nil, fmt.Errorf("invalid subscriptions max_per_resource: %d", fileConfig.Braid.Subscriptions.MaxPerResource)
+	}
+	config.Braid.Subscriptions.MaxPerResource = fileConfig.Braid.Subscriptions.MaxPerResource
+	if fileConfig.Braid.Subscriptions.RetryAfter != 0 {
+		if fileConfig.Braid.Subscriptions.RetryAfter < 0 {
+			return nil, fmt.Errorf("invalid subscriptions retry_after: %d", fileConfig.Braid.Subscriptions.RetryAfter)
+		}
+		config.Braid.Subscriptions.RetryAfter = fileConfig.Braid.Subscriptions.RetryAfter
+	}
+	if fileConfig.Braid.Chaos.TerminatePercent < 0 || fileConfig.Braid.Chaos.TerminatePercent > 100 {
+		return nil, fmt.Errorf("invalid chaos terminate_percent: %d", fileConfig.Braid.Chaos.TerminatePercent)
+	}
+	config.Braid.Chaos.TerminatePercent = fileConfig.Braid.Chaos.TerminatePercent
+	if fileConfig.Braid.Chaos.FlushDelay < 0 {
+		return nil, fmt.Errorf("invalid chaos flush_delay: %d", fileConfig.Braid.Chaos.FlushDelay)
+	}
+	config.Braid.Chaos.FlushDelay = fileConfig.Braid.Chaos.FlushDelay
+	switch fileConfig.Braid.Flush.Mode {
+	case "":
+	case FlushIdle, FlushFrame, FlushBytes, FlushDelay:
+		config.Braid.Flush.Mode = fileConfig.Braid.Flush.Mode
+	default:
+		return nil, fmt.Errorf("invalid flush mode: %s", fileConfig.Braid.Flush.Mode)
+	}
+	if fileConfig.Braid.Flush.Bytes != 0 {
+		if fileConfig.Braid.Flush.Bytes < 0 {
+			return nil, fmt.Errorf("invalid flush bytes: %d", fileConfig.Braid.Flush.Bytes)
+		}
+		config.Braid.Flush.Bytes = fileConfig.Braid.Flush.Bytes
+	}
+	if fileConfig.Braid.Flush.Delay < 0 {
+		return nil, fmt.Errorf("invalid flush delay: %d", fileConfig.Braid.Flush.Delay)
+	}
+	config.Braid.Flush.Delay = fileConfig.Braid.Flush.Delay
+
+	// WebSocket settings
+	config.WebSocket.Enabled = fileConfig.WebSocket.Enabled
+	if fileConfig.WebSocket.Path != "" {
+		config.WebSocket.Path = fileConfig.WebSocket.Path
+	}
+
+	// Webhook settings
+	config.Webhooks.URLs = fileConfig.Webhooks.URLs
+	if fileConfig.Webhooks.Retries != 0 {
+		config.Webhooks.Retries = fileConfig.Webhooks.Retries
+	}
+	if fileConfig.Webhooks.Timeout != 0 {
+		config.Webhooks.Timeout = fileConfig.Webhooks.Timeout
+	}
+
+	// Git time travel settings
+	config.Git.Enabled = fileConfig.Git.Enabled
+	if fileConfig.Git.ReplayInterval < 0 {
+		return nil, fmt.Errorf("invalid git replay_interval: %d", fileConfig.Git.ReplayInterval)
+	}
+	if fileConfig.Git.ReplayInterval != 0 {
+		config.Git.ReplayInterval = fileConfig.Git.ReplayInterval
+	}
+
+	// Template settings
+	config.Templates.Seed = fileConfig.Templates.Seed
+	if fileConfig.Templates.ClientHeader != "" {
+		config.Templates.ClientHeader = fileConfig.Templates.ClientHeader
+	}
+	if fileConfig.Templates.ClientCookie != "" {
+		config.Templates.ClientCookie = fileConfig.Templates.ClientCookie
+	}
+
+	// Session settings
+	config.Sessions.Enabled = fileConfig.Sessions.Enabled
+	if fileConfig.Sessions.Header != "" {
+		config.Sessions.Header = fileConfig.Sessions.Header
+	}
+	if fileConfig.Sessions.Cookie != "" {
+		config.Sessions.Cookie = fileConfig.Sessions.Cookie
+	}
+
+	// Tombstone settings
+	config.Tombstones.Enabled = fileConfig.Tombstones.Enabled
+	if fileConfig.Tombstones.Body != "" {
+		config.Tombstones.Body = fileConfig.Tombstones.Body
+	}
+
+	// NATS settings
+	config.NATS.URL = fileConfig.NATS.URL
+	if fileConfig.NATS.SubjectPrefix != "" {
+		config.NATS.SubjectPrefix = fileConfig.NATS.SubjectPrefix
+	}
+	if fileConfig.NATS.ClusterSubject != "" && fileConfig.NATS.URL == "" {
+		return nil, fmt.Errorf("invalid cluster_subject: NATS url is required")
+	}
+	config.NATS.ClusterSubject = fileConfig.NATS.ClusterSubject
+
+	// MQTT settings
+	config.MQTT.Broker = fileConfig.MQTT.Broker
+	if fileConfig.MQTT.TopicPrefix != "" {
+		config.MQTT.TopicPrefix = fileConfig.MQTT.TopicPrefix
+	}
+	if fileConfig.MQTT.ClientID != "" {
+		config.MQTT.ClientID = fileConfig.MQTT.ClientID
+	}
+	if fileConfig.MQTT.QoS < 0 || fileConfig.MQTT.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS: %d", fileConfig.MQTT.QoS)
+	}
+	config.MQTT.QoS = fileConfig.MQTT.QoS
+
+	// Redis settings
+	if fileConfig.Redis.URL != "" && config.NATS.ClusterSubject != "" {
+		return nil, fmt.Errorf("invalid redis url: instances share changes over NATS already")
+	}
+	config.Redis.URL = fileConfig.Redis.URL
+	if fileConfig.Redis.Prefix != "" {
+		config.Redis.Prefix = fileConfig.Redis.Prefix
+	}
+
+	// Bucket settings
+	if fileConfig.Bucket.URL != "" {
+		u, err := url.Parse(fileConfig.Bucket.URL)
+		if err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+			return nil, fmt.Errorf("invalid bucket url: %s", fileConfig.Bucket.URL)
+		}
+	}
+	config.Bucket.URL = fileConfig.Bucket.URL
+	config.Bucket.Endpoint = fileConfig.Bucket.Endpoint
+	config.Bucket.Region = fileConfig.Bucket.Region
+	config.Bucket.AccessKey = fileConfig.Bucket.AccessKey
+	config.Bucket.SecretKey = fileConfig.Bucket.SecretKey
+	config.Bucket.Insecure = fileConfig.Bucket.Insecure
+	if fileConfig.Bucket.PollInterval < 0 {
+		return nil, fmt.Errorf("invalid bucket poll_interval: %d", fileConfig.Bucket.PollInterval)
+	}
+	if fileConfig.Bucket.PollInterval != 0 {
+		config.Bucket.PollInterval = fileConfig.Bucket.PollInterval
+	}
+
+	// Request limits, where -1 disables a limit
+	if fileConfig.Limits.MaxBodySize != 0 {
+		if fileConfig.Limits.MaxBodySize < -1 {
+			return nil, fmt.Errorf("invalid limits max_body_size: %d", fileConfig.Limits.MaxBodySize)
+		}
+		config.Limits.MaxBodySize = fileConfig.Limits.MaxBodySize
+	}
+	if fileConfig.Limits.MaxHeaderSize != 0 {
+		if fileConfig.Limits.MaxHeaderSize < -1 {
+			return nil, fmt.Errorf("invalid limits max_header_size: %d", fileConfig.Limits.MaxHeaderSize)
+		}
+		config.Limits.MaxHeaderSize = fileConfig.Limits.MaxHeaderSize
+	}
+	if fileConfig.Limits.MaxURLLength != 0 {
+		if fileConfig.Limits.MaxURLLength < -1 {
+			return nil, fmt.Errorf("invalid limits max_url_length: %d", fileConfig.Limits.MaxURLLength)
+		}
+		config.Limits.MaxURLLength = fileConfig.Limits.MaxURLLength
+	}
+	if fileConfig.Limits.ReadHeaderTimeout != 0 {
+		if fileConfig.Limits.ReadHeaderTimeout < -1 {
+			return nil, fmt.Errorf("invalid limits read_header_timeout: %d", fileConfig.Limits.ReadHeaderTimeout)
+		}
+		config.Limits.ReadHeaderTimeout = fileConfig.Limits.ReadHeaderTimeout
+	}
+	if fileConfig.Limits.ReadTimeout != 0 {
+		if fileConfig.Limits.ReadTimeout < -1 {
+			return nil, fmt.Errorf("invalid limits read_timeout: %d", fileConfig.Limits.ReadTimeout)
+		}
+		config.Limits.ReadTimeout = fileConfig.Limits.ReadTimeout
+	}
+	if fileConfig.Limits.IdleTimeout != 0 {
+		if fileConfig.Limits.IdleTimeout < -1 {
+			return nil, fmt.Errorf("invalid limits idle_timeout: %d", fileConfig.Limits.IdleTimeout)
+		}
+		config.Limits.IdleTimeout = fileConfig.Limits.IdleTimeout
+	}
+
+	// Error response bodies
+	config.Errors.NotFound = fileConfig.Errors.NotFound
+	config.Errors.ServerError = fileConfig.Errors.ServerError
+
+	// Extra mock directories
+	for _, m := range fileConfig.Mounts {
+		prefix := strings.TrimSuffix(m.Prefix, "/")
+		if !strings.HasPrefix(prefix, "/") || m.Dir == "" {
+			return nil, fmt.Errorf("invalid mount: %q -> %q", m.Prefix, m.Dir)
+		}
+		config.Mounts = append(config.Mounts, MountConfig{Prefix: prefix, Dir: m.Dir})
+	}
+
+	// Hosts served from their own root directories
+	for _, h := range fileConfig.Hosts {
+		host := strings.ToLower(h.Host)
+		if _, err := path.Match(host, ""); err != nil || host == "" || h.RootDir == "" {
+			return nil, fmt.Errorf("invalid host: %q -> %q", h.Host, h.RootDir)
+		}
+		config.Hosts = append(config.Hosts, HostConfig{Host: host, RootDir: h.RootDir})
+	}
+
+	// Per-resource settings
+	for _, resource := range fileConfig.Resources {
+		if _, err := path.Match(resource.Path, "/"); err != nil || resource.Path == "" {
+			return nil, fmt.Errorf("invalid resource path pattern: %q", resource.Path)
+		}
+		if resource.PatchFormat != "" && !IsPatchFormat(resource.PatchFormat) {
+			return nil, fmt.Errorf("invalid patch_format for %s: %s", resource.Path, resource.PatchFormat)
+		}
+		if resource.PageSize < 0 {
+			return nil, fmt.Errorf("invalid page_size for %s: %d", resource.Path, resource.PageSize)
+		}
+		switch resource.Access {
+		case "", AccessWritable, AccessAdminOnly, AccessReadOnly:
+		default:
+			return nil, fmt.Errorf("invalid access for %s: %s", resource.Path, resource.Access)
+		}
+		config.Resources = append(config.Resources, ResourceConfig{
+			Path:         resource.Path,
+			MergeType:    resource.MergeType,
+			ContentType:  resource.ContentType,
+			SnapshotOnly: resource.SnapshotOnly,
+			PatchFormat:  resource.PatchFormat,
+			Template:     resource.Template,
+			PageSize:     resource.PageSize,
+			PageEnvelope: resource.PageEnvelope,
+			Schema:       resource.Schema,
+			Script:       resource.Script,
+			Access:       resource.Access,
+		})
+	}
+
+	// Scheduled resource changes
+	for _, schedule := range fileConfig.Schedules {
+		if !strings.HasPrefix(schedule.Resource, "/") || schedule.Interval <= 0 {
+			return nil, fmt.Errorf("invalid schedule: %q every %dms", schedule.Resource, schedule.Interval)
+		}
+		if len(schedule.Files) == 0 && len(schedule.Set) == 0 && len(schedule.Mutate) == 0 {
+			return nil, fmt.Errorf("invalid schedule for %s: nothing to change", schedule.Resource)
+		}
+		for pointer := range schedule.Set {
+			if pointer != "" && !strings.HasPrefix(pointer, "/") {
+				return nil, fmt.Errorf("invalid schedule for %s: invalid JSON pointer %q", schedule.Resource, pointer)
+			}
+		}
+
+		mutations := make(map[string]MutationConfig, len(schedule.Mutate))
+		for pointer, mutation := range schedule.Mutate {
+			if pointer == "" || !strings.HasPrefix(pointer, "/") {
+				return nil, fmt.Errorf("invalid schedule for %s: invalid JSON pointer %q", schedule.Resource, pointer)
+			}
+			kinds := 0
+			for _, set := range []bool{mutation.Walk != 0, len(mutation.Pick) > 0, mutation.Increment != 0} {
+				if set {
+					kinds++
+				}
+			}
+			if kinds != 1 {
+				return nil, fmt.Errorf("invalid mutation of %s%s: expected one of walk, pick or increment", schedule.Resource, pointer)
+			}
+			if mutation.Min != nil && mutation.Max != nil && *mutation.Min > *mutation.Max {
+				return nil, fmt.Errorf("invalid mutation of %s%s: min above max", schedule.Resource, pointer)
+			}
+
+			decimals := 2
+			if mutation.Decimals != nil {
+				decimals = *mutation.Decimals
+			}
+			if decimals < 0 {
+				return nil, fmt.Errorf("invalid mutation of %s%s: decimals %d", schedule.Resource, pointer, decimals)
+			}
+			mutations[pointer] = MutationConfig{
+				Walk:      mutation.Walk,
+				Min:       mutation.Min,
+				Max:       mutation.Max,
+				Decimals:  decimals,
+				Pick:      mutation.Pick,
+				Increment: mutation.Increment,
+			}
+		}
+
+		config.Schedules = append(config.Schedules, ScheduleConfig{
+			Resource: schedule.Resource,
+			Interval: schedule.Interval,
+			Files:    schedule.Files,
+			Set:      schedule.Set,
+			Mutate:   mutations,
+		})
+	}
 
 	return config, nil
 }
 
+// parseOriginPatterns splits a comma-separated list of allowed CORS origins,
+// which may contain * wildcards
+func parseOriginPatterns(list string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if _, err := path.Match(origin, ""); err != nil {
+			return nil, fmt.Errorf("invalid CORS origin pattern: %q", origin)
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
 // SaveDefaultConfig saves a default configuration file
 func SaveDefaultConfig(filePath string) error {
 	// Create default config structure
@@ -132,6 +935,23 @@ func SaveDefaultConfig(filePath string) error {
 	// Server settings
 	fileConfig.Server.Port = 3000
 	fileConfig.Server.RootDir = "."
+	fileConfig.Server.HashAlgorithm = "sha256"
+	fileConfig.Server.CompressResponses = false
+	fileConfig.Server.Headers = map[string]string{}
+	fileConfig.Server.IndexName = "index"
+	fileConfig.Server.TrailingSlash = TrailingSlashIgnore
+	fileConfig.Server.CaseInsensitive = false
+	fileConfig.Server.JSONFixtures = false
+	fileConfig.Server.Ignore = []string{".git", "node_modules"}
+	fileConfig.Server.HideDotfiles = false
+	fileConfig.Server.StrictPaths = false
+	fileConfig.Server.WatchPoll = false
+	fileConfig.Server.PollInterval = 1000
+	fileConfig.Server.CacheResources = false
+	fileConfig.Server.Stateful = false
+	fileConfig.Server.IndexPath = "/__index"
+	fileConfig.Server.TraceWire = false
+	fileConfig.Server.PrintDiffs = false
 
 	// Proxy settings
 	fileConfig.Proxy.URL = ""
@@ -142,6 +962,16 @@ func SaveDefaultConfig(filePath string) error {
 	fileConfig.TLS.CertFile = "cert/cert.pem"
 	fileConfig.TLS.KeyFile = "cert/key.pem"
 	fileConfig.TLS.GenerateCert = false
+	fileConfig.TLS.ClientCAFile = ""
+	fileConfig.TLS.AllowedSubjects = []string{}
+	fileConfig.TLS.DNSNames = []string{}
+	fileConfig.TLS.IPAddresses = []string{}
+	fileConfig.TLS.ValidityDays = 365
+	fileConfig.TLS.KeyType = "rsa"
+	fileConfig.TLS.Port = 0
+	fileConfig.TLS.RedirectHTTP = false
+	fileConfig.TLS.DisableHTTP2 = false
+	fileConfig.TLS.HTTP3 = false
 
 	// CORS settings
 	fileConfig.CORS.Enabled = false
@@ -151,6 +981,119 @@ func SaveDefaultConfig(filePath string) error {
 	fileConfig.CORS.AllowCredentials = false
 	fileConfig.CORS.MaxAge = 86400
 
+	// Admin settings
+	fileConfig.Admin.Enabled = false
+	fileConfig.Admin.Prefix = "/__admin"
+	fileConfig.Admin.UIPath = "/__ui"
+	fileConfig.Admin.EditPath = "/__edit"
+	fileConfig.Admin.HAREntries = 1000
+	fileConfig.Admin.AuditEntries = 1000
+	fileConfig.Admin.AuditFile = ""
+
+	// Authentication settings
+	fileConfig.Auth.Scope = AuthScopeAll
+	fileConfig.Auth.Users = map[string]string{}
+	fileConfig.Auth.APIKeys = []string{}
+	fileConfig.Auth.APIKeyHeader = "X-API-Key"
+	fileConfig.Auth.RulesFile = ""
+	fileConfig.Auth.JWT.Secret = ""
+	fileConfig.Auth.JWT.JWKSURL = ""
+	fileConfig.Auth.JWT.Issuer = ""
+	fileConfig.Auth.JWT.Audience = ""
+
+	// Braid protocol settings
+	fileConfig.Braid.UnknownVersion = UnknownVersionError
+	fileConfig.Braid.SSE = false
+	fileConfig.Braid.HistorySize = 100
+	fileConfig.Braid.HistoryBytes = 64 << 20
+	fileConfig.Braid.StateFile = ""
+	fileConfig.Braid.LargeSize = 16 << 20
+	fileConfig.Braid.MergeType = ""
+	fileConfig.Braid.ContentTypes = map[string]string{}
+	fileConfig.Braid.SnapshotOnly = false
+	fileConfig.Braid.PatchFormat = PatchFormatOperations
+	fileConfig.Braid.Diff.LCS = false
+	fileConfig.Braid.Diff.Invertible = false
+	fileConfig.Braid.Diff.Equivalent = false
+	fileConfig.Braid.Diff.Rationalize = false
+	fileConfig.Braid.Compression.Enabled = false
+	fileConfig.Braid.Compression.MinSize = 1024
+	fileConfig.Braid.Backpressure.Policy = BackpressureBuffer
+	fileConfig.Braid.Backpressure.BufferSize = 64
+	fileConfig.Braid.Subscriptions.MaxDuration = 0
+	fileConfig.Braid.Subscriptions.IdleTimeout = 0
+	fileConfig.Braid.Subscriptions.MaxTotal = 0
+	fileConfig.Braid.Subscriptions.MaxPerResource = 0
+	fileConfig.Braid.Subscriptions.RetryAfter = 5
+	fileConfig.Braid.Chaos.TerminatePercent = 0
+	fileConfig.Braid.Chaos.FlushDelay = 0
+	fileConfig.Braid.Flush.Mode = FlushIdle
+	fileConfig.Braid.Flush.Bytes = 4096
+	fileConfig.Braid.Flush.Delay = 0
+
+	// WebSocket settings
+	fileConfig.WebSocket.Enabled = false
+	fileConfig.WebSocket.Path = "/ws"
+
+	// Webhook settings
+	fileConfig.Webhooks.URLs = []string{}
+	fileConfig.Webhooks.Retries = 3
+	fileConfig.Webhooks.Timeout = 5
+
+	// Git time travel settings
+	fileConfig.Git.Enabled = false
+	fileConfig.Git.ReplayInterval = 1000
+
+	// Template settings
+	fileConfig.Templates.Seed = 0
+	fileConfig.Templates.ClientHeader = "X-Client-ID"
+	fileConfig.Templates.ClientCookie = "client_id"
+
+	// Session settings
+	fileConfig.Sessions.Enabled = false
+	fileConfig.Sessions.Header = "X-Session-ID"
+	fileConfig.Sessions.Cookie = "session_id"
+
+	// Tombstone settings
+	fileConfig.Tombstones.Enabled = false
+	fileConfig.Tombstones.Body = `{"error": "Resource deleted"}`
+
+	// NATS settings
+	fileConfig.NATS.URL = ""
+	fileConfig.NATS.SubjectPrefix = "braidmock"
+	fileConfig.NATS.ClusterSubject = ""
+
+	// MQTT settings
+	fileConfig.MQTT.Broker = ""
+	fileConfig.MQTT.TopicPrefix = "braidmock"
+	fileConfig.MQTT.ClientID = "braidmock"
+	fileConfig.MQTT.QoS = 0
+
+	// Redis settings
+	fileConfig.Redis.URL = ""
+	fileConfig.Redis.Prefix = "braidmock"
+
+	// Bucket settings
+	fileConfig.Bucket.URL = ""
+	fileConfig.Bucket.Endpoint = ""
+	fileConfig.Bucket.Region = ""
+	fileConfig.Bucket.AccessKey = ""
+	fileConfig.Bucket.SecretKey = ""
+	fileConfig.Bucket.Insecure = false
+	fileConfig.Bucket.PollInterval = 10
+
+	// Request limits
+	fileConfig.Limits.MaxBodySize = 16 << 20
+	fileConfig.Limits.MaxHeaderSize = 1 << 20
+	fileConfig.Limits.MaxURLLength = 8192
+	fileConfig.Limits.ReadHeaderTimeout = 10
+	fileConfig.Limits.ReadTimeout = 60
+	fileConfig.Limits.IdleTimeout = 120
+
+	// Error response bodies
+	fileConfig.Errors.NotFound = ""
+	fileConfig.Errors.ServerError = ""
+
 	// Marshal to YAML
 	data, err := yaml.Marshal(fileConfig)
 	if err != nil {
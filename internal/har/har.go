@@ -0,0 +1,171 @@
+// Package har reads and writes HTTP Archive (HAR 1.2) files, as exported and
+// imported by browser devtools
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Version is the HAR format version written
+const Version = "1.2"
+
+// HAR is the root of an HTTP Archive
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log holds the recorded exchanges of an archive
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+// Creator names the application that wrote an archive
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is a single request and its response
+type Entry struct {
+	StartedDateTime string   `json:"startedDateTime"`
+	Time            float64  `json:"time"` // Milliseconds the exchange took
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	Cache           struct{} `json:"cache"`
+	Timings         Timings  `json:"timings"`
+}
+
+// Request describes a recorded request
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	Cookies     []NameValue `json:"cookies"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// PostData is the body of a recorded request
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Response describes a recorded response
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []NameValue `json:"headers"`
+	Cookies     []NameValue `json:"cookies"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// Content is the body of a recorded response
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for binary bodies
+}
+
+// NameValue is a header, query parameter or cookie
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Timings splits the time an exchange took into its phases, in milliseconds
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Fixture is a mock resource generated from a recorded response
+type Fixture struct {
+	Resource string // Path of the request
+	Method   string // Method of the request
+	URL      string // URL the response was recorded from
+	Body     []byte
+}
+
+// Fixtures returns a fixture for every path with a successful JSON response
+// in an archive. Of several responses for a path, the last GET response is
+// used, or else the last response to any other method.
+func Fixtures(data []byte) ([]Fixture, error) {
+	var archive HAR
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return nil, fmt.Errorf("invalid HAR file: %w", err)
+	}
+
+	byResource := make(map[string]Fixture)
+	for _, entry := range archive.Log.Entries {
+		response := entry.Response
+		if response.Status < 200 || response.Status >= 300 || !isJSON(response.Content.MimeType) {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil || u.Path == "" {
+			continue
+		}
+		body, err := decodeContent(response.Content)
+		if err != nil || !json.Valid(body) {
+			continue
+		}
+
+		if existing, ok := byResource[u.Path]; ok && existing.Method == "GET" && entry.Request.Method != "GET" {
+			continue
+		}
+		byResource[u.Path] = Fixture{Resource: u.Path, Method: entry.Request.Method, URL: entry.Request.URL, Body: indent(body)}
+	}
+
+	fixtures := make([]Fixture, 0, len(byResource))
+	for _, fixture := range byResource {
+		fixtures = append(fixtures, fixture)
+	}
+	sort.Slice(fixtures, func(i, j int) bool { return fixtures[i].Resource < fixtures[j].Resource })
+	return fixtures, nil
+}
+
+// decodeContent returns the body of a recorded response
+func decodeContent(content Content) ([]byte, error) {
+	if content.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(content.Text)
+	}
+	return []byte(content.Text), nil
+}
+
+// indent indents a JSON document for editing as a mock file
+func indent(body []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return body
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// isJSON reports whether a media type is JSON
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
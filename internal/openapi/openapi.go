@@ -0,0 +1,391 @@
+// Package openapi generates mock resources from the example responses of an
+// OpenAPI spec
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gihan9a/braidmock/internal/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// methods are the operations whose responses are used for the fixture of a
+// path, in order of preference
+var methods = []string{"get", "put", "post", "patch"}
+
+// maxDepth limits how far $ref pointers to other $ref pointers are followed
+const maxDepth = 8
+
+// pathParam matches the parameters of path templates such as /users/{id}
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Fixture is a mock resource generated from a spec
+type Fixture struct {
+	Resource string // Resource ID, with path parameters filled in
+	Path     string // Path template in the spec, e.g. /users/{id}
+	Method   string // Method of the operation whose response the body is an example of
+	Type     string // Extension of non-JSON resources such as "txt", empty for JSON
+	Body     []byte
+}
+
+// spec is a parsed OpenAPI 3 or Swagger 2 document, kept generic so $ref
+// pointers can be resolved against it
+type spec map[string]interface{}
+
+// Fixtures returns a fixture for every path of a spec in YAML or JSON that
+// has an example or schema of a successful response, preferring GET
+// responses and filling in path parameters with their examples
+func Fixtures(data []byte) ([]Fixture, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+	root, ok := normalize(doc).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid spec: not an object")
+	}
+	if root["openapi"] == nil && root["swagger"] == nil {
+		return nil, fmt.Errorf("invalid spec: missing openapi version")
+	}
+	s := spec(root)
+
+	paths, _ := s["paths"].(map[string]interface{})
+	templates := make([]string, 0, len(paths))
+	for template := range paths {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	var fixtures []Fixture
+	for _, template := range templates {
+		item, _ := s.resolve(paths[template]).(map[string]interface{})
+		for _, method := range methods {
+			operation, ok := s.resolve(item[method]).(map[string]interface{})
+			if !ok {
+				continue
+			}
+			body, typ, ok := s.responseExample(operation)
+			if !ok {
+				continue
+			}
+			fixtures = append(fixtures, Fixture{
+				Resource: s.resourceID(template, item, operation),
+				Path:     template,
+				Method:   strings.ToUpper(method),
+				Type:     typ,
+				Body:     body,
+			})
+			break
+		}
+	}
+	return fixtures, nil
+}
+
+// resourceID fills in the parameters of a path template with the examples
+// of their definitions, or "1" for parameters without one
+func (s spec) resourceID(template string, item, operation map[string]interface{}) string {
+	examples := make(map[string]string)
+	for _, params := range []interface{}{item["parameters"], operation["parameters"]} {
+		list, _ := params.([]interface{})
+		for _, p := range list {
+			param, _ := s.resolve(p).(map[string]interface{})
+			if param["in"] != "path" {
+				continue
+			}
+			name, _ := param["name"].(string)
+			if value, ok := s.paramExample(param); ok {
+				examples[name] = value
+			}
+		}
+	}
+
+	return pathParam.ReplaceAllStringFunc(template, func(match string) string {
+		name := match[1 : len(match)-1]
+		if value, ok := examples[name]; ok {
+			return url.PathEscape(value)
+		}
+		return "1"
+	})
+}
+
+// paramExample returns the example value of a parameter
+func (s spec) paramExample(param map[string]interface{}) (string, bool) {
+	value, ok := param["example"]
+	if !ok {
+		schema, _ := s.resolve(param["schema"]).(map[string]interface{})
+		if value, ok = schema["example"]; !ok {
+			if enum, _ := schema["enum"].([]interface{}); len(enum) > 0 {
+				value, ok = enum[0], true
+			}
+		}
+	}
+	if !ok || value == nil {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// responseExample returns an example body of the first successful response
+// of an operation with one, along with the type of resource it is
+func (s spec) responseExample(operation map[string]interface{}) ([]byte, string, bool) {
+	responses, _ := operation["responses"].(map[string]interface{})
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	if _, ok := responses["default"]; ok {
+		codes = append(codes, "default")
+	}
+
+	for _, code := range codes {
+		response, _ := s.resolve(responses[code]).(map[string]interface{})
+
+		// OpenAPI 3 describes bodies per media type
+		if content, ok := response["content"].(map[string]interface{}); ok {
+			mediaType, media := jsonMedia(content)
+			if media == nil {
+				continue
+			}
+			value, ok := s.mediaExample(media)
+			if !ok {
+				continue
+			}
+			return encodeExample(value, mediaType)
+		}
+
+		// Swagger 2 has a schema and examples by media type
+		if examples, ok := response["examples"].(map[string]interface{}); ok {
+			if value, ok := examples["application/json"]; ok {
+				return encodeExample(value, "application/json")
+			}
+		}
+		if schema, ok := response["schema"]; ok {
+			return encodeExample(s.schemaExample(schema, nil), "application/json")
+		}
+	}
+	return nil, "", false
+}
+
+// jsonMedia returns the JSON media type of a response, or the first one if
+// it has none
+func jsonMedia(content map[string]interface{}) (string, map[string]interface{}) {
+	mediaTypes := make([]string, 0, len(content))
+	for mediaType := range content {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.Strings(mediaTypes)
+	if len(mediaTypes) == 0 {
+		return "", nil
+	}
+
+	chosen := mediaTypes[0]
+	for _, mediaType := range mediaTypes {
+		if isJSON(mediaType) {
+			chosen = mediaType
+			break
+		}
+	}
+	media, _ := content[chosen].(map[string]interface{})
+	return chosen, media
+}
+
+// mediaExample returns the example of a media type, its first named
+// example, or one generated from its schema
+func (s spec) mediaExample(media map[string]interface{}) (interface{}, bool) {
+	if value, ok := media["example"]; ok {
+		return value, true
+	}
+	if examples, ok := media["examples"].(map[string]interface{}); ok && len(examples) > 0 {
+		names := make([]string, 0, len(examples))
+		for name := range examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		example, _ := s.resolve(examples[names[0]]).(map[string]interface{})
+		if value, ok := example["value"]; ok {
+			return value, true
+		}
+	}
+	if schema, ok := media["schema"]; ok {
+		return s.schemaExample(schema, nil), true
+	}
+	return nil, false
+}
+
+// schemaExample generates an example value matching a schema, using the
+// examples, defaults and enums it declares. Schemas referring to themselves,
+// given the $ref pointers being expanded, end with null values and empty arrays.
+func (s spec) schemaExample(value interface{}, refs []string) interface{} {
+	if object, ok := value.(map[string]interface{}); ok {
+		if ref, ok := object["$ref"].(string); ok {
+			if slices.Contains(refs, ref) {
+				return nil
+			}
+			refs = append(refs, ref)
+		}
+	}
+	schema, _ := s.resolve(value).(map[string]interface{})
+	if schema == nil {
+		return nil
+	}
+	for _, key := range []string{"example", "default"} {
+		if example, ok := schema[key]; ok {
+			return example
+		}
+	}
+	if enum, _ := schema["enum"].([]interface{}); len(enum) > 0 {
+		return enum[0]
+	}
+	if allOf, _ := schema["allOf"].([]interface{}); len(allOf) > 0 {
+		merged := make(map[string]interface{})
+		for _, part := range allOf {
+			if object, ok := s.schemaExample(part, refs).(map[string]interface{}); ok {
+				for key, value := range object {
+					merged[key] = value
+				}
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if choices, _ := schema[key].([]interface{}); len(choices) > 0 {
+			return s.schemaExample(choices[0], refs)
+		}
+	}
+
+	typ, _ := schema["type"].(string)
+	if list, ok := schema["type"].([]interface{}); ok && len(list) > 0 {
+		// OpenAPI 3.1 lists types, e.g. ["string", "null"]
+		typ, _ = list[0].(string)
+	}
+	if typ == "" && schema["properties"] != nil {
+		typ = "object"
+	}
+
+	switch typ {
+	case "object":
+		object := make(map[string]interface{})
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range properties {
+			object[name] = s.schemaExample(property, refs)
+		}
+		return object
+	case "array":
+		if item := s.schemaExample(schema["items"], refs); item != nil {
+			return []interface{}{item}
+		}
+		return []interface{}{}
+	case "string":
+		format, _ := schema["format"].(string)
+		return stringExample(format)
+	case "integer", "number":
+		if minimum, ok := schema["minimum"]; ok {
+			return minimum
+		}
+		return 0
+	case "boolean":
+		return false
+	}
+	return nil
+}
+
+// stringExample returns an example of a string in a format
+func stringExample(format string) string {
+	switch format {
+	case "date-time":
+		return "2024-01-01T00:00:00Z"
+	case "date":
+		return "2024-01-01"
+	case "email":
+		return "user@example.com"
+	case "uuid":
+		return "00000000-0000-0000-0000-000000000000"
+	case "uri", "url":
+		return "https://example.com"
+	}
+	return "string"
+}
+
+// resolve follows a local $ref to the value it points to in the spec
+func (s spec) resolve(value interface{}) interface{} {
+	for i := 0; i < maxDepth; i++ {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		ref, ok := object["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			return value
+		}
+		tokens, err := utils.ParsePointer(strings.TrimPrefix(ref, "#"))
+		if err != nil {
+			return nil
+		}
+		value = lookup(map[string]interface{}(s), tokens)
+	}
+	return value
+}
+
+// lookup returns the value at the tokens of a JSON Pointer
+func lookup(value interface{}, tokens []string) interface{} {
+	for _, token := range tokens {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[token]
+	}
+	return value
+}
+
+// encodeExample encodes an example as the body of a resource of a media
+// type, returning the type of the resource
+func encodeExample(value interface{}, mediaType string) ([]byte, string, bool) {
+	if text, ok := value.(string); ok && strings.HasPrefix(mediaType, "text/") {
+		return []byte(text), "txt", true
+	}
+	body, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, "", false
+	}
+	return append(body, '\n'), "", true
+}
+
+// isJSON reports whether a media type is JSON
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// normalize converts maps decoded from YAML with non-string keys, such as
+// unquoted status codes, to maps with string keys
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[fmt.Sprint(key)] = normalize(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	}
+	return value
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"gihan9a/braidmock/internal/config"
+)
+
+// checkWriteAccess reports whether the access rules of a resource let a
+// write from source change it, responding with 403 Forbidden if they don't
+func (s *BraidMockServer) checkWriteAccess(w http.ResponseWriter, r *http.Request, source, resourceID string) bool {
+	access := s.resourceMeta(resourceID).Access
+	switch access {
+	case config.AccessWritable:
+		return true
+	case config.AccessAdminOnly:
+		if source == auditAdmin {
+			return true
+		}
+	}
+
+	logf(requestID(r), "Refusing %s write to %s resource %s", source, access, resourceID)
+	http.Error(w, fmt.Sprintf("Resource %s is %s", resourceID, access), http.StatusForbidden)
+	return false
+}
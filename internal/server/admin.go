@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"github.com/gorilla/mux"
+)
+
+// setupAdminRoutes registers the admin API on the given router
+func (s *BraidMockServer) setupAdminRoutes(router *mux.Router) {
+	router.HandleFunc("/push", s.handleAdminPush).Methods("POST")
+	router.HandleFunc("/conflict", s.handleAdminConflict).Methods("POST")
+	router.HandleFunc("/resources", s.handleAdminResources).Methods("GET")
+	router.HandleFunc("/renotify", s.handleAdminRenotify).Methods("POST")
+	router.HandleFunc("/resource", s.handleAdminWrite).Methods("PUT")
+	router.HandleFunc("/snapshot", s.handleAdminSnapshot).Methods("GET")
+	router.HandleFunc("/restore", s.handleAdminRestore).Methods("POST")
+	router.HandleFunc("/har", s.handleAdminHAR).Methods("GET")
+	router.HandleFunc("/har", s.handleAdminClearHAR).Methods("DELETE")
+	router.HandleFunc("/sessions", s.handleAdminSessions).Methods("GET")
+	router.HandleFunc("/sessions", s.handleAdminResetSession).Methods("DELETE")
+	router.HandleFunc("/audit", s.handleAdminAudit).Methods("GET")
+	router.HandleFunc("/history", s.handleAdminHistory).Methods("GET")
+}
+
+// resourceInfo describes a mock resource in admin listings
+type resourceInfo struct {
+	Resource    string `json:"resource"`
+	Version     string `json:"version"`
+	Size        int    `json:"size"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// adminResource returns the existing resource named by the resource query parameter
+func (s *BraidMockServer) adminResource(w http.ResponseWriter, r *http.Request) (string, bool) {
+	resourceID := r.URL.Query().Get("resource")
+	if resourceID == "" {
+		http.Error(w, "Missing resource parameter", http.StatusBadRequest)
+		return "", false
+	}
+	if !s.store.Exists(resourceID) {
+		s.logRejectedPath(r, resourceID)
+		http.Error(w, "Resource not found", http.StatusNotFound)
+		return "", false
+	}
+	return resourceID, true
+}
+
+// handleAdminResources lists all mock resources with their current versions and subscriber counts
+func (s *BraidMockServer) handleAdminResources(w http.ResponseWriter, r *http.Request) {
+	resourceIDs, err := s.store.List("/")
+	if err != nil {
+		http.Error(w, "Error listing resources: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resources := make([]resourceInfo, 0, len(resourceIDs))
+	for _, resourceID := range resourceIDs {
+		data, hash, err := s.readResource(r, resourceID)
+		if err != nil {
+			continue
+		}
+
+		subscribers := s.subscriptions.count(resourceID)
+
+		resources = append(resources, resourceInfo{
+			Resource:    resourceID,
+			Version:     hash,
+			Size:        len(data),
+			Subscribers: subscribers,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resources)
+}
+
+// handleAdminRenotify resends the full current state of a resource to all its subscribers
+func (s *BraidMockServer) handleAdminRenotify(w http.ResponseWriter, r *http.Request) {
+	resourceID, ok := s.adminResource(w, r)
+	if !ok || !s.checkWriteAccess(w, r, auditAdmin, resourceID) {
+		return
+	}
+
+	sent, err := s.renotify(resourceID)
+	if err != nil {
+		http.Error(w, "Error reading resource: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resource":    resourceID,
+		"subscribers": sent,
+	})
+}
+
+// handleAdminWrite replaces the body of a resource's .braid file, which the
+// file watcher then delivers to subscribers like any other edit, or the
+// resource's copy in the session of the request
+func (s *BraidMockServer) handleAdminWrite(w http.ResponseWriter, r *http.Request) {
+	resourceID, ok := s.adminResource(w, r)
+	if !ok || !s.checkWriteAccess(w, r, auditAdmin, resourceID) {
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading body: "+err.Error(), bodyErrorStatus(err))
+		return
+	}
+
+	if err := s.writeResource(r, auditAdmin, resourceID, data); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renotify resends the full current state of a resource to all its
+// subscribers, returning how many it was sent to
+func (s *BraidMockServer) renotify(resourceID string) (int, error) {
+	data, err := s.store.Read(resourceID)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock := s.sequences.lock(resourceID)
+	defer unlock()
+	hash := s.observeResource(resourceID, data)
+	s.cacheResource(resourceID, data, hash)
+	return s.renotifySubscribers(resourceID, data), nil
+}
+
+// handleAdminPush sends an update with explicit versions and parents to the
+// subscribers of a resource, e.g. to simulate merges from other peers
+func (s *BraidMockServer) handleAdminPush(w http.ResponseWriter, r *http.Request) {
+	resourceID, ok := s.adminResource(w, r)
+	if !ok || !s.checkWriteAccess(w, r, auditAdmin, resourceID) {
+		return
+	}
+
+	var update braidproto.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(update.Version) == 0 {
+		http.Error(w, "Update must have a version", http.StatusBadRequest)
+		return
+	}
+
+	unlock := s.sequences.lock(resourceID)
+	update.Sequence = s.sequences.next(resourceID)
+	sent := s.broadcastUpdate(resourceID, update)
+	unlock()
+	s.auditUpdate(r, auditAdmin, resourceID, update)
+	s.shareChange(clusterMessage{Kind: clusterUpdate, Resource: resourceID, Update: &update})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resource":    resourceID,
+		"subscribers": sent,
+	})
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// Sources of the writes recorded in the audit log
+const (
+	auditFile     = "file"     // Mock file edited outside the server
+	auditAdmin    = "admin"    // Admin API or editor
+	auditClient   = "client"   // Client request to the resource
+	auditSchedule = "schedule" // Scheduled change
+	auditAPI      = "api"      // UpdateResource of an embedding program
+	auditRestore  = "restore"  // Snapshot restore
+	auditCluster  = "cluster"  // Change made on another instance
+)
+
+// auditEntry records a write to a resource
+type auditEntry struct {
+	Time       time.Time          `json:"time"`
+	Resource   string             `json:"resource"`
+	Source     string             `json:"source"`
+	RequestID  string             `json:"request_id,omitempty"`
+	Session    string             `json:"session,omitempty"`
+	OldVersion []string           `json:"old_version,omitempty"`
+	NewVersion []string           `json:"new_version"`
+	Patches    []braidproto.Patch `json:"patches,omitempty"`
+	Body       string             `json:"body,omitempty"`
+	Status     int                `json:"status,omitempty"`
+}
+
+// auditWrite is the source of a write to the store, kept until the store
+// reports the change
+type auditWrite struct {
+	source    string
+	requestID string
+	version   string
+}
+
+// auditLog keeps the most recent writes to resources, and appends every
+// write to a JSONL file if one is configured
+type auditLog struct {
+	mu      sync.Mutex
+	entries []auditEntry
+	max     int
+	file    *os.File
+	pending map[string]auditWrite // Sources of writes the store hasn't reported yet, by resource
+}
+
+// newAuditLog opens the audit log, appending to file unless it is empty
+func newAuditLog(max int, file string) (*auditLog, error) {
+	a := &auditLog{max: max, pending: make(map[string]auditWrite)}
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		a.file = f
+	}
+	return a, nil
+}
+
+// add records a write, dropping the oldest kept when the log is full
+func (a *auditLog) add(entry auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.max > 0 {
+		a.entries = append(a.entries, entry)
+		if len(a.entries) > a.max {
+			a.entries = a.entries[len(a.entries)-a.max:]
+		}
+	}
+	if a.file != nil {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			_, err = a.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Printf("Error writing audit log: %v", err)
+		}
+	}
+}
+
+// expect remembers the source of a write to the store, for the change the
+// store reports once the resource has the written content
+func (a *auditLog) expect(resourceID string, write auditWrite) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending[resourceID] = write
+}
+
+// observe forgets the source of a write to a resource when the store
+// reports different content, which means the mock file was edited since
+func (a *auditLog) observe(resourceID, version string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if write, ok := a.pending[resourceID]; ok && write.version != version {
+		delete(a.pending, resourceID)
+	}
+}
+
+// source returns the source of a change to a resource reported by the
+// store, which is an edit of the mock file unless the server wrote it
+func (a *auditLog) source(resourceID string) auditWrite {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	write, ok := a.pending[resourceID]
+	if !ok {
+		return auditWrite{source: auditFile}
+	}
+	delete(a.pending, resourceID)
+	return write
+}
+
+// list returns the kept writes matching the resource and source filters,
+// either of which may be empty
+func (a *auditLog) list(resourceID, source string) []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := []auditEntry{}
+	for _, entry := range a.entries {
+		if (resourceID == "" || entry.Resource == resourceID) && (source == "" || entry.Source == source) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// close closes the audit log file
+func (a *auditLog) close() {
+	if a.file != nil {
+		a.file.Close()
+	}
+}
+
+// auditRequest returns the session and ID of the request making a write,
+// both empty for writes without one
+func (s *BraidMockServer) auditRequest(r *http.Request) (string, string) {
+	if r == nil {
+		return "", ""
+	}
+	return s.requestSession(r), requestID(r)
+}
+
+// expectWrite remembers the source of a write to the store
+func (s *BraidMockServer) expectWrite(r *http.Request, source, resourceID string, data []byte) {
+	if s.audit == nil {
+		return
+	}
+	_, id := s.auditRequest(r)
+	s.audit.expect(resourceID, auditWrite{source: source, requestID: id, version: s.hasher.Hash(data)})
+}
+
+// observeWrite matches a change the store reported with the write that made
+// it, by the content of the resource before rendering
+func (s *BraidMockServer) observeWrite(resourceID string, data []byte) {
+	if s.audit != nil {
+		s.audit.observe(resourceID, s.hasher.Hash(data))
+	}
+}
+
+// auditChange records a change of a resource the store reported, or its
+// creation if the update has no parents
+func (s *BraidMockServer) auditChange(resourceID string, update braidproto.Update) {
+	if s.audit == nil {
+		return
+	}
+	write := s.audit.source(resourceID)
+	s.audit.add(auditEntry{
+		Time:       s.clock.Now(),
+		Resource:   resourceID,
+		Source:     write.source,
+		RequestID:  write.requestID,
+		OldVersion: update.Parents,
+		NewVersion: update.Version,
+		Patches:    update.Patches,
+		Body:       update.Body,
+	})
+}
+
+// auditUpdate records a write that doesn't go through the store, such as a
+// write to a session's copy, a push or a deletion
+func (s *BraidMockServer) auditUpdate(r *http.Request, source, resourceID string, update braidproto.Update) {
+	if s.audit == nil {
+		return
+	}
+	session, id := s.auditRequest(r)
+	s.audit.add(auditEntry{
+		Time:       s.clock.Now(),
+		Resource:   resourceID,
+		Source:     source,
+		RequestID:  id,
+		Session:    session,
+		OldVersion: update.Parents,
+		NewVersion: update.Version,
+		Patches:    update.Patches,
+		Body:       update.Body,
+		Status:     update.Status,
+	})
+}
+
+// handleAdminAudit lists the recorded writes, filtered by the resource and
+// source query parameters
+func (s *BraidMockServer) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil || s.audit.max <= 0 {
+		http.Error(w, "Audit log is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.audit.list(query.Get("resource"), query.Get("source")))
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"gihan9a/braidmock/internal/config"
+)
+
+// authEnabled reports whether any credentials are configured
+func (s *BraidMockServer) authEnabled() bool {
+	return len(s.config.Auth.Users) > 0 || len(s.config.Auth.APIKeys) > 0 || s.jwt != nil
+}
+
+// isAdminPath reports whether a path belongs to the admin API, dashboard or editor
+func (s *BraidMockServer) isAdminPath(path string) bool {
+	if !s.config.Admin.Enabled {
+		return false
+	}
+	admin := s.config.Admin
+	return path == admin.Prefix || strings.HasPrefix(path, admin.Prefix+"/") ||
+		path == admin.UIPath || strings.HasPrefix(path, admin.UIPath+"/") ||
+		strings.HasPrefix(path, admin.EditPath+"/")
+}
+
+// requiresAuth reports whether a request falls under the configured auth scope
+func (s *BraidMockServer) requiresAuth(r *http.Request) bool {
+	if !s.authEnabled() || r.Method == http.MethodOptions {
+		return false
+	}
+	switch s.config.Auth.Scope {
+	case config.AuthScopeMock:
+		return !s.isAdminPath(r.URL.Path)
+	case config.AuthScopeAdmin:
+		return s.isAdminPath(r.URL.Path)
+	default:
+		return true
+	}
+}
+
+// authMiddleware rejects requests in the auth scope without a valid JWT,
+// basic auth credentials or API key. Claims of valid JWTs are added to the
+// request context.
+func (s *BraidMockServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.requiresAuth(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token, ok := bearerToken(r); ok && s.jwt != nil {
+			claims, status, err := s.jwt.verify(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, http.StatusText(status)+": "+err.Error(), status)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+			return
+		}
+
+		if !s.authenticated(r) {
+			if s.jwt != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="braid-mock"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Basic realm="braid-mock"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticated checks the request's credentials against the configured users and API keys
+func (s *BraidMockServer) authenticated(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		if expected, exists := s.config.Auth.Users[user]; exists && secureCompare(password, expected) {
+			return true
+		}
+	}
+
+	// Browsers can't set headers on EventSource or WebSocket connections, so
+	// API keys are also accepted as a query parameter
+	key := r.Header.Get(s.config.Auth.APIKeyHeader)
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	if key != "" {
+		for _, expected := range s.config.Auth.APIKeys {
+			if secureCompare(key, expected) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// secureCompare compares secrets in constant time
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v3"
+)
+
+// authRule simulates an auth outcome for requests matching a path and token pattern
+type authRule struct {
+	Path    string            `yaml:"path"`    // Glob matched against the request path, empty matches any
+	Token   string            `yaml:"token"`   // Glob matched against the Bearer token or API key, empty matches any
+	Missing bool              `yaml:"missing"` // Only match requests without a token
+	Status  int               `yaml:"status"`
+	Body    string            `yaml:"body"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// authRulesFile represents the structure of an auth rules file
+type authRulesFile struct {
+	Rules []authRule `yaml:"rules"`
+}
+
+// loadAuthRules reads the auth rules from a YAML file
+func loadAuthRules(filePath string) ([]authRule, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading auth rules file: %w", err)
+	}
+
+	var rulesFile authRulesFile
+	if err := yaml.Unmarshal(data, &rulesFile); err != nil {
+		return nil, fmt.Errorf("error parsing auth rules file: %w", err)
+	}
+
+	for i, rule := range rulesFile.Rules {
+		if rule.Status < 100 || rule.Status > 599 {
+			return nil, fmt.Errorf("auth rule %d has invalid status: %d", i+1, rule.Status)
+		}
+		if _, err := path.Match(rule.Path, "/"); err != nil {
+			return nil, fmt.Errorf("auth rule %d has invalid path pattern: %w", i+1, err)
+		}
+		if _, err := path.Match(rule.Token, ""); err != nil {
+			return nil, fmt.Errorf("auth rule %d has invalid token pattern: %w", i+1, err)
+		}
+	}
+
+	return rulesFile.Rules, nil
+}
+
+// matches reports whether a rule applies to a request path and presented token
+func (rule authRule) matches(requestPath, token string) bool {
+	if rule.Path != "" {
+		if ok, _ := path.Match(rule.Path, requestPath); !ok {
+			return false
+		}
+	}
+	if rule.Missing {
+		return token == ""
+	}
+	if rule.Token != "" {
+		if ok, _ := path.Match(rule.Token, token); !ok || token == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// authRulesMiddleware responds with the outcome of the first auth rule
+// matching a request, before any real authentication takes place
+func (s *BraidMockServer) authRulesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.authRules) == 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if rule, ok := s.matchAuthRule(r.URL.Path, s.presentedToken(r)); ok {
+			for name, value := range rule.Headers {
+				w.Header().Set(name, value)
+			}
+			if w.Header().Get("Content-Type") == "" {
+				if json.Valid([]byte(rule.Body)) {
+					w.Header().Set("Content-Type", "application/json")
+				} else {
+					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				}
+			}
+			w.WriteHeader(rule.Status)
+			w.Write([]byte(rule.Body))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matchAuthRule returns the first auth rule matching a request path and
+// presented token, if any
+func (s *BraidMockServer) matchAuthRule(requestPath, token string) (authRule, bool) {
+	for _, rule := range s.authRules {
+		if rule.matches(requestPath, token) {
+			return rule, true
+		}
+	}
+	return authRule{}, false
+}
+
+// presentedToken returns the Bearer token or API key a request carries
+func (s *BraidMockServer) presentedToken(r *http.Request) string {
+	if token, ok := bearerToken(r); ok {
+		return token
+	}
+	if key := r.Header.Get(s.config.Auth.APIKeyHeader); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
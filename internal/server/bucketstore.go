@@ -0,0 +1,413 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Default S3 API endpoints of the bucket URL schemes
+var bucketEndpoints = map[string]string{
+	"s3": "s3.amazonaws.com",
+	"gs": "storage.googleapis.com",
+}
+
+// bucketStore serves resources from mock files in an S3 or Google Cloud
+// Storage bucket, kept in memory and polled for changes. The bucket holds a
+// fixture set shared by many servers, so like embedded mock files, writes
+// are kept in memory until the object changes in the bucket.
+type bucketStore struct {
+	client    *minio.Client
+	bucket    string
+	prefix    string // Key prefix of the mock files, empty or ending in a slash
+	indexName string
+	interval  time.Duration
+	mu        sync.RWMutex
+	files     map[string][]byte   // Content of the mock files, by name relative to the prefix
+	mockFiles map[string][]string // Sorted names of the mock files of each resource, by base name
+	etags     map[string]string   // ETags of the objects the mock files were last read from
+	onChange  func(resourceID string, data []byte)
+	onDelete  func(resourceID string)
+	onMeta    func(resourceID string)
+	done      chan struct{} // Closed when the store is closed
+}
+
+// newBucketStore connects to the configured bucket and reads its mock files
+func newBucketStore(cfg *config.Config) (*bucketStore, error) {
+	u, err := url.Parse(cfg.Bucket.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bucket url: %w", err)
+	}
+	endpoint := cfg.Bucket.Endpoint
+	if endpoint == "" {
+		endpoint = bucketEndpoints[u.Scheme]
+	}
+
+	// Without configured keys, credentials are taken from the environment
+	// like the AWS CLI does
+	creds := credentials.NewStaticV4(cfg.Bucket.AccessKey, cfg.Bucket.SecretKey, "")
+	if cfg.Bucket.AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.Bucket.Insecure,
+		Region: cfg.Bucket.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bucket: %w", err)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	s := &bucketStore{
+		client:    client,
+		bucket:    u.Host,
+		prefix:    prefix,
+		indexName: cfg.IndexName,
+		interval:  time.Duration(cfg.Bucket.PollInterval) * time.Second,
+		files:     make(map[string][]byte),
+		mockFiles: make(map[string][]string),
+		etags:     make(map[string]string),
+		done:      make(chan struct{}),
+	}
+	if err := s.sync(); err != nil {
+		return nil, err
+	}
+	log.Printf("Serving %d mock files from bucket %s", len(s.files), cfg.Bucket.URL)
+	return s, nil
+}
+
+// sync reads the mock files whose objects are new or changed since the last
+// sync, reporting the changed resources, and forgets those of deleted
+// objects, reporting the resources left without mock files as deleted
+func (s *bucketStore) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	seen := make(map[string]bool)
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("error listing bucket %s: %w", s.bucket, object.Err)
+		}
+		name := strings.TrimPrefix(object.Key, s.prefix)
+		if !strings.HasSuffix(name, resourceSuffix) && fileExtension(name) == "" && !strings.HasSuffix(name, metaSuffix) {
+			continue
+		}
+		seen[name] = true
+
+		s.mu.RLock()
+		etag, known := s.etags[name]
+		s.mu.RUnlock()
+		if known && etag == object.ETag {
+			continue
+		}
+		data, err := s.readObject(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.setFile(name, data)
+		s.etags[name] = object.ETag
+		onChange, onMeta := s.onChange, s.onMeta
+		s.mu.Unlock()
+		switch {
+		case strings.HasSuffix(name, metaSuffix):
+			if onMeta != nil {
+				onMeta(s.metaResourceID(name))
+			}
+		case onChange != nil:
+			onChange(mockFileResourceID(name, s.indexName), data)
+		}
+	}
+
+	// Mock files deleted from the bucket stop being served, while those only
+	// written to this server are kept. A resource with another mock file left
+	// changes to its content.
+	s.mu.Lock()
+	removed := make(map[string]bool)
+	var metaRemoved []string
+	for name := range s.etags {
+		if !seen[name] {
+			s.removeFile(name)
+			delete(s.etags, name)
+			if strings.HasSuffix(name, metaSuffix) {
+				metaRemoved = append(metaRemoved, s.metaResourceID(name))
+			} else {
+				removed[bucketFileBase(name)] = true
+			}
+		}
+	}
+	changed := make(map[string][]byte)
+	var deleted []string
+	for _, base := range sortedKeys(removed) {
+		resourceID := mockFileResourceID(base+resourceSuffix, s.indexName)
+		if names := s.mockFiles[base]; len(names) > 0 {
+			changed[resourceID] = s.files[names[0]]
+		} else {
+			deleted = append(deleted, resourceID)
+		}
+	}
+	onChange, onDelete, onMeta := s.onChange, s.onDelete, s.onMeta
+	s.mu.Unlock()
+
+	if onMeta != nil {
+		for _, resourceID := range metaRemoved {
+			onMeta(resourceID)
+		}
+	}
+	if onChange != nil {
+		for _, resourceID := range sortedKeys(changed) {
+			onChange(resourceID, changed[resourceID])
+		}
+	}
+	if onDelete != nil {
+		for _, resourceID := range deleted {
+			onDelete(resourceID)
+		}
+	}
+	return nil
+}
+
+// metaResourceID returns the ID of the resource a sidecar file belongs to
+func (s *bucketStore) metaResourceID(name string) string {
+	return mockFileResourceID(strings.TrimSuffix(name, metaSuffix)+resourceSuffix, s.indexName)
+}
+
+// bucketFileBase returns the name of a mock file without its suffixes, the
+// base name of the resource it serves
+func bucketFileBase(name string) string {
+	if ext := fileExtension(name); ext != "" {
+		return strings.TrimSuffix(name, resourceSuffix+"."+ext)
+	}
+	return strings.TrimSuffix(name, resourceSuffix)
+}
+
+// setFile stores the content of a mock file, indexing new mock files by the
+// resource they serve. The caller must hold s.mu.
+func (s *bucketStore) setFile(name string, data []byte) {
+	if _, ok := s.files[name]; !ok && !strings.HasSuffix(name, metaSuffix) {
+		base := bucketFileBase(name)
+		names := append(s.mockFiles[base], name)
+		sort.Strings(names)
+		s.mockFiles[base] = names
+	}
+	s.files[name] = data
+}
+
+// removeFile forgets a mock file. The caller must hold s.mu.
+func (s *bucketStore) removeFile(name string) {
+	delete(s.files, name)
+	if strings.HasSuffix(name, metaSuffix) {
+		return
+	}
+	base := bucketFileBase(name)
+	names := slices.DeleteFunc(s.mockFiles[base], func(n string) bool { return n == name })
+	if len(names) == 0 {
+		delete(s.mockFiles, base)
+	} else {
+		s.mockFiles[base] = names
+	}
+}
+
+// readObject returns the content of an object in the bucket
+func (s *bucketStore) readObject(ctx context.Context, key string) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from bucket %s: %w", key, s.bucket, err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from bucket %s: %w", key, s.bucket, err)
+	}
+	return data, nil
+}
+
+// pollBucket syncs the mock files with the bucket until the store is closed
+func (s *bucketStore) pollBucket() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.sync(); err != nil {
+				log.Printf("Error polling bucket: %v", err)
+			}
+		}
+	}
+}
+
+// baseName returns the name of a resource's mock file without suffixes
+func (s *bucketStore) baseName(resourceID string) string {
+	base := strings.TrimPrefix(resourceID, "/")
+	if base == "" || strings.HasSuffix(base, "/") {
+		base += s.indexName
+	}
+	return base
+}
+
+// fileName returns the name of a resource's mock file, preferring a .braid
+// file over typed mock files, e.g. name.braid.txt, which sort after it
+func (s *bucketStore) fileName(resourceID string) (string, bool) {
+	base := s.baseName(resourceID)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if names := s.mockFiles[base]; len(names) > 0 {
+		return names[0], true
+	}
+	return "", false
+}
+
+// Stat returns the ID and type of a resource's mock file
+func (s *bucketStore) Stat(resourceID string) (ResourceInfo, error) {
+	name, ok := s.fileName(resourceID)
+	if !ok {
+		return ResourceInfo{}, fs.ErrNotExist
+	}
+
+	s.mu.RLock()
+	size := int64(len(s.files[name]))
+	s.mu.RUnlock()
+	return ResourceInfo{ID: mockFileResourceID(name, s.indexName), Type: fileExtension(name), Size: size}, nil
+}
+
+// Exists checks if a mock file exists for the given resource ID
+func (s *bucketStore) Exists(resourceID string) bool {
+	_, ok := s.fileName(resourceID)
+	return ok
+}
+
+// Read returns the content of a resource's mock file
+func (s *bucketStore) Read(resourceID string) ([]byte, error) {
+	name, ok := s.fileName(resourceID)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.files[name], nil
+}
+
+// Open returns a reader for the content of a resource's mock file
+func (s *bucketStore) Open(resourceID string) (io.ReadCloser, error) {
+	data, err := s.Read(resourceID)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// ReadMeta returns the content of a resource's sidecar settings file
+func (s *bucketStore) ReadMeta(resourceID string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.files[s.baseName(resourceID)+metaSuffix]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return data, nil
+}
+
+// Write replaces the content of a resource in memory, creating a .braid
+// file for new resources, and reports the change right away
+func (s *bucketStore) Write(resourceID string, data []byte) error {
+	name, ok := s.fileName(resourceID)
+	if !ok {
+		name = s.baseName(resourceID) + resourceSuffix
+	}
+
+	s.mu.Lock()
+	s.setFile(name, append([]byte(nil), data...))
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if onChange != nil {
+		onChange(mockFileResourceID(name, s.indexName), data)
+	}
+	return nil
+}
+
+// List returns the IDs of all resources under a path prefix
+func (s *bucketStore) List(prefix string) ([]string, error) {
+	dir := strings.TrimPrefix(prefix, "/")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var resources []string
+	seen := make(map[string]bool)
+	for _, name := range sortedKeys(s.files) {
+		if !strings.HasPrefix(name, dir) || strings.HasSuffix(name, metaSuffix) {
+			continue
+		}
+
+		// A resource may have mock files with several extensions
+		if resourceID := mockFileResourceID(name, s.indexName); !seen[resourceID] {
+			seen[resourceID] = true
+			resources = append(resources, resourceID)
+		}
+	}
+	sort.Strings(resources)
+	return resources, nil
+}
+
+// WatchMeta calls onChange with the resources whose sidecar files changed
+// or were deleted in the bucket, once Watch has started polling it
+func (s *bucketStore) WatchMeta(onChange func(resourceID string)) {
+	s.mu.Lock()
+	s.onMeta = onChange
+	s.mu.Unlock()
+}
+
+// WatchDeletions calls onDelete with the resources whose last mock file was
+// deleted from the bucket, once Watch has started polling it
+func (s *bucketStore) WatchDeletions(onDelete func(resourceID string)) {
+	s.mu.Lock()
+	s.onDelete = onDelete
+	s.mu.Unlock()
+}
+
+// Watch starts polling the bucket for changed mock files
+func (s *bucketStore) Watch(onChange func(resourceID string, data []byte)) error {
+	s.mu.Lock()
+	s.onChange = onChange
+	s.mu.Unlock()
+
+	log.Printf("Polling bucket for changes every %v", s.interval)
+	go s.pollBucket()
+	return nil
+}
+
+// Close stops polling the bucket
+func (s *bucketStore) Close() error {
+	close(s.done)
+	return nil
+}
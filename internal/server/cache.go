@@ -0,0 +1,136 @@
+package server
+
+import "net/http"
+
+// cachedResource is the last known content of a resource and its version
+type cachedResource struct {
+	Data []byte
+	Hash string
+}
+
+// readResource returns the current content of a resource and its version,
+// rendered for the client making the request, if any. With
+// caching enabled, resources are only read from the store until they are
+// cached, and the cache is then kept up to date by change events. Resources
+// written in the session of the request are read from its copy.
+func (s *BraidMockServer) readResource(r *http.Request, resourceID string) ([]byte, string, error) {
+	if data, ok := s.sessions.read(s.requestSession(r), resourceID); ok {
+		return s.readSessionResource(r, resourceID, data)
+	}
+
+	// Templates and scripts may render differently on every request, so
+	// they are never cached
+	dynamic := s.resourceMeta(resourceID).dynamic()
+	cached := s.config.CacheResources && !dynamic
+
+	if cached {
+		s.mu.RLock()
+		cached, ok := s.cache[resourceID]
+		s.mu.RUnlock()
+		if ok {
+			return cached.Data, cached.Hash, nil
+		}
+	}
+
+	data, err := s.store.Read(resourceID)
+	if err != nil {
+		return nil, "", err
+	}
+	if dynamic {
+		// A render for one request isn't a change to the resource, which
+		// only its change events record
+		if data, err = s.renderResource(r, resourceID, data); err != nil {
+			return nil, "", err
+		}
+		return data, s.hasher.Hash(data), nil
+	}
+	hash := s.observeResource(resourceID, data)
+
+	// A change event may have cached newer content in the meantime
+	if cached {
+		s.mu.Lock()
+		if _, exists := s.cache[resourceID]; !exists {
+			s.cache[resourceID] = cachedResource{Data: data, Hash: hash}
+		}
+		s.mu.Unlock()
+	}
+	return data, hash, nil
+}
+
+// renderResource returns the content of a resource as served to a request,
+// or for change events when r is nil, rendering its template and then
+// running its script
+func (s *BraidMockServer) renderResource(r *http.Request, resourceID string, data []byte) ([]byte, error) {
+	meta := s.resourceMeta(resourceID)
+	var err error
+	if meta.Template {
+		if data, err = s.renderTemplate(r, resourceID, data); err != nil {
+			return nil, err
+		}
+	}
+	if meta.Script != "" {
+		if data, err = s.runScript(r, resourceID, meta.Script, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// statResource returns information about a resource's mock file, cached
+// along with the resources when caching is enabled
+func (s *BraidMockServer) statResource(resourceID string) (ResourceInfo, error) {
+	if !s.config.CacheResources {
+		return s.store.Stat(resourceID)
+	}
+
+	s.mu.RLock()
+	info, ok := s.infos[resourceID]
+	gen := s.cacheGen
+	s.mu.RUnlock()
+	if ok {
+		return info, nil
+	}
+	info, err := s.store.Stat(resourceID)
+	if err != nil {
+		return info, err
+	}
+
+	// Missing resources aren't cached, as they may appear without a change
+	s.mu.Lock()
+	if s.cacheGen == gen {
+		s.infos[resourceID] = info
+	}
+	s.mu.Unlock()
+	return info, nil
+}
+
+// invalidateResource forgets the cached settings, mock file information and
+// large resource version of a resource whose mock file changed or was deleted
+func (s *BraidMockServer) invalidateResource(resourceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cacheGen++
+	delete(s.metas, resourceID)
+	delete(s.infos, resourceID)
+	delete(s.largeHashes, resourceID)
+}
+
+// invalidateMeta forgets the cached settings of a resource whose sidecar
+// file changed
+func (s *BraidMockServer) invalidateMeta(resourceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cacheGen++
+	delete(s.metas, resourceID)
+}
+
+// cacheResource replaces the cached content of a resource after it changed
+func (s *BraidMockServer) cacheResource(resourceID string, data []byte, hash string) {
+	if !s.config.CacheResources {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[resourceID] = cachedResource{Data: data, Hash: hash}
+}
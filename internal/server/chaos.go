@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"gihan9a/braidmock/internal/utils"
+)
+
+// chaosMiddleware disrupts subscriptions as configured: each is given a
+// random lifetime after which its connection is aborted without ending the
+// response, so that the configured percentage of them is terminated per
+// minute, and flushes are delayed by a random time
+func (s *BraidMockServer) chaosMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chaos := s.config.Braid.Chaos
+		if (chaos.TerminatePercent == 0 && chaos.FlushDelay == 0) || !wantsStream(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if chaos.FlushDelay > 0 {
+			w = &chaosResponseWriter{ResponseWriter: w, clock: s.clock, maxDelay: time.Duration(chaos.FlushDelay) * time.Millisecond}
+		}
+		if chaos.TerminatePercent == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		timer := s.clock.NewTimer(chaosLifetime(chaos.TerminatePercent))
+		defer timer.Stop()
+		terminated := make(chan struct{})
+		go func() {
+			select {
+			case <-timer.C():
+				logf(requestID(r), "Chaos: terminating subscription to %s", r.URL.Path)
+				close(terminated)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// The subscription is cleaned up as if the client had gone, then the
+		// connection is cut before the response ends
+		next.ServeHTTP(w, r.WithContext(ctx))
+		select {
+		case <-terminated:
+			panic(http.ErrAbortHandler)
+		default:
+		}
+	})
+}
+
+// chaosLifetime returns a random lifetime for a subscription, exponentially
+// distributed so that the given percentage of subscriptions open at any
+// time is terminated within a minute
+func chaosLifetime(percent int) time.Duration {
+	if percent >= 100 {
+		return time.Duration(rand.Int63n(int64(time.Minute)))
+	}
+	rate := -math.Log(1-float64(percent)/100) / time.Minute.Seconds()
+	return time.Duration(rand.ExpFloat64() / rate * float64(time.Second))
+}
+
+// chaosResponseWriter delays every flush of a response by a random time
+type chaosResponseWriter struct {
+	http.ResponseWriter
+	clock    utils.Clock
+	maxDelay time.Duration
+}
+
+// Flush sends the response written so far after a random delay
+func (cw *chaosResponseWriter) Flush() {
+	cw.FlushError()
+}
+
+// FlushError flushes the response after a random delay, returning the write
+// error if there is one
+func (cw *chaosResponseWriter) FlushError() error {
+	cw.clock.Sleep(time.Duration(rand.Int63n(int64(cw.maxDelay) + 1)))
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets response controllers reach the underlying response writer
+func (cw *chaosResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// Kinds of changes shared between instances
+const (
+	clusterWrite  = "write"  // Resource written, with its new body
+	clusterUpdate = "update" // Update pushed to the subscribers of a resource
+	clusterDelete = "delete" // Resource soft-deleted
+)
+
+// clusterMessage tells the other instances of a mock about a change made on
+// one of them
+type clusterMessage struct {
+	Instance string             `json:"instance"` // Instance the change was made on
+	Kind     string             `json:"kind"`
+	Resource string             `json:"resource"`
+	Body     []byte             `json:"body,omitempty"`
+	Update   *braidproto.Update `json:"update,omitempty"`
+}
+
+// cluster connects the instances of a mock serving the same resources, e.g.
+// behind a load balancer, so a change made on any of them reaches the
+// subscribers of all of them
+type cluster interface {
+	Publish(message clusterMessage) error
+	Subscribe(handler func(clusterMessage)) error
+	Close()
+}
+
+// versionRegistry shares the versions the instances of a mock have produced,
+// so clients can resume from them on any instance
+type versionRegistry interface {
+	AddVersions(resourceID string, versions []string) error
+	KnownVersions(resourceID string, versions []string) ([]bool, error)
+}
+
+// newCluster connects to the configured backend shared with other
+// instances, returning nil if there is none. Configs built in code rather
+// than loaded may set both, which would share every change twice.
+func newCluster(cfg *config.Config) (cluster, error) {
+	if cfg.Redis.URL != "" && cfg.NATS.ClusterSubject != "" {
+		return nil, fmt.Errorf("invalid cluster config: both redis url and NATS cluster_subject are set")
+	}
+	if cfg.Redis.URL != "" {
+		return newRedisCluster(cfg.Redis, cfg.Braid.HistorySize)
+	}
+	if cfg.NATS.ClusterSubject != "" {
+		return newNATSCluster(cfg.NATS)
+	}
+	return nil, nil
+}
+
+// remoteWrites holds the versions of the writes from other instances until
+// the store reports them, so they aren't shared back
+type remoteWrites struct {
+	mu      sync.Mutex
+	pending map[string]string // Version written, by resource
+}
+
+func newRemoteWrites() *remoteWrites {
+	return &remoteWrites{pending: make(map[string]string)}
+}
+
+// expect remembers a write from another instance
+func (w *remoteWrites) expect(resourceID, version string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[resourceID] = version
+}
+
+// take reports whether a change the store reported is a write from another
+// instance, forgetting the write
+func (w *remoteWrites) take(resourceID, version string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	remote, ok := w.pending[resourceID]
+	if !ok {
+		return false
+	}
+	delete(w.pending, resourceID)
+	return remote == version
+}
+
+// handleStoreChange shares a change the store reported with the other
+// instances, unless it came from one of them, then handles it
+func (s *BraidMockServer) handleStoreChange(resourceID string, data []byte) {
+	s.invalidateResource(resourceID)
+	if s.cluster != nil && !s.remoteWrites.take(resourceID, s.hasher.Hash(data)) {
+		s.shareChange(clusterMessage{Kind: clusterWrite, Resource: resourceID, Body: data})
+	}
+	s.handleResourceChange(resourceID, data)
+}
+
+// shareChange tells the other instances about a change made on this one
+func (s *BraidMockServer) shareChange(message clusterMessage) {
+	if s.cluster == nil {
+		return
+	}
+	message.Instance = s.instance
+	if err := s.cluster.Publish(message); err != nil {
+		log.Printf("Error sharing change of %s with other instances: %v", message.Resource, err)
+	}
+}
+
+// handleClusterMessage applies a change made on another instance
+func (s *BraidMockServer) handleClusterMessage(message clusterMessage) {
+	if message.Instance == s.instance {
+		return
+	}
+	resourceID := message.Resource
+
+	switch message.Kind {
+	case clusterWrite:
+		// Instances serving the same directory already have the content
+		if data, err := s.store.Read(resourceID); err == nil && bytes.Equal(data, message.Body) {
+			return
+		}
+		s.expectWrite(nil, auditCluster, resourceID, message.Body)
+		s.remoteWrites.expect(resourceID, s.hasher.Hash(message.Body))
+		if err := s.store.Write(resourceID, message.Body); err != nil {
+			log.Printf("Error writing resource %s from instance %s: %v", resourceID, message.Instance, err)
+		}
+
+	case clusterUpdate:
+		if message.Update == nil {
+			return
+		}
+		update := *message.Update
+		unlock := s.sequences.lock(resourceID)
+		update.Sequence = s.sequences.next(resourceID)
+		s.broadcastUpdate(resourceID, update)
+		unlock()
+		s.auditUpdate(nil, auditCluster, resourceID, update)
+
+	case clusterDelete:
+		s.tombstones.add("", resourceID)
+		s.notifyDeleted(resourceID, func(sub Subscription) bool {
+			return !s.sessions.has(sub.Session, resourceID)
+		})
+
+	default:
+		log.Printf("Unknown change %q of %s from instance %s", message.Kind, resourceID, message.Instance)
+	}
+}
+
+// shareVersions adds versions produced by this instance to the registry
+// shared with other instances, if there is one
+func (s *BraidMockServer) shareVersions(resourceID string, versions []string) {
+	registry, ok := s.cluster.(versionRegistry)
+	if !ok || len(versions) == 0 {
+		return
+	}
+	if err := registry.AddVersions(resourceID, versions); err != nil {
+		log.Printf("Error sharing versions of %s with other instances: %v", resourceID, err)
+	}
+}
+
+// sharedUnknownVersions returns the given versions that no other instance
+// has produced for a resource either
+func (s *BraidMockServer) sharedUnknownVersions(resourceID string, versions []string) []string {
+	registry, ok := s.cluster.(versionRegistry)
+	if !ok || len(versions) == 0 {
+		return versions
+	}
+	known, err := registry.KnownVersions(resourceID, versions)
+	if err != nil {
+		log.Printf("Error looking up versions of %s from other instances: %v", resourceID, err)
+		return versions
+	}
+
+	var unknown []string
+	for i, version := range versions {
+		if !known[i] {
+			unknown = append(unknown, version)
+		}
+	}
+	return unknown
+}
+
+// redisCluster shares changes between instances over a Redis pub/sub
+// channel, and their latest versions in a Redis sorted set per resource
+type redisCluster struct {
+	client      *redis.Client
+	pubsub      *redis.PubSub
+	prefix      string
+	maxVersions int // Versions kept per resource, like the history of each instance
+}
+
+// newRedisCluster connects to a Redis server, keeping up to historySize
+// versions of each resource, or one when history is disabled
+func newRedisCluster(cfg config.RedisConfig, historySize int) (*redisCluster, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	log.Printf("Sharing versions and updates with other instances through Redis at %s", opts.Addr)
+	return &redisCluster{client: client, prefix: cfg.Prefix, maxVersions: max(historySize, 1)}, nil
+}
+
+// Publish sends a change to the channel of the mock, <prefix>:changes
+func (c *redisCluster) Publish(message clusterMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.client.Publish(ctx, c.prefix+":changes", payload).Err()
+}
+
+// Subscribe calls handler with the changes sent to the channel of the mock,
+// one at a time in the order they were sent
+func (c *redisCluster) Subscribe(handler func(clusterMessage)) error {
+	channel := c.prefix + ":changes"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.pubsub = c.client.Subscribe(ctx, channel)
+	if _, err := c.pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to Redis channel %s: %w", channel, err)
+	}
+
+	go func() {
+		for msg := range c.pubsub.Channel() {
+			var message clusterMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+				log.Printf("Invalid change on Redis channel %s: %v", channel, err)
+				continue
+			}
+			handler(message)
+		}
+	}()
+	return nil
+}
+
+// AddVersions adds versions to the sorted set of a resource,
+// <prefix>:recent-versions:<resource>, scored by when they were added, and
+// drops the oldest beyond maxVersions
+func (c *redisCluster) AddVersions(resourceID string, versions []string) error {
+	key := c.prefix + ":recent-versions:" + resourceID
+	now := float64(time.Now().UnixNano())
+	members := make([]redis.Z, len(versions))
+	for i, version := range versions {
+		members[i] = redis.Z{Score: now, Member: version}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, members...)
+		pipe.ZRemRangeByRank(ctx, key, 0, int64(-c.maxVersions-1))
+		return nil
+	})
+	return err
+}
+
+// KnownVersions reports which of the given versions are in the sorted set of
+// a resource
+func (c *redisCluster) KnownVersions(resourceID string, versions []string) ([]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	scores, err := c.client.ZMScore(ctx, c.prefix+":recent-versions:"+resourceID, versions...).Result()
+	if err != nil {
+		return nil, err
+	}
+	// Members that aren't in the set have no score, read as 0
+	known := make([]bool, len(scores))
+	for i, score := range scores {
+		known[i] = score != 0
+	}
+	return known, nil
+}
+
+// Close unsubscribes and disconnects from Redis
+func (c *redisCluster) Close() {
+	if c.pubsub != nil {
+		c.pubsub.Close()
+	}
+	c.client.Close()
+}
+
+// natsCluster shares changes between instances over a NATS subject
+type natsCluster struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// newNATSCluster connects to a NATS server
+func newNATSCluster(cfg config.NATSConfig) (*natsCluster, error) {
+	conn, err := nats.Connect(cfg.URL, nats.Name("braidmock-cluster"), nats.NoEcho())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	log.Printf("Sharing updates with other instances over NATS subject %s at %s", cfg.ClusterSubject, cfg.URL)
+	return &natsCluster{conn: conn, subject: cfg.ClusterSubject}, nil
+}
+
+// Publish sends a change to the cluster subject
+func (c *natsCluster) Publish(message clusterMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.conn.Publish(c.subject, payload)
+}
+
+// Subscribe calls handler with the changes sent to the cluster subject, one
+// at a time in the order they were sent
+func (c *natsCluster) Subscribe(handler func(clusterMessage)) error {
+	_, err := c.conn.Subscribe(c.subject, func(msg *nats.Msg) {
+		var message clusterMessage
+		if err := json.Unmarshal(msg.Data, &message); err != nil {
+			log.Printf("Invalid change on NATS subject %s: %v", c.subject, err)
+			return
+		}
+		handler(message)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to NATS subject %s: %w", c.subject, err)
+	}
+	return nil
+}
+
+// Close drains and closes the NATS connection
+func (c *natsCluster) Close() {
+	c.conn.Drain()
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsEncoding reports whether a request's Accept-Encoding header allows a content coding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(accepted, ";")
+		coding = strings.TrimSpace(coding)
+		if coding != encoding && coding != "*" {
+			continue
+		}
+
+		// An explicit zero quality refuses the coding
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressionMiddleware compresses regular responses with gzip or deflate as
+// negotiated by the client. Subscriptions and WebSocket connections are
+// never compressed as a whole.
+func (s *BraidMockServer) compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.CompressResponses || r.Method == http.MethodHead || wantsStream(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var encoding string
+		switch {
+		case acceptsEncoding(r, "gzip"):
+			encoding = "gzip"
+		case acceptsEncoding(r, "deflate"):
+			encoding = "deflate"
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressResponseWriter compresses a response body once its headers show it is worth compressing
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+// WriteHeader starts compressing unless the response is empty, already
+// encoded or of an already compressed media type
+func (cw *compressResponseWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	if statusCode != http.StatusNoContent && statusCode != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		if cw.encoding == "gzip" {
+			cw.writer = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.writer = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes compressed data if compression started
+func (cw *compressResponseWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush flushes compressed data buffered so far to the client
+func (cw *compressResponseWriter) Flush() {
+	if cw.writer != nil {
+		if flusher, ok := cw.writer.(interface{ Flush() error }); ok {
+			flusher.Flush()
+		}
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the compressed stream
+func (cw *compressResponseWriter) Close() error {
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+// compressible reports whether responses of a media type benefit from compression
+func compressible(contentType string) bool {
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/octet-stream"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
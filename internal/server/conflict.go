@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"github.com/wI2L/jsondiff"
+)
+
+// conflictRequest describes a conflict to simulate: two concurrent edits of
+// a resource and the result of merging them. Values are JSON, and strings
+// for plain-text resources.
+type conflictRequest struct {
+	A      json.RawMessage `json:"a"`
+	B      json.RawMessage `json:"b"`
+	Merged json.RawMessage `json:"merged,omitempty"` // Defaults to the changes of b applied on top of a, for JSON resources
+}
+
+// handleAdminConflict sends the subscribers of a resource two updates made
+// concurrently from its current version, then an update merging them with
+// both as parents, so clients can be tested on resolving conflicts. The
+// merged content is written to the resource, so the conflict is resolved
+// for later requests too.
+func (s *BraidMockServer) handleAdminConflict(w http.ResponseWriter, r *http.Request) {
+	resourceID, ok := s.adminResource(w, r)
+	if !ok || !s.checkWriteAccess(w, r, auditAdmin, resourceID) {
+		return
+	}
+	meta := s.resourceMeta(resourceID)
+	if meta.dynamic() || s.isBinaryResource(resourceID) {
+		http.Error(w, "Conflicts can only be simulated for JSON and plain-text mock files", http.StatusBadRequest)
+		return
+	}
+
+	var conflict conflictRequest
+	if err := json.NewDecoder(r.Body).Decode(&conflict); err != nil {
+		http.Error(w, "Invalid conflict: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if conflict.A == nil || conflict.B == nil {
+		http.Error(w, "Conflict must have a and b", http.StatusBadRequest)
+		return
+	}
+
+	base, baseVersion, err := s.readResource(nil, resourceID)
+	if err != nil {
+		http.Error(w, "Error reading resource: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a, err := s.conflictContent(resourceID, conflict.A)
+	if err != nil {
+		http.Error(w, "Invalid a: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := s.conflictContent(resourceID, conflict.B)
+	if err != nil {
+		http.Error(w, "Invalid b: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var merged []byte
+	if conflict.Merged != nil {
+		merged, err = s.conflictContent(resourceID, conflict.Merged)
+	} else {
+		merged, err = s.mergeConflict(resourceID, base, a, b)
+	}
+	if err != nil {
+		http.Error(w, "Invalid merged: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.validateWrite(resourceID, merged); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Both edits build on the current version, and the merge on both edits
+	versionA, versionB, versionMerged := s.hasher.Hash(a), s.hasher.Hash(b), s.hasher.Hash(merged)
+	updateA := s.changeUpdate(resourceID, baseVersion, versionA, base, a)
+	updateB := s.changeUpdate(resourceID, baseVersion, versionB, base, b)
+	updateMerged := braidproto.Update{
+		Version: []string{versionMerged},
+		Parents: []string{versionA, versionB},
+		Body:    string(merged),
+	}
+
+	// The merge takes the sequence of the change event of writing it, which
+	// there is none of if the merge undoes both edits
+	sent := 0
+	unlock := s.sequences.lock(resourceID)
+	updateA.Sequence = s.sequences.next(resourceID)
+	updateB.Sequence = s.sequences.next(resourceID)
+	if versionMerged == baseVersion {
+		updateMerged.Sequence = s.sequences.next(resourceID)
+	} else {
+		updateMerged.Sequence = s.sequences.current(resourceID) + 1
+	}
+	for _, update := range []braidproto.Update{updateA, updateB, updateMerged} {
+		update.MergeType = meta.MergeType
+		sent = s.broadcastUpdate(resourceID, update)
+		s.auditUpdate(r, auditAdmin, resourceID, update)
+		s.shareChange(clusterMessage{Kind: clusterUpdate, Resource: resourceID, Update: &update})
+	}
+	unlock()
+	logf(requestID(r), "Simulated conflict %s and %s merged into %s for resource %s", versionA, versionB, versionMerged, resourceID)
+
+	// Subscribers already have the merged content, so its change event skips them
+	if err := s.writeResource(nil, auditAdmin, resourceID, merged); err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resource":    resourceID,
+		"base":        baseVersion,
+		"a":           versionA,
+		"b":           versionB,
+		"merged":      versionMerged,
+		"subscribers": sent,
+	})
+}
+
+// conflictContent returns the content of a resource given as a value in a
+// conflict, a string for plain-text resources
+func (s *BraidMockServer) conflictContent(resourceID string, value json.RawMessage) ([]byte, error) {
+	if !s.isTextResource(resourceID) {
+		return value, nil
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return nil, fmt.Errorf("plain-text resources take strings")
+	}
+	return []byte(text), nil
+}
+
+// mergeConflict merges two concurrent edits of a JSON resource by applying
+// the changes of b on top of a
+func (s *BraidMockServer) mergeConflict(resourceID string, base, a, b []byte) ([]byte, error) {
+	if s.resourceType(resourceID) != "" {
+		return nil, fmt.Errorf("plain-text resources need merged content")
+	}
+	patch, err := jsondiff.CompareJSON(base, b)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := applyJSONPatch(a, patch)
+	if err != nil {
+		return nil, fmt.Errorf("changes of b don't apply to a, merged content is needed: %w", err)
+	}
+	return merged, nil
+}
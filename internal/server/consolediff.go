@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"golang.org/x/term"
+)
+
+// ANSI colors of the patches printed to the console
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// printDiff prints a change to a resource to the console as the patches sent
+// to its subscribers, if enabled, so developers see what update an edit to a
+// mock file produced
+func (s *BraidMockServer) printDiff(resourceID string, update braidproto.Update) {
+	if !s.config.PrintDiffs {
+		return
+	}
+
+	color := consoleColors()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Resource %s changed from %s to %s", resourceID, braidproto.FormatVersions(update.Parents), braidproto.FormatVersions(update.Version))
+	if len(update.Patches) == 0 {
+		b.WriteString("\n" + colored(color, colorYellow, "= "+update.Body))
+	}
+	for _, patch := range update.Patches {
+		for _, line := range diffLines(patch) {
+			b.WriteString("\n" + colored(color, diffColor(line), line))
+		}
+	}
+	log.Print(b.String())
+}
+
+// diffLines describes a patch line by line, one line per operation, each
+// starting with + for additions, - for removals and ~ for other changes
+func diffLines(patch braidproto.Patch) []string {
+	switch patch.Unit {
+	case config.PatchFormatJSONPatch:
+		var operations []struct {
+			Op    string          `json:"op"`
+			Path  string          `json:"path"`
+			From  string          `json:"from"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal([]byte(patch.Content), &operations); err != nil {
+			return []string{"~ " + patch.Content}
+		}
+		var lines []string
+		for _, op := range operations {
+			value := string(op.Value)
+			if op.From != "" {
+				value = "from " + op.From
+			}
+			lines = append(lines, diffLine(op.Op, op.Path, value))
+		}
+		return lines
+
+	case "text":
+		return []string{diffLine("text", patch.Range, fmt.Sprintf("%q", patch.Content))}
+
+	default:
+		return []string{diffLine(patch.Unit, patch.Range, patch.Content)}
+	}
+}
+
+// diffLine describes a single operation of a patch
+func diffLine(op, path, value string) string {
+	sign := "~"
+	switch op {
+	case "add":
+		sign = "+"
+	case "remove":
+		sign = "-"
+	}
+
+	line := sign + " " + op
+	if path != "" {
+		line += " " + path
+	}
+	if op != "remove" && value != "" {
+		line += " " + value
+	}
+	return line
+}
+
+// diffColor returns the color of a line describing a patch operation
+func diffColor(line string) string {
+	switch line[0] {
+	case '+':
+		return colorGreen
+	case '-':
+		return colorRed
+	default:
+		return colorYellow
+	}
+}
+
+// colored wraps text in an ANSI color if colors are enabled
+func colored(enabled bool, color, text string) string {
+	if !enabled {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// consoleColors reports whether the log is written to a terminal that colors
+// can be used on, as NO_COLOR can opt out of
+func consoleColors() bool {
+	f, ok := log.Writer().(*os.File)
+	return ok && term.IsTerminal(int(f.Fd())) && os.Getenv("NO_COLOR") == ""
+}
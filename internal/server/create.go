@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"github.com/wI2L/jsondiff"
+)
+
+// idField is the member of collection items holding their ID
+const idField = "id"
+
+// isCollection reports whether a resource is a JSON array that items can be
+// added to. Templates and scripted resources are computed, so they aren't.
+func (s *BraidMockServer) isCollection(r *http.Request, resourceID string) bool {
+	if s.resourceType(resourceID) != "" || s.resourceMeta(resourceID).dynamic() {
+		return false
+	}
+	data, err := s.readStoredResource(r, resourceID)
+	if err != nil {
+		return false
+	}
+	var items []interface{}
+	return json.Unmarshal(data, &items) == nil
+}
+
+// handleCreate adds the item POSTed to a collection, writing it as a child
+// resource of the collection and appending it to the collection, whose
+// subscribers get the insert as a patch. It returns false, leaving the
+// request to be served like a GET, if the resource isn't a collection.
+func (s *BraidMockServer) handleCreate(w http.ResponseWriter, r *http.Request, resourceID string) bool {
+	if !s.isCollection(r, resourceID) {
+		return false
+	}
+	if !s.checkWriteAccess(w, r, auditClient, resourceID) {
+		return true
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading body: "+err.Error(), bodyErrorStatus(err))
+		return true
+	}
+	var item map[string]interface{}
+	if err := json.Unmarshal(body, &item); err != nil {
+		http.Error(w, "Invalid item: body must be a JSON object", http.StatusBadRequest)
+		return true
+	}
+
+	collection, err := s.readStoredResource(r, resourceID)
+	if err != nil {
+		s.writeError(w, fmt.Sprintf("Error reading resource: %v", err), http.StatusInternalServerError)
+		return true
+	}
+
+	// Items keep an ID they were sent with, or get the next one
+	if _, ok := item[idField]; !ok {
+		item[idField] = s.nextItemID(collection)
+	}
+	childID := strings.TrimSuffix(resourceID, "/") + "/" + url.PathEscape(fmt.Sprint(item[idField]))
+	if s.resourceExists(r, childID) || hasItem(collection, item[idField]) {
+		http.Error(w, fmt.Sprintf("Resource %s already exists", childID), http.StatusConflict)
+		return true
+	}
+	if !s.checkWriteAccess(w, r, auditClient, childID) {
+		return true
+	}
+
+	child, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		s.writeError(w, fmt.Sprintf("Error encoding item: %v", err), http.StatusInternalServerError)
+		return true
+	}
+	child = append(child, '\n')
+	collection, err = applyJSONPatch(collection, jsondiff.Patch{{Type: jsondiff.OperationAdd, Path: "/-", Value: item}})
+	if err != nil {
+		s.writeError(w, fmt.Sprintf("Error adding item: %v", err), http.StatusInternalServerError)
+		return true
+	}
+
+	// Check both writes before making either, so a rejected item leaves no trace
+	if err := s.validateWrite(childID, child); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return true
+	}
+	if err := s.validateWrite(resourceID, collection); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return true
+	}
+	if err := s.writeResource(r, auditClient, childID, child); err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if err := s.writeResource(r, auditClient, resourceID, collection); err != nil {
+		s.writeError(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	logf(requestID(r), "Created resource %s in collection %s", childID, resourceID)
+
+	w.Header().Set("Location", childID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Version", braidproto.FormatVersions([]string{s.hasher.Hash(child)}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(child)))
+	w.WriteHeader(http.StatusCreated)
+	w.Write(child)
+	return true
+}
+
+// nextItemID returns the ID of an item added to a collection: one more than
+// the highest ID if the items have numeric IDs, or else a generated one
+func (s *BraidMockServer) nextItemID(collection []byte) interface{} {
+	var items []map[string]interface{}
+	if err := json.Unmarshal(collection, &items); err != nil {
+		return s.ids.NewID()
+	}
+
+	highest := 0.0
+	for _, item := range items {
+		switch id := item[idField].(type) {
+		case float64:
+			highest = max(highest, id)
+		case nil:
+		default:
+			return s.ids.NewID()
+		}
+	}
+	return int64(highest) + 1
+}
+
+// hasItem reports whether a collection has an item with an ID
+func hasItem(collection []byte, id interface{}) bool {
+	var items []map[string]interface{}
+	if err := json.Unmarshal(collection, &items); err != nil {
+		return false
+	}
+	for _, item := range items {
+		if item[idField] == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//go:embed ui/index.html
+var dashboardHTML string
+
+//go:embed ui/edit.html
+var editorHTML string
+
+// dashboardTemplate renders the dashboard page with the admin API location
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+// editorTemplate renders the editor page for a single resource
+var editorTemplate = template.Must(template.New("editor").Parse(editorHTML))
+
+// handleDashboard serves the single-page web dashboard
+func (s *BraidMockServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, struct {
+		AdminPrefix string
+		EditPath    string
+	}{
+		AdminPrefix: s.config.Admin.Prefix,
+		EditPath:    s.config.Admin.EditPath,
+	})
+	if err != nil {
+		log.Printf("Error rendering dashboard: %v", err)
+	}
+}
+
+// handleEditor serves the editor page for the resource named by the rest of
+// the path on GET, and saves the edited body back to its .braid file on PUT
+func (s *BraidMockServer) handleEditor(w http.ResponseWriter, r *http.Request) {
+	resourceID := strings.TrimPrefix(r.URL.Path, s.config.Admin.EditPath)
+	if !s.store.Exists(resourceID) {
+		s.logRejectedPath(r, resourceID)
+		http.Error(w, "Resource not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		if !s.checkWriteAccess(w, r, auditAdmin, resourceID) {
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading body: "+err.Error(), bodyErrorStatus(err))
+			return
+		}
+		if err := s.writeResource(nil, auditAdmin, resourceID, data); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := editorTemplate.Execute(w, struct {
+		Resource string
+		Text     bool
+	}{
+		Resource: resourceID,
+		Text:     s.isTextResource(resourceID),
+	})
+	if err != nil {
+		log.Printf("Error rendering editor: %v", err)
+	}
+}
+
+// writeResource validates a new body for a resource and writes it to the
+// store, which then reports the change to subscribers. Requests in a session
+// write the session's copy instead, and nil requests always write the store.
+// The write is recorded in the audit log as coming from source.
+func (s *BraidMockServer) writeResource(r *http.Request, source, resourceID string, data []byte) error {
+	if err := s.validateWrite(resourceID, data); err != nil {
+		return err
+	}
+
+	if session := s.requestSession(r); session != "" {
+		return s.writeSessionResource(r, source, session, resourceID, data)
+	}
+
+	s.expectWrite(r, source, resourceID, data)
+	if err := s.store.Write(resourceID, data); err != nil {
+		return fmt.Errorf("error writing resource: %w", err)
+	}
+
+	log.Printf("Resource %s written", resourceID)
+	return nil
+}
+
+// validateWrite checks that a new body for a resource is valid JSON, if the
+// resource is JSON, and matches the resource's schema
+func (s *BraidMockServer) validateWrite(resourceID string, data []byte) error {
+	if s.resourceType(resourceID) == "" && !json.Valid(data) {
+		return fmt.Errorf("invalid JSON body")
+	}
+	return s.validateSchema(resourceID, data)
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/utils"
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"github.com/wI2L/jsondiff"
+)
+
+// patchMediaTypes maps patch media types clients may accept to patch formats
+var patchMediaTypes = map[string]string{
+	"application/json-patch+json":  config.PatchFormatJSONPatch,
+	"application/merge-patch+json": config.PatchFormatMergePatch,
+}
+
+// identifierPattern matches object keys that can be written as .key in Braid json ranges
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// requestedPatchFormat returns the patch format a subscriber asks for with an
+// Accept-Patch header, either as a media type or a format name, or an empty
+// string to use the resource's format
+func requestedPatchFormat(r *http.Request) string {
+	for _, accepted := range strings.Split(r.Header.Get("Accept-Patch"), ",") {
+		accepted = strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+		if format, ok := patchMediaTypes[accepted]; ok {
+			return format
+		}
+		if config.IsPatchFormat(accepted) {
+			return accepted
+		}
+	}
+	return ""
+}
+
+// diffResource returns the patches turning one state of a resource into
+// another, as text ranges for plain-text resources and JSON patches in the
+// given format otherwise
+func (s *BraidMockServer) diffResource(resourceID, format string, oldData, newData []byte) ([]braidproto.Patch, error) {
+	if s.isBinaryResource(resourceID) {
+		return nil, fmt.Errorf("binary resource %s can't be patched", resourceID)
+	}
+
+	if s.isTextResource(resourceID) {
+		start, end, replacement, changed := utils.DiffText(string(oldData), string(newData))
+		if !changed {
+			return nil, nil
+		}
+		return []braidproto.Patch{{
+			Unit:    "text",
+			Range:   fmt.Sprintf("[%d:%d]", start, end),
+			Content: replacement,
+		}}, nil
+	}
+
+	if format == config.PatchFormatMergePatch {
+		return mergePatch(oldData, newData)
+	}
+
+	patchOperations, err := jsondiff.CompareJSON(oldData, newData, s.diffOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if len(patchOperations) == 0 {
+		return nil, nil
+	}
+
+	switch format {
+	case config.PatchFormatJSONPatch:
+		// The whole RFC 6902 document is sent as a single patch
+		content, err := json.Marshal(patchOperations)
+		if err != nil {
+			return nil, err
+		}
+		return []braidproto.Patch{{Unit: config.PatchFormatJSONPatch, Content: string(content)}}, nil
+
+	case config.PatchFormatJSON:
+		return jsonRangePatches(patchOperations, oldData, newData)
+
+	default:
+		var patches []braidproto.Patch
+		for _, op := range patchOperations {
+			valueJSON, _ := json.Marshal(op.Value)
+			patches = append(patches, braidproto.Patch{
+				Unit:    op.Type,
+				Range:   op.Path,
+				Content: string(valueJSON),
+			})
+		}
+		return patches, nil
+	}
+}
+
+// diffOptions returns the configured options for computing JSON patches
+func (s *BraidMockServer) diffOptions() []jsondiff.Option {
+	var options []jsondiff.Option
+	if s.config.Braid.Diff.LCS {
+		options = append(options, jsondiff.LCS())
+	}
+	if s.config.Braid.Diff.Invertible {
+		options = append(options, jsondiff.Invertible())
+	}
+	if s.config.Braid.Diff.Equivalent {
+		options = append(options, jsondiff.Equivalent())
+	}
+	if s.config.Braid.Diff.Rationalize {
+		options = append(options, jsondiff.Rationalize())
+	}
+	return options
+}
+
+// jsonRangePatches converts JSON Patch operations to Braid json range
+// patches, which replace the value at a range with their content or remove it
+// if the content is empty. Insertions into arrays are splices of an empty
+// range like [2:2]. Ranges refer to the document as left by the previous patches.
+func jsonRangePatches(patchOperations jsondiff.Patch, oldData, newData []byte) ([]braidproto.Patch, error) {
+	var doc interface{}
+	if err := json.Unmarshal(oldData, &doc); err != nil {
+		return nil, err
+	}
+
+	var patches []braidproto.Patch
+	for _, op := range patchOperations {
+		// Braid ranges have no counterpart to tests of the old value
+		if op.Type == jsondiff.OperationTest {
+			continue
+		}
+
+		tokens, err := utils.ParsePointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		segments, index, err := jsonRange(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		patch := braidproto.Patch{Unit: config.PatchFormatJSON, Range: strings.Join(segments, "")}
+		valueJSON, _ := json.Marshal(op.Value)
+		switch op.Type {
+		case jsondiff.OperationAdd:
+			patch.Content = string(valueJSON)
+			if index >= 0 {
+				// Insert before the index instead of replacing the element there
+				patch.Range = strings.Join(segments[:len(segments)-1], "") + fmt.Sprintf("[%d:%d]", index, index)
+				patch.Content = "[" + string(valueJSON) + "]"
+			}
+		case jsondiff.OperationReplace:
+			patch.Content = string(valueJSON)
+		case jsondiff.OperationRemove:
+		default:
+			return nil, fmt.Errorf("unsupported operation %q", op.Type)
+		}
+		patches = append(patches, patch)
+
+		if doc, err = applyOperation(doc, tokens, op); err != nil {
+			return nil, err
+		}
+	}
+	return patches, nil
+}
+
+// jsonRange converts JSON Pointer tokens to the segments of a Braid json
+// range such as .a.b[0], using the document to tell array indexes from object
+// keys. It also returns the index the last token refers to if it is an array
+// index, or -1 otherwise.
+func jsonRange(doc interface{}, tokens []string) ([]string, int, error) {
+	var segments []string
+	lastIndex := -1
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case []interface{}:
+			index := len(node) // "-" refers to the end of the array
+			if token != "-" {
+				var err error
+				if index, err = strconv.Atoi(token); err != nil {
+					return nil, -1, fmt.Errorf("invalid array index %q", token)
+				}
+			}
+			segments = append(segments, fmt.Sprintf("[%d]", index))
+			doc, lastIndex = nil, index
+			if index < len(node) {
+				doc = node[index]
+			}
+		default:
+			if identifierPattern.MatchString(token) {
+				segments = append(segments, "."+token)
+			} else {
+				key, _ := json.Marshal(token)
+				segments = append(segments, fmt.Sprintf("[%s]", key))
+			}
+			doc, lastIndex = nil, -1
+			if object, ok := node.(map[string]interface{}); ok {
+				doc = object[token]
+			}
+		}
+	}
+	return segments, lastIndex, nil
+}
+
+// applyOperation applies an add, remove or replace operation at the location
+// of the given tokens to a decoded JSON document and returns the result
+func applyOperation(doc interface{}, tokens []string, op jsondiff.Operation) (interface{}, error) {
+	if len(tokens) == 0 {
+		return op.Value, nil
+	}
+
+	token := tokens[0]
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(tokens) > 1 {
+			child, err := applyOperation(node[token], tokens[1:], op)
+			node[token] = child
+			return node, err
+		}
+		if op.Type == jsondiff.OperationRemove {
+			delete(node, token)
+		} else {
+			node[token] = op.Value
+		}
+		return node, nil
+
+	case []interface{}:
+		index := len(node)
+		if token != "-" {
+			var err error
+			if index, err = strconv.Atoi(token); err != nil || index < 0 || index > len(node) {
+				return nil, fmt.Errorf("invalid array index %q", token)
+			}
+		}
+		if len(tokens) > 1 {
+			if index >= len(node) {
+				return nil, fmt.Errorf("index %q out of range", token)
+			}
+			child, err := applyOperation(node[index], tokens[1:], op)
+			node[index] = child
+			return node, err
+		}
+		switch op.Type {
+		case jsondiff.OperationAdd:
+			node = append(node[:index], append([]interface{}{op.Value}, node[index:]...)...)
+		case jsondiff.OperationRemove:
+			if index >= len(node) {
+				return nil, fmt.Errorf("index %q out of range", token)
+			}
+			node = append(node[:index], node[index+1:]...)
+		default:
+			if index >= len(node) {
+				return nil, fmt.Errorf("index %q out of range", token)
+			}
+			node[index] = op.Value
+		}
+		return node, nil
+
+	default:
+		return nil, fmt.Errorf("cannot descend into %q", token)
+	}
+}
+
+// mergePatch returns the RFC 7386 merge patch turning one JSON document into
+// another as a single patch
+func mergePatch(oldData, newData []byte) ([]braidproto.Patch, error) {
+	var oldDoc, newDoc interface{}
+	if err := json.Unmarshal(oldData, &oldDoc); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(newData, &newDoc); err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(oldDoc, newDoc) {
+		return nil, nil
+	}
+
+	content, err := json.Marshal(mergeDiff(oldDoc, newDoc))
+	if err != nil {
+		return nil, err
+	}
+	return []braidproto.Patch{{Unit: config.PatchFormatMergePatch, Content: string(content)}}, nil
+}
+
+// mergeDiff returns the merge patch between two decoded JSON values. Members
+// removed from objects become null, and any other change replaces the value.
+func mergeDiff(oldValue, newValue interface{}) interface{} {
+	oldObject, oldIsObject := oldValue.(map[string]interface{})
+	newObject, newIsObject := newValue.(map[string]interface{})
+	if !oldIsObject || !newIsObject {
+		return newValue
+	}
+
+	patch := make(map[string]interface{})
+	for key := range oldObject {
+		if _, exists := newObject[key]; !exists {
+			patch[key] = nil
+		}
+	}
+	for key, value := range newObject {
+		if oldMember, exists := oldObject[key]; !exists || !reflect.DeepEqual(oldMember, value) {
+			patch[key] = mergeDiff(oldMember, value)
+		}
+	}
+	return patch
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// writeError responds with an error like http.Error, but serves the
+// configured fixture file as the body of 404 and 5xx responses so the mock
+// returns the same error envelope as the real API
+func (s *BraidMockServer) writeError(w http.ResponseWriter, message string, status int) {
+	var fixture string
+	switch {
+	case status == http.StatusNotFound:
+		fixture = s.config.Errors.NotFound
+	case status >= http.StatusInternalServerError:
+		fixture = s.config.Errors.ServerError
+	}
+	if fixture == "" {
+		http.Error(w, message, status)
+		return
+	}
+
+	body, err := os.ReadFile(fixture)
+	if err != nil {
+		logf(w.Header().Get(requestIDHeader), "Error reading error fixture %s: %v", fixture, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	logf(w.Header().Get(requestIDHeader), "Responding with %d from %s: %s", status, fixture, message)
+	w.Header().Del("Content-Length")
+	if json.Valid(body) {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// changeDebounce is how long a mock file must go without writes before its
+// change is reported, so that a file being truncated and then written isn't
+// reported empty in between
+const changeDebounce = 20 * time.Millisecond
+
+// fileStore serves resources from mock files in the root directory and the
+// configured mounts, watching them for changes
+type fileStore struct {
+	config   *config.Config
+	ignore   []string // Patterns of files and directories that aren't watched or served
+	mounts   []mount  // Directories mock files are served from, the root directory last
+	watcher  *fsnotify.Watcher
+	onChange func(resourceID string, data []byte)
+	onMeta   func(resourceID string) // Called when a sidecar file changes, if set
+	done     chan struct{}           // Closed when the store is closed
+}
+
+// newFileStore creates a store for the configured mock directories
+func newFileStore(config *config.Config) (*fileStore, error) {
+	// Load patterns of files to ignore
+	ignore, err := loadIgnorePatterns(config.RootDir, config.Ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create file watcher
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	return &fileStore{
+		config:  config,
+		ignore:  ignore,
+		mounts:  newMounts(config),
+		watcher: watcher,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Stat returns the ID and type of a resource's mock file
+func (s *fileStore) Stat(resourceID string) (ResourceInfo, error) {
+	path, ok := s.getPathFromResourceID(resourceID)
+	if !ok || s.isIgnored(path) {
+		return ResourceInfo{}, os.ErrNotExist
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+
+	id, err := s.getResourceIDFromPath(path)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+	return ResourceInfo{ID: id, Type: fileExtension(path), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Read returns the content of a resource's mock file
+func (s *fileStore) Read(resourceID string) ([]byte, error) {
+	path, ok := s.getPathFromResourceID(resourceID)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(path)
+}
+
+// Open opens a resource's mock file for reading
+func (s *fileStore) Open(resourceID string) (io.ReadCloser, error) {
+	path, ok := s.getPathFromResourceID(resourceID)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(path)
+}
+
+// ReadMeta returns the content of a resource's sidecar settings file
+func (s *fileStore) ReadMeta(resourceID string) ([]byte, error) {
+	basePath, ok := s.resourceBasePath(resourceID)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(basePath + metaSuffix)
+}
+
+// Write replaces the content of a resource's mock file, which the watcher
+// then reports like any other edit. Directories created for new resources
+// are watched like the others.
+func (s *fileStore) Write(resourceID string, data []byte) error {
+	path, ok := s.getPathFromResourceID(resourceID)
+	if !ok {
+		return fmt.Errorf("invalid resource path: %s", resourceID)
+	}
+	var created []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		created = append(created, dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	for _, dir := range created {
+		if err := s.watcher.Add(dir); err != nil {
+			log.Printf("Error watching %s: %v", dir, err)
+		}
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Close stops watching the mock files
+func (s *fileStore) Close() error {
+	close(s.done)
+	return s.watcher.Close()
+}
+
+// Watch recursively adds the directories of all mounts to the watcher, or
+// starts polling for changes when file system events aren't available
+func (s *fileStore) Watch(onChange func(resourceID string, data []byte)) error {
+	s.onChange = onChange
+	if s.config.WatchPoll {
+		return s.startPolling()
+	}
+
+	go s.watchFiles()
+	for _, m := range s.mounts {
+		err := walkFiles(m.Dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			if s.isIgnored(path) || s.outsideMounts(path) {
+				return filepath.SkipDir
+			}
+			return s.watcher.Add(path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchFiles monitors file changes and reports changed resources once their
+// mock files have gone changeDebounce without further writes
+func (s *fileStore) watchFiles() {
+	pending := make(map[string]*time.Timer)
+	due := make(chan string)
+
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+
+			// Sidecar files are only read when needed, so any change to
+			// them is reported right away
+			if strings.HasSuffix(event.Name, metaSuffix) {
+				s.handleMetaChange(event.Name)
+				continue
+			}
+
+			// Only process mock file writes
+			if !s.isResourceFile(event.Name) || s.isIgnored(event.Name) || event.Op&fsnotify.Write != fsnotify.Write {
+				continue
+			}
+			if timer, exists := pending[event.Name]; exists {
+				timer.Reset(changeDebounce)
+				continue
+			}
+			path := event.Name
+			pending[path] = time.AfterFunc(changeDebounce, func() {
+				select {
+				case due <- path:
+				case <-s.done:
+				}
+			})
+
+		case path := <-due:
+			delete(pending, path)
+			s.handleFileChange(path)
+
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+		}
+	}
+}
+
+// handleFileChange reads a changed mock file and reports the new state of its resource
+func (s *fileStore) handleFileChange(path string) {
+	// Get resource ID from file path
+	resourceID, err := s.getResourceIDFromPath(path)
+	if err != nil {
+		log.Printf("Error determining resource ID: %v", err)
+		return
+	}
+
+	log.Printf("File changed: %s, resourceID: %s", path, resourceID)
+
+	// Read updated content
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Error reading file: %v", err)
+		return
+	}
+
+	s.onChange(resourceID, data)
+}
+
+// handleMetaChange reports the resource of a changed or deleted sidecar file
+func (s *fileStore) handleMetaChange(path string) {
+	if s.onMeta == nil || s.isIgnored(path) {
+		return
+	}
+	resourceID, err := s.getResourceIDFromPath(strings.TrimSuffix(path, metaSuffix) + resourceSuffix)
+	if err != nil {
+		return
+	}
+	s.onMeta(resourceID)
+}
+
+// WatchMeta calls onChange with the resources whose sidecar files changed,
+// once Watch has started
+func (s *fileStore) WatchMeta(onChange func(resourceID string)) {
+	s.onMeta = onChange
+}
+
+// getResourceIDFromPath converts a file path to a resource ID
+func (s *fileStore) getResourceIDFromPath(path string) (string, error) {
+	// Make the path relative to the directory of its mount
+	m, relPath, ok := s.mountForPath(path)
+	if !ok {
+		return "", fmt.Errorf("%s is outside the mock directories", path)
+	}
+
+	// Remove the mock file extension
+	resourceID := relPath
+	if base, _, ok := s.mockFileBase(relPath); ok {
+		resourceID = base
+	}
+
+	// Convert Windows path separators to URL path separators
+	resourceID = strings.ReplaceAll(resourceID, "\\", "/")
+
+	// Index files stand for their directory, e.g. users/index.braid for /users/
+	if resourceID == s.config.IndexName || strings.HasSuffix(resourceID, "/"+s.config.IndexName) {
+		resourceID = strings.TrimSuffix(resourceID, s.config.IndexName)
+	}
+
+	// Ensure the path starts with /
+	if !strings.HasPrefix(resourceID, "/") {
+		resourceID = "/" + resourceID
+	}
+
+	return m.Prefix + resourceID, nil
+}
+
+// resourceBasePath returns the path of a resource's files without their
+// suffix, which is the directory's index file for paths ending in a slash.
+// Resource IDs that could resolve outside their mock directory, such as ones
+// with .. elements or encoded separators from query parameters, and hidden
+// files are refused.
+func (s *fileStore) resourceBasePath(resourceID string) (string, bool) {
+	if s.pathReason(resourceID) != "" {
+		return "", false
+	}
+	m, relPath := s.mountForResource(resourceID)
+	if relPath == "" || strings.HasSuffix(relPath, "/") {
+		relPath += s.config.IndexName
+	}
+	return filepath.Join(m.Dir, relPath), true
+}
+
+// getPathFromResourceID converts a resource ID to the path of its mock file,
+// failing for resource IDs refused by resourceBasePath and for mock files
+// that symlinks resolve outside the mock directories
+func (s *fileStore) getPathFromResourceID(resourceID string) (string, bool) {
+	path, ok := s.findMockFile(resourceID)
+	if !ok || s.outsideMounts(path) {
+		return "", false
+	}
+	return path, true
+}
+
+// findMockFile returns the path of a resource's mock file, which is a .braid
+// file unless only a file with another extension exists. It fails for
+// resource IDs refused by resourceBasePath.
+func (s *fileStore) findMockFile(resourceID string) (string, bool) {
+	basePath, ok := s.resourceBasePath(resourceID)
+	if !ok {
+		return "", false
+	}
+	if _, err := os.Stat(basePath + resourceSuffix); err == nil {
+		return basePath + resourceSuffix, true
+	}
+
+	// Fall back to the first typed mock file, e.g. name.braid.txt or name.braid.png,
+	// then to a .json fixture, preferring names that match in case
+	dir, name := filepath.Split(basePath)
+	if s.config.CaseInsensitive {
+		dir = s.matchCase(dir)
+	}
+	best, bestRank := "", -1
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() || s.isIgnored(filepath.Join(dir, entry.Name())) {
+				continue
+			}
+			base, rank, ok := s.mockFileBase(entry.Name())
+			switch {
+			case !ok:
+				continue
+			case base == name:
+			case s.config.CaseInsensitive && strings.EqualFold(base, name):
+				rank += 3
+			default:
+				continue
+			}
+			if bestRank < 0 || rank < bestRank {
+				best, bestRank = filepath.Join(dir, entry.Name()), rank
+			}
+		}
+	}
+	if best != "" {
+		return best, true
+	}
+	return basePath + resourceSuffix, true
+}
+
+// mockFileBase splits a mock file name into the name of the resource it
+// serves and its precedence among mock files of that resource, lowest first
+func (s *fileStore) mockFileBase(name string) (string, int, bool) {
+	switch ext := fileExtension(name); {
+	case strings.HasSuffix(name, resourceSuffix):
+		return strings.TrimSuffix(name, resourceSuffix), 0, true
+	case ext != "":
+		return strings.TrimSuffix(name, resourceSuffix+"."+ext), 1, true
+	case s.config.JSONFixtures && strings.HasSuffix(name, jsonFixtureSuffix):
+		return strings.TrimSuffix(name, jsonFixtureSuffix), 2, true
+	}
+	return "", 0, false
+}
+
+// matchCase returns a directory under a mock directory with each path
+// element matched case-insensitively against the existing directories
+func (s *fileStore) matchCase(dir string) string {
+	m, relPath, ok := s.mountForPath(dir)
+	if !ok || relPath == "." {
+		return dir
+	}
+
+	matched := m.Dir
+	for _, elem := range strings.Split(relPath, string(filepath.Separator)) {
+		next := filepath.Join(matched, elem)
+		if _, err := os.Stat(next); err != nil {
+			entries, _ := os.ReadDir(matched)
+			for _, entry := range entries {
+				if entry.IsDir() && strings.EqualFold(entry.Name(), elem) {
+					next = filepath.Join(matched, entry.Name())
+					break
+				}
+			}
+		}
+		matched = next
+	}
+	return matched
+}
+
+// isResourceFile reports whether a file is a mock file
+func (s *fileStore) isResourceFile(path string) bool {
+	_, _, ok := s.mockFileBase(filepath.Base(path))
+	return ok
+}
+
+// Exists checks if a mock file exists for the given resource ID
+func (s *fileStore) Exists(resourceID string) bool {
+	filePath, ok := s.getPathFromResourceID(resourceID)
+	if !ok || s.isIgnored(filePath) {
+		return false
+	}
+	_, err := os.Stat(filePath)
+	return err == nil
+}
+
+// List returns the IDs of all resources under a path prefix, across
+// all mounts. It fails if no mock directory exists under the prefix.
+func (s *fileStore) List(prefix string) ([]string, error) {
+	var resources []string
+	seen := make(map[string]bool)
+	found := false
+	for _, dir := range s.listingDirs(prefix) {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		found = true
+
+		err := walkFiles(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if s.isIgnored(path) || s.outsideMounts(path) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || !s.isResourceFile(path) {
+				return nil
+			}
+
+			resourceID, err := s.getResourceIDFromPath(path)
+			if err != nil {
+				return err
+			}
+
+			// A resource may have mock files with several extensions
+			if !seen[resourceID] {
+				seen[resourceID] = true
+				resources = append(resources, resourceID)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no mock directory under %s", prefix)
+	}
+
+	sort.Strings(resources)
+	return resources, nil
+}
+
+// fileExtension returns the extension of a typed mock file after ".braid.",
+// or an empty string for JSON mock files
+func fileExtension(path string) string {
+	base := filepath.Base(path)
+	if i := strings.LastIndex(base, resourceSuffix+"."); i >= 0 {
+		return base[i+len(resourceSuffix)+1:]
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"gihan9a/braidmock/internal/config"
+)
+
+// fsStore serves resources from mock files in an fs.FS such as an embed.FS.
+// The files can't change, so writes are kept in memory on top of them and
+// reported as changes right away instead of being watched for.
+type fsStore struct {
+	fsys      fs.FS
+	indexName string
+	mu        sync.RWMutex
+	written   map[string][]byte // Content written to mock files, by file name
+	onChange  func(resourceID string, data []byte)
+}
+
+// NewBraidMockServerFromFS creates a new BraidMockServer serving the mock
+// files in an fs.FS, e.g. fixtures embedded in a test binary. Resources are
+// changed with UpdateResource instead of by editing files.
+func NewBraidMockServerFromFS(config *config.Config, fsys fs.FS) (*BraidMockServer, error) {
+	server, err := NewBraidMockServerWithStore(config, newFSStore(fsys, config.IndexName))
+	if err != nil {
+		return nil, err
+	}
+	if err := server.SetupWatchers(); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return server, nil
+}
+
+// newFSStore creates a store for the mock files in an fs.FS
+func newFSStore(fsys fs.FS, indexName string) *fsStore {
+	return &fsStore{
+		fsys:      fsys,
+		indexName: indexName,
+		written:   make(map[string][]byte),
+	}
+}
+
+// fileName returns the name of a resource's mock file in the file system,
+// preferring a .braid file over typed mock files
+func (s *fsStore) fileName(resourceID string) (string, bool) {
+	base := strings.TrimPrefix(resourceID, "/")
+	if base == "" || strings.HasSuffix(base, "/") {
+		base += s.indexName
+	}
+	if !fs.ValidPath(base) {
+		return "", false
+	}
+
+	if _, err := fs.Stat(s.fsys, base+resourceSuffix); err == nil {
+		return base + resourceSuffix, true
+	}
+
+	// Fall back to the first typed mock file, e.g. name.braid.txt
+	dir, name := path.Split(base)
+	entries, err := fs.ReadDir(s.fsys, path.Clean("./"+dir))
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), name+resourceSuffix+".") {
+			return path.Join(dir, entry.Name()), true
+		}
+	}
+	return "", false
+}
+
+// resourceID converts the name of a mock file in the file system to a resource ID
+func (s *fsStore) resourceID(name string) string {
+	return mockFileResourceID(name, s.indexName)
+}
+
+// mockFileResourceID converts a slash-separated mock file name relative to
+// the mock directory to a resource ID
+func mockFileResourceID(name, indexName string) string {
+	resourceID := strings.TrimSuffix(name, resourceSuffix)
+	if ext := fileExtension(name); ext != "" {
+		resourceID = strings.TrimSuffix(name, resourceSuffix+"."+ext)
+	}
+	if resourceID == indexName || strings.HasSuffix(resourceID, "/"+indexName) {
+		resourceID = strings.TrimSuffix(resourceID, indexName)
+	}
+	return "/" + resourceID
+}
+
+// Stat returns the ID and type of a resource's mock file
+func (s *fsStore) Stat(resourceID string) (ResourceInfo, error) {
+	name, ok := s.fileName(resourceID)
+	if !ok {
+		return ResourceInfo{}, fs.ErrNotExist
+	}
+
+	s.mu.RLock()
+	data, written := s.written[name]
+	s.mu.RUnlock()
+	size := int64(len(data))
+	if !written {
+		info, err := fs.Stat(s.fsys, name)
+		if err != nil {
+			return ResourceInfo{}, err
+		}
+		size = info.Size()
+	}
+	return ResourceInfo{ID: s.resourceID(name), Type: fileExtension(name), Size: size}, nil
+}
+
+// Exists checks if a mock file exists for the given resource ID
+func (s *fsStore) Exists(resourceID string) bool {
+	_, ok := s.fileName(resourceID)
+	return ok
+}
+
+// Read returns the content last written to a resource, or its mock file's content
+func (s *fsStore) Read(resourceID string) ([]byte, error) {
+	name, ok := s.fileName(resourceID)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	s.mu.RLock()
+	data, written := s.written[name]
+	s.mu.RUnlock()
+	if written {
+		return data, nil
+	}
+	return fs.ReadFile(s.fsys, name)
+}
+
+// Open returns a reader for the content last written to a resource, or its mock file
+func (s *fsStore) Open(resourceID string) (io.ReadCloser, error) {
+	name, ok := s.fileName(resourceID)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	s.mu.RLock()
+	data, written := s.written[name]
+	s.mu.RUnlock()
+	if written {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return s.fsys.Open(name)
+}
+
+// ReadMeta returns the content of a resource's sidecar settings file
+func (s *fsStore) ReadMeta(resourceID string) ([]byte, error) {
+	base := strings.TrimPrefix(resourceID, "/")
+	if base == "" || strings.HasSuffix(base, "/") {
+		base += s.indexName
+	}
+	return fs.ReadFile(s.fsys, base+metaSuffix)
+}
+
+// Write replaces the content of an existing resource in memory and reports
+// the change right away
+func (s *fsStore) Write(resourceID string, data []byte) error {
+	name, ok := s.fileName(resourceID)
+	if !ok {
+		return fmt.Errorf("resource %s not found", resourceID)
+	}
+
+	s.mu.Lock()
+	s.written[name] = append([]byte(nil), data...)
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if onChange != nil {
+		onChange(s.resourceID(name), data)
+	}
+	return nil
+}
+
+// List returns the IDs of all resources under a path prefix
+func (s *fsStore) List(prefix string) ([]string, error) {
+	dir := path.Clean("./" + strings.TrimPrefix(prefix, "/"))
+
+	var resources []string
+	seen := make(map[string]bool)
+	err := fs.WalkDir(s.fsys, dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || (!strings.HasSuffix(name, resourceSuffix) && fileExtension(name) == "") {
+			return nil
+		}
+
+		// A resource may have mock files with several extensions
+		if resourceID := s.resourceID(name); !seen[resourceID] {
+			seen[resourceID] = true
+			resources = append(resources, resourceID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(resources)
+	return resources, nil
+}
+
+// Watch registers the function that writes are reported to
+func (s *fsStore) Watch(onChange func(resourceID string, data []byte)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = onChange
+	return nil
+}
+
+// Close does nothing, since the store holds no resources beyond memory
+func (s *fsStore) Close() error {
+	return nil
+}
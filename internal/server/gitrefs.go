@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// Clients ask for a resource as of a git revision of its mock file with the
+// "at" query parameter or the X-Git-Ref header, and are told the commit it
+// resolved to in the X-Git-Commit header. The revision "first" names the
+// first commit of the mock file.
+const (
+	gitRefParam     = "at"
+	gitRefHeader    = "X-Git-Ref"
+	gitCommitHeader = "X-Git-Commit"
+	gitFirstRef     = "first"
+)
+
+// diskStore is implemented by stores keeping mock files on disk
+type diskStore interface {
+	mockFilePath(resourceID string) (string, bool)
+	rejectionReason(resourceID string) string
+}
+
+// mockFilePath returns the path of a resource's mock file, if the resource
+// ID may be served
+func (s *fileStore) mockFilePath(resourceID string) (string, bool) {
+	return s.getPathFromResourceID(resourceID)
+}
+
+// requestedGitRef returns the git revision a request asks to see a resource at, if any
+func requestedGitRef(r *http.Request) string {
+	if ref := r.URL.Query().Get(gitRefParam); ref != "" {
+		return ref
+	}
+	return r.Header.Get(gitRefHeader)
+}
+
+// handleGitRequest serves a resource as its mock file was at a git revision.
+// Subscriptions start from that revision and are replayed the later commits
+// of the file, before receiving changes like any other subscription.
+func (s *BraidMockServer) handleGitRequest(w http.ResponseWriter, r *http.Request, resourceID, ref string) {
+	if _, ranged := requestedRange(r); ranged {
+		http.Error(w, "Ranges are not supported for git revisions", http.StatusBadRequest)
+		return
+	}
+
+	store, ok := s.store.(diskStore)
+	if !ok {
+		http.Error(w, "Git revisions are only supported for mock files on disk", http.StatusBadRequest)
+		return
+	}
+	filePath, ok := store.mockFilePath(resourceID)
+	if !ok {
+		s.logRejectedPath(r, resourceID)
+		s.writeError(w, "Resource not found", http.StatusNotFound)
+		return
+	}
+	dir, name := filepath.Split(filePath)
+
+	commit, err := resolveGitCommit(dir, name, ref)
+	if err != nil {
+		logf(requestID(r), "Error resolving git revision %q of %s: %v", ref, resourceID, err)
+		s.writeError(w, fmt.Sprintf("Unknown git revision: %s", ref), http.StatusNotFound)
+		return
+	}
+	data, err := gitFileAt(dir, commit, name)
+	if err != nil {
+		s.writeError(w, fmt.Sprintf("Resource not found at %s", ref), http.StatusNotFound)
+		return
+	}
+	hash := s.hasher.Hash(data)
+	s.recordVersions(resourceID, hash)
+
+	meta := s.resourceMeta(resourceID)
+	s.setResourceHeaders(w, resourceID, meta)
+	w.Header().Set(gitCommitHeader, commit)
+
+	if !wantsStream(r) {
+		w.Header().Set("Version", braidproto.FormatVersions([]string{hash}))
+		w.Header().Set("Parents", "")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(data)
+		return
+	}
+
+	if !s.reserveStream(w, r, resourceID, false) {
+		return
+	}
+	defer s.releaseStream(resourceID, false)
+
+	stream, ok := s.startStream(w, r, resourceID)
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	subID := s.AddSubscription(requestID(r), s.requestSession(r), resourceID, "", requestedPatchFormat(r), hash, w, stream, stream, data)
+	stream.Start(func(encoder updateEncoder) {
+		encoder.Encode(braidproto.Update{
+			Version:   []string{hash},
+			MergeType: meta.MergeType,
+			Body:      string(data),
+		})
+	})
+	go s.replayGitHistory(r, stream, resourceID, subID, dir, name, commit)
+
+	s.holdStream(r.Context(), requestID(r), r.URL.Path, stream)
+	s.RemoveSubscription(resourceID, subID)
+}
+
+// replayGitHistory sends a subscription the state of its resource at each
+// commit changing its mock file after the given one, and finally its current
+// state, pausing for the configured interval before each. Edits to the file
+// during the replay are sent as they happen.
+func (s *BraidMockServer) replayGitHistory(r *http.Request, stream *subscriberStream, resourceID, subID, dir, name, commit string) {
+	commits, err := gitCommitsAfter(dir, name, commit)
+	if err != nil {
+		logf(requestID(r), "Error listing git history of %s: %v", resourceID, err)
+		return
+	}
+	logf(requestID(r), "Replaying %d commits of %s to subscription %s", len(commits), resourceID, subID)
+
+	interval := time.Duration(s.config.Git.ReplayInterval) * time.Millisecond
+	for i := 0; i <= len(commits); i++ {
+		timer := s.clock.NewTimer(interval)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-stream.Failed():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		var data []byte
+		if i < len(commits) {
+			data, err = gitFileAt(dir, commits[i], name)
+		} else {
+			data, _, err = s.readResource(r, resourceID)
+		}
+		if err != nil {
+			// Commits deleting the mock file have nothing to replay
+			continue
+		}
+
+		sub, exists := s.subscriptions.get(resourceID, subID)
+		if !exists {
+			return
+		}
+		hash := s.hasher.Hash(data)
+		s.recordVersions(resourceID, hash)
+		s.notifySubscription(sub, s.resourceMeta(resourceID), data, hash)
+	}
+}
+
+// resolveGitCommit returns the commit a revision names in the repository of a file
+func resolveGitCommit(dir, name, ref string) (string, error) {
+	if ref == gitFirstRef {
+		commits, err := gitLog(dir, "--reverse", "--", name)
+		if err != nil {
+			return "", err
+		}
+		if len(commits) == 0 {
+			return "", errors.New("file has no commits")
+		}
+		return commits[0], nil
+	}
+
+	out, err := runGit(dir, "rev-parse", "--verify", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitCommitsAfter returns the commits changing a file after the given one
+// up to HEAD, oldest first
+func gitCommitsAfter(dir, name, commit string) ([]string, error) {
+	return gitLog(dir, "--reverse", commit+"..HEAD", "--", name)
+}
+
+// gitFileAt returns the content of a file at a commit
+func gitFileAt(dir, commit, name string) ([]byte, error) {
+	return runGit(dir, "show", commit+":./"+name)
+}
+
+// gitLog returns the hashes of the commits git log lists with the given arguments
+func gitLog(dir string, args ...string) ([]string, error) {
+	out, err := runGit(dir, append([]string{"log", "--format=%H"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// runGit runs a git command in a directory and returns its output
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
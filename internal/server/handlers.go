@@ -2,119 +2,483 @@ package server
 
 import (
 	"fmt"
-	"log"
 	"net/http"
-	"os"
+	"path"
+	"strconv"
+	"strings"
 
+	"gihan9a/braidmock/internal/config"
 	"gihan9a/braidmock/internal/utils"
+	"gihan9a/braidmock/pkg/braidproto"
 )
 
 // handleBraidRequest handles all Braid protocol requests
 func (s *BraidMockServer) handleBraidRequest(w http.ResponseWriter, r *http.Request) {
-	resourceID := r.URL.Path
+	// Wildcard subscriptions cover every resource under the path
+	if wantsStream(r) && isWildcardRequest(r) {
+		if s.config.CORS.Enabled {
+			s.addCORSHeaders(w, r)
+		}
+		s.handleWildcardSubscription(w, r)
+		return
+	}
+
+	// Resolve directory paths with or without a trailing slash
+	resourceID := s.resolveResourceID(r.URL.Path)
+	if resourceID != r.URL.Path && s.config.TrailingSlash == config.TrailingSlashRedirect && r.Method != http.MethodOptions {
+		target := resourceID
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
 
 	// Check if we have a local mock file for this resource
-	if !s.fileExists(resourceID) {
+	if !s.resourceExists(r, resourceID) {
+		s.logRejectedPath(r, resourceID)
+
+		// Browsers preflight requests before they know whether a resource
+		// exists, so answer preflights for proxied and missing resources too
+		if s.config.CORS.Enabled && isPreflightRequest(r) {
+			s.addCORSHeaders(w, r)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
 		// If not and we have a proxy configured, forward the request
 		if s.config.ProxyURL != nil {
-			log.Printf("Resource %s not found locally, proxying to %s", resourceID, s.config.ProxyURL.String())
+			logf(requestID(r), "Resource %s not found locally, proxying to %s", resourceID, s.config.ProxyURL.String())
 			s.proxyRequest(w, r)
 			return
 		}
 
 		// No proxy configured, return 404
-		http.Error(w, "Resource not found", http.StatusNotFound)
+		if s.config.CORS.Enabled {
+			s.addCORSHeaders(w, r)
+		}
+		s.writeError(w, "Resource not found", http.StatusNotFound)
 		return
 	}
 
 	// Add CORS headers for mock server responses if enabled
 	if s.config.CORS.Enabled {
 		s.addCORSHeaders(w, r)
+	}
 
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusNoContent)
-			return
+	// Answer preflight and capability requests alike
+	if r.Method == http.MethodOptions {
+		s.writeCapabilities(w, r, resourceID)
+		return
+	}
+
+	// Deleted resources are gone until written again
+	if s.isDeleted(r, resourceID) {
+		s.writeGone(w)
+		return
+	}
+	if r.Method == http.MethodDelete && s.config.Tombstones.Enabled {
+		if s.checkWriteAccess(w, r, auditClient, resourceID) {
+			s.handleDelete(w, r, resourceID)
 		}
+		return
+	}
+
+	// Stateful servers add items POSTed to collections
+	if r.Method == http.MethodPost && s.config.Stateful && s.handleCreate(w, r, resourceID) {
+		return
 	}
 
-	// Get path to the .braid file
-	filePath := s.getPathFromResourceID(resourceID)
+	// Serve the mock file as of a past commit if the client asks for one
+	if ref := requestedGitRef(r); ref != "" && s.config.Git.Enabled {
+		s.handleGitRequest(w, r, resourceID, ref)
+		return
+	}
 
-	// Read file content
-	data, err := os.ReadFile(filePath)
+	// Parse the versions the client refers to
+	requestVersion, err := braidproto.ParseVersions(r.Header.Get("Version"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid Version header: %v", err), http.StatusBadRequest)
+		return
+	}
+	requestParents, err := braidproto.ParseVersions(r.Header.Get("Parents"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading resource: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Invalid Parents header: %v", err), http.StatusBadRequest)
 		return
 	}
+	if version := r.URL.Query().Get(versionParam); version != "" {
+		requestVersion = []string{strings.Trim(version, `"`)}
+	}
 
-	// Calculate hash for the resource
-	hash := utils.CalculateHash(data)
+	// Read resource content, except for large resources requested as a
+	// whole, which are only hashed here and streamed from the store later
+	var data []byte
+	var hash string
+	if _, ranged := requestedRange(r); !wantsStream(r) && !ranged && s.isLargeResource(resourceID) && !s.sessions.has(s.requestSession(r), resourceID) {
+		hash, err = s.hashResource(resourceID)
+	} else {
+		data, hash, err = s.readResource(r, resourceID)
+	}
+	if err != nil {
+		s.writeError(w, fmt.Sprintf("Error reading resource: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	s.mu.Lock()
-	s.versions[resourceID] = hash
-	s.hashes[resourceID] = hash
-	s.mu.Unlock()
+	// Tell the client when it refers to versions this server has never produced
+	if unknown := s.unknownVersions(resourceID, append(requestVersion, requestParents...)); len(unknown) > 0 {
+		if s.config.Braid.UnknownVersion != config.UnknownVersionSnapshot {
+			logf(requestID(r), "Unknown versions %v requested for resource %s", unknown, resourceID)
+			w.Header().Set("Version", braidproto.FormatVersions([]string{hash}))
+			http.Error(w, fmt.Sprintf("Version unknown: %s", braidproto.FormatVersions(unknown)), braidproto.StatusVersionUnknown)
+			return
+		}
+		logf(requestID(r), "Unknown versions %v requested for resource %s, sending snapshot", unknown, resourceID)
+	}
 
 	// Set common headers
-	w.Header().Set("Range-Request-Allow-Methods", "PATCH, PUT")
-	w.Header().Set("Range-Request-Allow-Units", "json")
-	w.Header().Set("Content-Type", "application/json")
+	meta := s.resourceMeta(resourceID)
+	s.setResourceHeaders(w, resourceID, meta)
 
 	// Check if this is a subscription request
-	if r.Header.Get("Subscribe") == "true" || r.Header.Get("subscribe") == "true" {
-		// Ensure we can flush the response
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+	if wantsStream(r) {
+		// Subscribers may scope the subscription to a part of the resource
+		pointer, filtered := requestedRange(r)
+		if filtered {
+			if data, err = utils.ResolvePointer(data, pointer); err != nil {
+				http.Error(w, fmt.Sprintf("Range not satisfiable: %v", err), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", "json "+pointer)
+		}
+
+		if !s.reserveStream(w, r, resourceID, false) {
 			return
 		}
+		defer s.releaseStream(resourceID, false)
 
-		// Set headers for streaming
-		w.Header().Set("subscribe", "true")
-		w.Header().Set("cache-control", "no-cache, no-transform")
-		w.Header().Set("X-Accel-Buffering", "no")
-		w.WriteHeader(209) // 209 is the status code for a successful subscription
+		stream, ok := s.startStream(w, r, resourceID)
+		if !ok {
+			return
+		}
+		defer stream.Close()
 
 		// Add subscription
-		subID := s.AddSubscription(resourceID, w, flusher, data)
+		patchFormat := requestedPatchFormat(r)
+		subID := s.AddSubscription(requestID(r), s.requestSession(r), resourceID, pointer, patchFormat, hash, w, stream, stream, data)
 
-		// Send initial state
-		fmt.Fprintf(w, "Version: %s\r\n", hash)
-		fmt.Fprintf(w, "Parents: \r\n")
-		fmt.Fprintf(w, "Content-Length: %d\r\n", len(data))
-		fmt.Fprintf(w, "\r\n")
-		w.Write(data)
-		fmt.Fprintf(w, "\r\n\r\n\r\n\r\n\r\n")
-		flusher.Flush()
-
-		// Remove subscription when client disconnects
-		notify := r.Context().Done()
-		go func() {
-			<-notify
-			s.RemoveSubscription(resourceID, subID)
-		}()
-
-		// Keep the connection open until client disconnects
-		<-notify
+		// Clients that already hold a recent version catch up through the
+		// buffered updates they missed instead of a fresh snapshot, as long
+		// as the buffered patches are in the format they expect. Changes
+		// notified meanwhile are queued until these are written.
+		replay, replayed := s.replayUpdates(resourceID, requestParents)
+		sameFormat := patchFormat == "" || patchFormat == meta.PatchFormat
+		stream.Start(func(encoder updateEncoder) {
+			switch {
+			case len(requestParents) == 1 && requestParents[0] == hash:
+				// Clients that are already at the current version only wait for the next change
+				logf(requestID(r), "Subscription %s for resource %s is already at version %s", subID, resourceID, hash)
+			case replayed && !filtered && sameFormat && s.sendsPatches(resourceID, meta):
+				logf(requestID(r), "Replaying %d updates to subscription %s for resource %s", len(replay), subID, resourceID)
+				for _, update := range replay {
+					update.MergeType = meta.MergeType
+					encoder.Encode(update)
+				}
+			default:
+				// Send initial state
+				encoder.Encode(braidproto.Update{
+					Version:   []string{hash},
+					MergeType: meta.MergeType,
+					Body:      string(data),
+					Sequence:  s.sequences.current(resourceID),
+				})
+			}
+		})
+
+		// Keep the connection open until the subscription ends, then remove it
+		s.holdStream(r.Context(), requestID(r), r.URL.Path, stream)
+		s.RemoveSubscription(resourceID, subID)
 	} else {
+		// Clients may fetch a past version, e.g. to resolve a conflict
+		if len(requestVersion) == 1 && requestVersion[0] != hash {
+			if s.writeVersion(w, r, resourceID, requestVersion[0]) {
+				return
+			}
+			if s.config.Braid.UnknownVersion != config.UnknownVersionSnapshot {
+				w.Header().Set("Version", braidproto.FormatVersions([]string{hash}))
+				http.Error(w, fmt.Sprintf("Version no longer available: %s", braidproto.FormatVersions(requestVersion)), braidproto.StatusVersionUnknown)
+				return
+			}
+		}
+
 		// Regular GET request
-		w.Header().Set("Version", hash)
+		w.Header().Set("Version", braidproto.FormatVersions([]string{hash}))
 		w.Header().Set("Parents", "")
 
+		// Bodies rewritten by hooks are cached by clients as rewritten
+		etag := hash
+		if data != nil && len(s.hooks) > 0 {
+			data = s.hookResponse(r, resourceID, data)
+			etag = s.hasher.Hash(data)
+		}
+
+		// Collections with a page size are served a page at a time, each
+		// page being cached by clients separately
+		if _, ranged := requestedRange(r); meta.PageSize > 0 && data != nil && !ranged {
+			if data, err = paginate(w, r, data, meta); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			etag = s.hasher.Hash(data)
+		}
+		w.Header().Set("ETag", `"`+etag+`"`)
+
+		// Clients that already cache the current version don't need the body again
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		// Serve only the requested JSON sub-range if the client asked for one
+		if pointer, ok := requestedRange(r); ok {
+			part, err := utils.ResolvePointer(data, pointer)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Range not satisfiable: %v", err), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+
+			w.Header().Set("Content-Range", "json "+pointer)
+			w.Header().Set("Content-Length", strconv.Itoa(len(part)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(part)
+			return
+		}
+
+		if data == nil {
+			s.streamResource(w, r, resourceID)
+			return
+		}
+
+		// HEAD requests get the same headers without the body
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
 		w.Write(data)
 	}
 }
 
-// addCORSHeaders adds CORS headers to the response
+// setResourceHeaders sets the headers describing how a resource is served and patched
+func (s *BraidMockServer) setResourceHeaders(w http.ResponseWriter, resourceID string, meta resourceMeta) {
+	w.Header().Set("Range-Request-Allow-Methods", "PATCH, PUT")
+	switch {
+	case s.isTextResource(resourceID):
+		w.Header().Set("Range-Request-Allow-Units", "text")
+	case !s.isBinaryResource(resourceID):
+		w.Header().Set("Range-Request-Allow-Units", "json")
+	}
+	w.Header().Set("Content-Type", s.contentType(resourceID, meta))
+	if meta.MergeType != "" {
+		w.Header().Set("Merge-Type", meta.MergeType)
+	}
+}
+
+// allowedMethods returns the methods a resource can be requested with
+func (s *BraidMockServer) allowedMethods(r *http.Request, resourceID string) []string {
+	methods := []string{"GET", "HEAD"}
+	writable := s.resourceMeta(resourceID).Access == config.AccessWritable
+	if s.config.Stateful && writable && s.isCollection(r, resourceID) {
+		methods = append(methods, "POST")
+	}
+	if s.config.Tombstones.Enabled && writable {
+		methods = append(methods, "DELETE")
+	}
+	return append(methods, "OPTIONS")
+}
+
+// writeCapabilities answers an OPTIONS request with the Braid features a
+// resource supports, so clients can feature-detect before subscribing
+func (s *BraidMockServer) writeCapabilities(w http.ResponseWriter, r *http.Request, resourceID string) {
+	meta := s.resourceMeta(resourceID)
+	s.setResourceHeaders(w, resourceID, meta)
+	w.Header().Set("Allow", strings.Join(s.allowedMethods(r, resourceID), ", "))
+	w.Header().Set("Subscribe", "true")
+
+	// JSON resources can be patched in the standard patch media types on request
+	if !s.isTextResource(resourceID) && !s.isBinaryResource(resourceID) {
+		w.Header().Set("Accept-Patch", "application/json-patch+json, application/merge-patch+json")
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isSubscribeRequest reports whether a request asks for a subscription
+func isSubscribeRequest(r *http.Request) bool {
+	return r.Header.Get("Subscribe") == "true"
+}
+
+// wantsStream reports whether a request asks for a stream of updates, either
+// as a Braid subscription or as Server-Sent Events. HEAD requests never do.
+func wantsStream(r *http.Request) bool {
+	return r.Method != http.MethodHead && (isSubscribeRequest(r) || acceptsEventStream(r))
+}
+
+// etagMatches reports whether an If-None-Match header matches a version
+func etagMatches(ifNoneMatch, version string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == `"`+version+`"` {
+			return true
+		}
+	}
+	return false
+}
+
+// startStream writes the response headers of a subscription to a resource,
+// or to every resource for an empty resource ID, and returns the stream its
+// updates are written to, or false if streaming isn't possible. The caller
+// must close the stream before returning.
+func (s *BraidMockServer) startStream(w http.ResponseWriter, r *http.Request, resourceID string) (*subscriberStream, bool) {
+	// Ensure we can flush the response
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, "Streaming not supported", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	// Flush as configured, e.g. to mimic servers that buffer aggressively
+	if s.config.Braid.Flush.Mode != config.FlushIdle {
+		fw := &flushingWriter{ResponseWriter: w, flush: s.config.Braid.Flush, clock: s.clock}
+		w, flusher = fw, fw
+	}
+
+	// Set headers for streaming
+	w.Header().Set("cache-control", "no-cache, no-transform")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// Clients without Braid support get the same updates as Server-Sent Events
+	if s.config.Braid.SSE || acceptsEventStream(r) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		return newSubscriberStream(w, s.hookEncoder(r, resourceID, newSSEEncoder(w)), flusher, s.config.Braid.Backpressure), true
+	}
+
+	// Compress individual updates for clients accepting gzip
+	encoder := braidproto.NewEncoder(w)
+	if s.config.Braid.Compression.Enabled && acceptsEncoding(r, "gzip") {
+		encoder.ContentEncoding = "gzip"
+		encoder.CompressMinSize = s.config.Braid.Compression.MinSize
+	}
+
+	w.Header().Set("subscribe", "true")
+	w.WriteHeader(braidproto.StatusSubscribed)
+	return newSubscriberStream(w, s.hookEncoder(r, resourceID, encoder), flusher, s.config.Braid.Backpressure), true
+}
+
+// requestedRange extracts the JSON Pointer a request is scoped to, either
+// from a "Range: json=/path" header or from a "range" query parameter
+func requestedRange(r *http.Request) (string, bool) {
+	header := r.Header.Get("Range")
+	for _, prefix := range []string{"json=", "json "} {
+		if strings.HasPrefix(header, prefix) {
+			return strings.TrimSpace(header[len(prefix):]), true
+		}
+	}
+
+	if pointer := r.URL.Query().Get("range"); pointer != "" {
+		return pointer, true
+	}
+	return "", false
+}
+
+// isPreflightRequest reports whether a request is a CORS preflight request
+func isPreflightRequest(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsPolicy returns the CORS policy for a request: the global settings,
+// overridden by the first rule whose path prefix and origins match it
+func (s *BraidMockServer) corsPolicy(r *http.Request) config.CORSConfig {
+	policy := s.config.CORS
+	origin := r.Header.Get("Origin")
+	for _, rule := range s.config.CORS.Rules {
+		if !strings.HasPrefix(r.URL.Path, rule.Path) {
+			continue
+		}
+		if len(rule.AllowOrigins) > 0 {
+			if !matchesOrigin(rule.AllowOrigins, origin) {
+				continue
+			}
+			policy.AllowOrigins = rule.AllowOrigins
+		}
+		if rule.AllowMethods != "" {
+			policy.AllowMethods = rule.AllowMethods
+		}
+		if rule.AllowHeaders != "" {
+			policy.AllowHeaders = rule.AllowHeaders
+		}
+		if rule.AllowCredentials != nil {
+			policy.AllowCredentials = *rule.AllowCredentials
+		}
+		if rule.MaxAge != 0 {
+			policy.MaxAge = rule.MaxAge
+		}
+		break
+	}
+	return policy
+}
+
+// matchesOrigin reports whether an origin matches one of the patterns of
+// allowed origins
+func matchesOrigin(patterns []string, origin string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, origin); matched || pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request
+// origin under a CORS policy, or an empty string if the origin isn't allowed
+func allowedOrigin(policy config.CORSConfig, origin string) string {
+	for _, pattern := range policy.AllowOrigins {
+		// Credentialed requests can't use the * wildcard, so echo the origin instead
+		if pattern == "*" {
+			if policy.AllowCredentials && origin != "" {
+				return origin
+			}
+			return "*"
+		}
+		if origin == "" {
+			continue
+		}
+		if matched, _ := path.Match(pattern, origin); matched {
+			return origin
+		}
+	}
+	return ""
+}
+
+// addCORSHeaders adds CORS headers to the response when the request's origin
+// is allowed by the policy for the request
 func (s *BraidMockServer) addCORSHeaders(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", s.config.CORS.AllowOrigins)
-	w.Header().Set("Access-Control-Allow-Methods", s.config.CORS.AllowMethods)
-	w.Header().Set("Access-Control-Allow-Headers", s.config.CORS.AllowHeaders)
+	policy := s.corsPolicy(r)
+	origin := allowedOrigin(policy, r.Header.Get("Origin"))
+	// With rules, even a * response depends on the origin it was sent to
+	if origin != "*" || len(s.config.CORS.Rules) > 0 {
+		w.Header().Add("Vary", "Origin")
+	}
+	if origin == "" {
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", policy.AllowMethods)
+	w.Header().Set("Access-Control-Allow-Headers", policy.AllowHeaders)
 
-	if s.config.CORS.AllowCredentials {
+	if policy.AllowCredentials {
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 	}
 
-	w.Header().Set("Access-Control-Max-Age", fmt.Sprintf("%d", s.config.CORS.MaxAge))
+	w.Header().Set("Access-Control-Max-Age", fmt.Sprintf("%d", policy.MaxAge))
 }
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"net/http"
+)
+
+// headersMiddleware adds the configured static headers to every response, so
+// the mock carries the same header fingerprint as the API it stands in for
+func (s *BraidMockServer) headersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range s.config.Headers {
+			w.Header().Set(name, value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
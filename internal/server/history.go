@@ -0,0 +1,414 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"gihan9a/braidmock/internal/utils"
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// resourceHistory keeps a bounded log of the most recent updates to a resource
+type resourceHistory struct {
+	Version string              // Current version of the resource
+	Body    []byte              // Body of the resource at the current version
+	Updates []braidproto.Update // Updates leading up to the current version, oldest first
+	Times   []time.Time         // When each of the updates was recorded, zero if unknown
+	Bodies  [][]byte            // Body of the resource before each of the updates, nil if unknown
+}
+
+// versionSet holds the versions produced for a resource, least recently
+// produced first, forgetting the oldest beyond a limit
+type versionSet struct {
+	order []string
+	known map[string]bool
+}
+
+func newVersionSet() *versionSet {
+	return &versionSet{known: make(map[string]bool)}
+}
+
+// has reports whether a version is in the set, which may be nil
+func (v *versionSet) has(version string) bool {
+	return v != nil && v.known[version]
+}
+
+// add makes a version the most recent one, forgetting the oldest versions
+// beyond limit, and reports whether it is new
+func (v *versionSet) add(version string, limit int) bool {
+	added := !v.known[version]
+	if added {
+		v.known[version] = true
+	} else {
+		v.order = slices.DeleteFunc(v.order, func(known string) bool { return known == version })
+	}
+	v.order = append(v.order, version)
+	for len(v.order) > limit {
+		delete(v.known, v.order[0])
+		v.order = v.order[1:]
+	}
+	return added
+}
+
+// list returns the versions in the set, least recently produced first
+func (v *versionSet) list() []string {
+	if v == nil {
+		return nil
+	}
+	return append([]string(nil), v.order...)
+}
+
+// knownVersionsLimit is how many versions of each resource are remembered,
+// as many as its history keeps and at least the current one
+func (s *BraidMockServer) knownVersionsLimit() int {
+	return max(s.config.Braid.HistorySize, 1)
+}
+
+// observeResource calculates the version of a resource's current content and
+// records it as known and in the resource's history, returning the version
+func (s *BraidMockServer) observeResource(resourceID string, data []byte) string {
+	hash := s.versionOf(resourceID, data)
+
+	s.mu.Lock()
+	s.versions[resourceID] = hash
+	s.hashes[resourceID] = hash
+	s.mu.Unlock()
+
+	update, changed := s.recordHistory(resourceID, hash, data)
+	if s.recordVersions(resourceID, hash) && changed {
+		// Returning to a known version still changes the history
+		s.persistResource(resourceID)
+	}
+	if changed {
+		s.onResourceChange(resourceID, update)
+	}
+	return hash
+}
+
+// versionOf returns the version of a resource's content. Hashers whose
+// versions stand for changes give content the resource changed to a fresh
+// version, even if it had one before, and keep the version of unchanged content.
+func (s *BraidMockServer) versionOf(resourceID string, data []byte) string {
+	renewer, ok := s.hasher.(utils.Renewer)
+	if !ok {
+		return s.hasher.Hash(data)
+	}
+
+	s.mu.RLock()
+	history, exists := s.history[resourceID]
+	var version string
+	unchanged := false
+	if exists {
+		version, unchanged = history.Version, bytes.Equal(history.Body, data)
+	}
+	s.mu.RUnlock()
+
+	switch {
+	case !exists:
+		return s.hasher.Hash(data)
+	case unchanged:
+		return version
+	default:
+		return renewer.Renew(data)
+	}
+}
+
+// onResourceChange is called with the update between two versions whenever
+// a resource changes, and records it and forwards it to external integrations
+func (s *BraidMockServer) onResourceChange(resourceID string, update braidproto.Update) {
+	s.auditChange(resourceID, update)
+	s.printDiff(resourceID, update)
+	s.sendWebhooks(resourceID, update)
+	s.publishChange(resourceID, update)
+}
+
+// recordHistory records a new version of a resource and returns the update
+// leading to it from the previous version, buffering it for replay. It
+// returns false if the version isn't new.
+func (s *BraidMockServer) recordHistory(resourceID, version string, data []byte) (braidproto.Update, bool) {
+	// Past bodies of large resources would take too much memory to keep
+	large := s.isLargeResource(resourceID)
+
+	for {
+		s.mu.Lock()
+		history, exists := s.history[resourceID]
+		if !exists {
+			// The first version seen is the baseline, with nothing to replay before it
+			s.history[resourceID] = &resourceHistory{Version: version, Body: data}
+			s.mu.Unlock()
+			return braidproto.Update{}, false
+		}
+		if history.Version == version {
+			s.mu.Unlock()
+			return braidproto.Update{}, false
+		}
+		oldVersion, oldData := history.Version, history.Body
+		s.mu.Unlock()
+
+		// Diff without holding s.mu, which every request needs
+		update := s.changeUpdate(resourceID, oldVersion, version, oldData, data)
+
+		s.mu.Lock()
+		if s.history[resourceID] != history || history.Version != oldVersion {
+			// Another version was recorded meanwhile, diff against that one
+			s.mu.Unlock()
+			continue
+		}
+		update.Sequence = s.sequences.next(resourceID)
+		if s.config.Braid.HistorySize > 0 {
+			body := oldData
+			if large {
+				body = nil
+			}
+			history.Updates = append(history.Updates, update)
+			history.Times = append(history.Times, s.clock.Now())
+			history.Bodies = append(history.Bodies, body)
+			history.trim(s.config.Braid.HistorySize, s.config.Braid.HistoryBytes)
+		}
+		history.Version = version
+		history.Body = data
+		s.mu.Unlock()
+
+		return update, true
+	}
+}
+
+// trim drops the oldest updates beyond the given number of them, and while
+// they and the past bodies kept take more than maxBytes, unless that isn't
+// positive
+func (h *resourceHistory) trim(size, maxBytes int) {
+	drop := max(len(h.Updates)-size, 0)
+	if maxBytes > 0 {
+		total := 0
+		for i := drop; i < len(h.Updates); i++ {
+			total += updateSize(h.Updates[i]) + len(h.Bodies[i])
+		}
+		for ; drop < len(h.Updates) && total > maxBytes; drop++ {
+			total -= updateSize(h.Updates[drop]) + len(h.Bodies[drop])
+		}
+	}
+	if drop == 0 {
+		return
+	}
+
+	// Let the dropped updates and bodies be collected before the slices grow again
+	clear(h.Updates[:drop])
+	clear(h.Bodies[:drop])
+	h.Updates = h.Updates[drop:]
+	h.Times = h.Times[drop:]
+	h.Bodies = h.Bodies[drop:]
+}
+
+// updateSize returns the bytes of body and patches an update holds
+func updateSize(update braidproto.Update) int {
+	size := len(update.Body)
+	for _, patch := range update.Patches {
+		size += len(patch.Unit) + len(patch.Range) + len(patch.Content)
+	}
+	return size
+}
+
+// changeUpdate returns the update between two versions of a resource, as
+// patches where possible, falling back to the full body
+func (s *BraidMockServer) changeUpdate(resourceID, oldVersion, version string, oldData, data []byte) braidproto.Update {
+	update := braidproto.Update{
+		Version: []string{version},
+		Parents: []string{oldVersion},
+	}
+
+	var patches []braidproto.Patch
+	var err error
+	if meta := s.resourceMeta(resourceID); s.sendsPatches(resourceID, meta) {
+		patches, err = s.diffResource(resourceID, meta.PatchFormat, oldData, data)
+	}
+	if err != nil || len(patches) == 0 {
+		update.Body = string(data)
+	} else {
+		update.Patches = patches
+	}
+	return update
+}
+
+// replayUpdates returns the buffered updates a client at the given parents
+// has missed, or false if the parents are not in the resource's history
+func (s *BraidMockServer) replayUpdates(resourceID string, parents []string) ([]braidproto.Update, bool) {
+	if s.config.Braid.HistorySize <= 0 || len(parents) != 1 {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history, exists := s.history[resourceID]
+	if !exists {
+		return nil, false
+	}
+	if history.Version == parents[0] {
+		return nil, true
+	}
+
+	for i, update := range history.Updates {
+		for _, parent := range update.Parents {
+			if parent == parents[0] {
+				return append([]braidproto.Update(nil), history.Updates[i:]...), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// versionParam is the query parameter asking for a version of a resource,
+// like the Version header
+const versionParam = "version"
+
+// writeVersion responds with the body of a resource at a past version, or
+// returns false if its history doesn't keep that version
+func (s *BraidMockServer) writeVersion(w http.ResponseWriter, r *http.Request, resourceID, version string) bool {
+	data, parents, ok := s.historicalVersion(resourceID, version)
+	if !ok {
+		return false
+	}
+	logf(requestID(r), "Serving resource %s at version %s", resourceID, version)
+
+	w.Header().Set("Version", braidproto.FormatVersions([]string{version}))
+	w.Header().Set("Parents", braidproto.FormatVersions(parents))
+	w.Header().Set("ETag", `"`+version+`"`)
+	if etagMatches(r.Header.Get("If-None-Match"), version) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+	return true
+}
+
+// historicalVersion returns the body of a resource at a version in its
+// history, and the version's parents, or false if the body isn't kept
+func (s *BraidMockServer) historicalVersion(resourceID, version string) ([]byte, []string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history, exists := s.history[resourceID]
+	if !exists {
+		return nil, nil, false
+	}
+	for i := len(history.Updates) - 1; i >= 0; i-- {
+		update := history.Updates[i]
+		if len(update.Version) == 1 && update.Version[0] == version {
+			if i+1 < len(history.Bodies) {
+				return history.Bodies[i+1], update.Parents, history.Bodies[i+1] != nil
+			}
+			return history.Body, update.Parents, true
+		}
+	}
+
+	// The version before the oldest update has no known parents
+	if len(history.Updates) > 0 && len(history.Bodies) > 0 {
+		if parents := history.Updates[0].Parents; len(parents) == 1 && parents[0] == version {
+			return history.Bodies[0], nil, history.Bodies[0] != nil
+		}
+	}
+	return nil, nil, false
+}
+
+// recordVersions remembers versions the server has produced for a resource,
+// up to knownVersionsLimit, persisting them if any are new, and returns
+// whether it knew all of them already
+func (s *BraidMockServer) recordVersions(resourceID string, versions ...string) bool {
+	s.mu.Lock()
+	known, exists := s.knownVersions[resourceID]
+	if !exists {
+		known = newVersionSet()
+		s.knownVersions[resourceID] = known
+	}
+	var added []string
+	for _, version := range versions {
+		if known.add(version, s.knownVersionsLimit()) {
+			added = append(added, version)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(added) == 0 {
+		return true
+	}
+	s.persistResource(resourceID)
+	s.shareVersions(resourceID, added)
+	return false
+}
+
+// unknownVersions returns the given versions that the server, or any other
+// instance of the mock, has never produced for a resource
+func (s *BraidMockServer) unknownVersions(resourceID string, versions []string) []string {
+	s.mu.RLock()
+	var unknown []string
+	for _, version := range versions {
+		if !s.knownVersions[resourceID].has(version) {
+			unknown = append(unknown, version)
+		}
+	}
+	s.mu.RUnlock()
+
+	return s.sharedUnknownVersions(resourceID, unknown)
+}
+
+// historyEntry is an update in a resource's history as listed by the admin API
+type historyEntry struct {
+	Time *time.Time `json:"time,omitempty"`
+	braidproto.Update
+}
+
+// handleAdminHistory lists the buffered updates of a resource, oldest first,
+// or the last n of them given the n query parameter, so tests can check the
+// sequence of updates the server sent
+func (s *BraidMockServer) handleAdminHistory(w http.ResponseWriter, r *http.Request) {
+	resourceID, ok := s.adminResource(w, r)
+	if !ok {
+		return
+	}
+	n := -1
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 0 {
+			http.Error(w, "Invalid n parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Resources the server hasn't seen yet start their history now
+	_, version, err := s.readResource(nil, resourceID)
+	if err != nil {
+		http.Error(w, "Error reading resource: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries := []historyEntry{}
+	s.mu.RLock()
+	if history, exists := s.history[resourceID]; exists {
+		version = history.Version
+		for i, update := range history.Updates {
+			entry := historyEntry{Update: update}
+			if i < len(history.Times) && !history.Times[i].IsZero() {
+				entry.Time = &history.Times[i]
+			}
+			entries = append(entries, entry)
+		}
+	}
+	s.mu.RUnlock()
+	if n >= 0 && n < len(entries) {
+		entries = entries[len(entries)-n:]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resource": resourceID,
+		"version":  version,
+		"updates":  entries,
+	})
+}
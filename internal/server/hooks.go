@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// Hook intercepts the requests a server handles, the resources it responds
+// with and the updates it sends to subscribers, e.g. to add custom auth or
+// rewrite bodies. Embed NopHook to implement only some of its methods.
+type Hook interface {
+	// HookRequest is called before a request is handled, and returns false
+	// if it has responded to the request itself
+	HookRequest(w http.ResponseWriter, r *http.Request) bool
+
+	// HookResponse returns the body to respond to a GET request for a
+	// resource with, given the body read from the store. Large resources
+	// streamed from the store are served as they are.
+	HookResponse(r *http.Request, resourceID string, body []byte) []byte
+
+	// HookUpdate returns the update to a resource to send to a subscriber,
+	// given the request that opened the subscription
+	HookUpdate(r *http.Request, resourceID string, update braidproto.Update) braidproto.Update
+}
+
+// NopHook is a Hook that changes nothing
+type NopHook struct{}
+
+// HookRequest lets the request be handled
+func (NopHook) HookRequest(w http.ResponseWriter, r *http.Request) bool { return true }
+
+// HookResponse returns the body unchanged
+func (NopHook) HookResponse(r *http.Request, resourceID string, body []byte) []byte { return body }
+
+// HookUpdate returns the update unchanged
+func (NopHook) HookUpdate(r *http.Request, resourceID string, update braidproto.Update) braidproto.Update {
+	return update
+}
+
+// Use adds middleware wrapping the handlers of the server's routes, inside
+// the built-in middleware such as authentication. The first middleware added
+// is the outermost. It must be called before SetupRoutes.
+func (s *BraidMockServer) Use(middleware ...func(http.Handler) http.Handler) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// AddHook adds a hook intercepting the server's requests, responses and
+// updates, called in the order added. It must be called before the server
+// handles requests.
+func (s *BraidMockServer) AddHook(hook Hook) {
+	s.hooks = append(s.hooks, hook)
+}
+
+// hookMiddleware lets the hooks respond to requests before they are handled
+func (s *BraidMockServer) hookMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, hook := range s.hooks {
+			if !hook.HookRequest(w, r) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hookResponse returns the body of a resource as rewritten by the hooks
+func (s *BraidMockServer) hookResponse(r *http.Request, resourceID string, body []byte) []byte {
+	for _, hook := range s.hooks {
+		body = hook.HookResponse(r, resourceID, body)
+	}
+	return body
+}
+
+// hookEncoder returns an encoder passing the updates to a resource of the
+// subscription opened by a request through the hooks before writing them
+// with encoder. Wildcard subscriptions have no resource, their updates
+// naming theirs in URL.
+func (s *BraidMockServer) hookEncoder(r *http.Request, resourceID string, encoder updateEncoder) updateEncoder {
+	if len(s.hooks) == 0 {
+		return encoder
+	}
+	return &hookedEncoder{encoder: encoder, hooks: s.hooks, r: r, resource: resourceID}
+}
+
+// hookedEncoder rewrites updates with hooks before writing them
+type hookedEncoder struct {
+	encoder  updateEncoder
+	hooks    []Hook
+	r        *http.Request
+	resource string
+}
+
+func (e *hookedEncoder) Encode(update braidproto.Update) error {
+	resourceID := e.resource
+	if update.URL != "" {
+		resourceID = update.URL
+	}
+	for _, hook := range e.hooks {
+		update = hook.HookUpdate(e.r, resourceID, update)
+	}
+	return e.encoder.Encode(update)
+}
+
+// EncodeWarning writes a warning with the underlying encoder, if it can
+func (e *hookedEncoder) EncodeWarning(message string) error {
+	if encoder, ok := e.encoder.(warningEncoder); ok {
+		return encoder.EncodeWarning(message)
+	}
+	return nil
+}
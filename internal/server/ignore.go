@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile lists additional ignore patterns in the root directory, one per line
+const ignoreFile = ".braidignore"
+
+// loadIgnorePatterns returns the configured ignore patterns followed by those
+// in the root directory's .braidignore file, if it exists
+func loadIgnorePatterns(rootDir string, configured []string) ([]string, error) {
+	patterns := append([]string(nil), configured...)
+
+	file, err := os.Open(filepath.Join(rootDir, ignoreFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading %s: %w", ignoreFile, err)
+	}
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			patterns = append(patterns, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", ignoreFile, err)
+		}
+	}
+
+	for i, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid ignore pattern: %q", patterns[i])
+		}
+		patterns[i] = pattern
+	}
+	return patterns, nil
+}
+
+// isIgnored reports whether a path under a mock directory matches an ignore
+// pattern. Patterns without a slash match any file or directory name, others
+// match paths relative to the mock directory, and ignoring a directory
+// ignores everything in it.
+func (s *fileStore) isIgnored(filePath string) bool {
+	if len(s.ignore) == 0 && !s.config.HideDotfiles {
+		return false
+	}
+	_, relPath, ok := s.mountForPath(filePath)
+	if !ok || relPath == "." {
+		return false
+	}
+	if s.isHidden(relPath) {
+		return true
+	}
+
+	elems := strings.Split(filepath.ToSlash(relPath), "/")
+	for _, pattern := range s.ignore {
+		for i, elem := range elems {
+			subject := elem
+			if strings.Contains(pattern, "/") {
+				subject = strings.Join(elems[:i+1], "/")
+			}
+			if matched, _ := path.Match(pattern, subject); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isHidden reports whether a path relative to a mock directory is a dotfile
+// or inside a dot directory, when those are hidden
+func (s *fileStore) isHidden(relPath string) bool {
+	if !s.config.HideDotfiles {
+		return false
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if strings.HasPrefix(elem, ".") && elem != "." && elem != ".." {
+			return true
+		}
+	}
+	return false
+}
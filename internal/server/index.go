@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// indexEntry describes a resource in the resource index
+type indexEntry struct {
+	Path        string   `json:"path"`
+	Size        int      `json:"size"`
+	Version     string   `json:"version"`
+	ContentType string   `json:"content_type"`
+	Subscribers int      `json:"subscribers"`
+	Methods     []string `json:"methods"`
+}
+
+// handleIndex lists every resource the mock serves to the request, so tools
+// can discover them without access to the mock files
+func (s *BraidMockServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	resourceIDs, err := s.store.List("/")
+	if err != nil {
+		s.writeError(w, "Error listing resources: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]indexEntry, 0, len(resourceIDs))
+	for _, resourceID := range resourceIDs {
+		if s.isDeleted(r, resourceID) {
+			continue
+		}
+		data, hash, err := s.readResource(r, resourceID)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, indexEntry{
+			Path:        resourceID,
+			Size:        len(data),
+			Version:     hash,
+			ContentType: s.contentType(resourceID, s.resourceMeta(resourceID)),
+			Subscribers: s.subscriptions.count(resourceID),
+			Methods:     s.allowedMethods(r, resourceID),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
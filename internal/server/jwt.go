@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gihan9a/braidmock/internal/config"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claimsKey is the request context key of validated JWT claims
+type claimsKey struct{}
+
+// jwtVerifier validates Bearer JWTs against a static secret or a JWKS
+type jwtVerifier struct {
+	parser  *jwt.Parser
+	keyfunc jwt.Keyfunc
+	cancel  context.CancelFunc
+}
+
+// newJWTVerifier creates a verifier from the JWT options, or returns nil if
+// JWT validation isn't configured
+func newJWTVerifier(cfg config.JWTConfig) (*jwtVerifier, error) {
+	if cfg.Secret == "" && cfg.JWKSURL == "" {
+		return nil, nil
+	}
+
+	options := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+
+	verifier := &jwtVerifier{}
+	if cfg.JWKSURL != "" {
+		// Keep the key set refreshed in the background until the server closes
+		ctx, cancel := context.WithCancel(context.Background())
+		jwks, err := keyfunc.NewDefaultCtx(ctx, []string{cfg.JWKSURL})
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to load JWKS: %w", err)
+		}
+		verifier.keyfunc = jwks.Keyfunc
+		verifier.cancel = cancel
+	} else {
+		secret := []byte(cfg.Secret)
+		verifier.keyfunc = func(*jwt.Token) (interface{}, error) { return secret, nil }
+		options = append(options, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	}
+	verifier.parser = jwt.NewParser(options...)
+
+	return verifier, nil
+}
+
+// verify validates a token and returns its claims, or the status to reject the request with
+func (v *jwtVerifier) verify(token string) (jwt.MapClaims, int, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.keyfunc); err != nil {
+		// A genuine token meant for someone else is forbidden rather than unauthorized
+		if errors.Is(err, jwt.ErrTokenInvalidIssuer) || errors.Is(err, jwt.ErrTokenInvalidAudience) {
+			return nil, http.StatusForbidden, err
+		}
+		return nil, http.StatusUnauthorized, err
+	}
+	return claims, http.StatusOK, nil
+}
+
+// Close stops refreshing the key set
+func (v *jwtVerifier) Close() {
+	if v.cancel != nil {
+		v.cancel()
+	}
+}
+
+// bearerToken returns the token of a Bearer Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// requestClaims returns the validated JWT claims of a request, if any
+func requestClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsKey{}).(jwt.MapClaims)
+	return claims
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// largeHash is the version of a large resource, along with the size and
+// modification time of the mock file it was hashed from
+type largeHash struct {
+	Size    int64
+	ModTime time.Time
+	Hash    string
+}
+
+// isLargeResource reports whether a resource is too large to be held in
+// memory for regular requests or diffed for subscribers
+func (s *BraidMockServer) isLargeResource(resourceID string) bool {
+	if s.config.Braid.LargeSize < 0 {
+		return false
+	}
+	info, err := s.statResource(resourceID)
+	return err == nil && info.Size > int64(s.config.Braid.LargeSize)
+}
+
+// hashResource returns the version of a resource, hashing its content as it
+// is read from the store instead of reading it all into memory. The version
+// is kept until the mock file's size or modification time changes, or the
+// store reports a change.
+func (s *BraidMockServer) hashResource(resourceID string) (string, error) {
+	info, err := s.store.Stat(resourceID)
+	if err != nil {
+		return "", err
+	}
+	s.mu.RLock()
+	cached, ok := s.largeHashes[resourceID]
+	s.mu.RUnlock()
+	if ok && cached.Size == info.Size && cached.ModTime.Equal(info.ModTime) {
+		return cached.Hash, nil
+	}
+
+	reader, err := s.store.Open(resourceID)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash, err := s.hasher.HashReader(reader)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.versions[resourceID] = hash
+	s.hashes[resourceID] = hash
+	s.largeHashes[resourceID] = largeHash{Size: info.Size, ModTime: info.ModTime, Hash: hash}
+	s.mu.Unlock()
+
+	s.recordVersions(resourceID, hash)
+	return hash, nil
+}
+
+// streamResource copies the content of a resource from the store to the response
+func (s *BraidMockServer) streamResource(w http.ResponseWriter, r *http.Request, resourceID string) {
+	reader, err := s.store.Open(resourceID)
+	if err != nil {
+		s.writeError(w, "Error reading resource: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	if info, err := s.store.Stat(resourceID); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Error streaming resource %s: %v", resourceID, err)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+)
+
+// limitsMiddleware refuses requests with URLs, headers or bodies over the
+// configured limits, and gives their bodies a deadline to arrive by, so
+// misbehaving clients can't exhaust a shared mock
+func (s *BraidMockServer) limitsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := s.config.Limits
+		if limits.MaxURLLength > 0 && len(r.RequestURI) > limits.MaxURLLength {
+			logf(requestID(r), "Refusing request with a URL of %d bytes", len(r.RequestURI))
+			s.writeError(w, "URL too long", http.StatusRequestURITooLong)
+			return
+		}
+		if limits.MaxHeaderSize > 0 && headerSize(r.Header) > limits.MaxHeaderSize {
+			logf(requestID(r), "Refusing request with %d bytes of headers", headerSize(r.Header))
+			s.writeError(w, "Request headers too large", http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+
+		if r.Body != nil && r.Body != http.NoBody {
+			if limits.MaxBodySize > 0 {
+				if r.ContentLength > limits.MaxBodySize {
+					logf(requestID(r), "Refusing request with a body of %d bytes", r.ContentLength)
+					s.writeError(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBodySize)
+			}
+
+			// Subscriptions are long-lived, so only requests with bodies get
+			// a deadline, which the server's would apply to all of them
+			if limits.ReadTimeout > 0 {
+				http.NewResponseController(w).SetReadDeadline(time.Now().Add(time.Duration(limits.ReadTimeout) * time.Second))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headerSize returns the size of request headers as sent over HTTP/1.1
+func headerSize(header http.Header) int {
+	size := 0
+	for name, values := range header {
+		for _, value := range values {
+			size += len(name) + len(value) + len(": \r\n")
+		}
+	}
+	return size
+}
+
+// bodyErrorStatus returns the status to respond with to a request whose body
+// couldn't be read
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// streamCounter counts open subscription streams, in total and per
+// resource, against the subscription limits
+type streamCounter struct {
+	mu          sync.Mutex
+	total       int
+	perResource map[string]int
+}
+
+// newStreamCounter creates a counter with no open streams
+func newStreamCounter() *streamCounter {
+	return &streamCounter{perResource: make(map[string]int)}
+}
+
+// reserve counts a new stream to a resource, or to every resource under a
+// prefix for wildcard streams, unless that exceeds a limit, in which case it
+// returns why. Checking and counting happen at once, so concurrent streams
+// can't both take the last place.
+func (c *streamCounter) reserve(resourceID string, wildcard bool, limits config.SubscriptionsConfig) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !wildcard && limits.MaxPerResource > 0 && c.perResource[resourceID] >= limits.MaxPerResource {
+		return "Too many subscribers to this resource"
+	}
+	if limits.MaxTotal > 0 && c.total >= limits.MaxTotal {
+		return "Too many subscriptions"
+	}
+
+	c.total++
+	if !wildcard {
+		c.perResource[resourceID]++
+	}
+	return ""
+}
+
+// release stops counting a stream reserved with the same arguments
+func (c *streamCounter) release(resourceID string, wildcard bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.total--
+	if !wildcard {
+		if c.perResource[resourceID]--; c.perResource[resourceID] <= 0 {
+			delete(c.perResource, resourceID)
+		}
+	}
+}
+
+// reserveStream counts a new subscription stream to a resource, or to every
+// resource under a prefix for wildcard streams, against the configured
+// limits. If a limit is exceeded it responds with 503 and returns false;
+// otherwise the caller must call releaseStream once the stream ends.
+func (s *BraidMockServer) reserveStream(w http.ResponseWriter, r *http.Request, resourceID string, wildcard bool) bool {
+	if message := s.streams.reserve(resourceID, wildcard, s.config.Braid.Subscriptions); message != "" {
+		s.refuseSubscription(w, r, resourceID, message)
+		return false
+	}
+	return true
+}
+
+// releaseStream stops counting a subscription stream against the limits
+func (s *BraidMockServer) releaseStream(resourceID string, wildcard bool) {
+	s.streams.release(resourceID, wildcard)
+}
+
+// refuseSubscription responds to a subscription over a limit with 503,
+// telling the client when to retry
+func (s *BraidMockServer) refuseSubscription(w http.ResponseWriter, r *http.Request, resourceID, message string) {
+	logf(requestID(r), "Refusing subscription to %s: %s", resourceID, message)
+	w.Header().Set("Retry-After", strconv.Itoa(s.config.Braid.Subscriptions.RetryAfter))
+	s.writeError(w, message, http.StatusServiceUnavailable)
+}
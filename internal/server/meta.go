@@ -0,0 +1,132 @@
+package server
+
+import (
+	"log"
+	"path"
+
+	"gihan9a/braidmock/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// metaSuffix is the extension of sidecar files holding a resource's settings
+const metaSuffix = ".meta.yml"
+
+// resourceMeta holds the settings of a single resource
+type resourceMeta struct {
+	MergeType    string `yaml:"merge_type"`    // Merge algorithm advertised in Merge-Type headers
+	ContentType  string `yaml:"content_type"`  // Media type the resource is served as
+	SnapshotOnly bool   `yaml:"snapshot_only"` // Always send full bodies instead of patches
+	PatchFormat  string `yaml:"patch_format"`  // Format of patches for changes to JSON resources
+	Template     bool   `yaml:"template"`      // Render the mock file as a template
+	PageSize     int    `yaml:"page_size"`     // Items per page of a JSON array, 0 to serve it whole
+	PageEnvelope bool   `yaml:"page_envelope"` // Wrap pages in an object with paging metadata
+	Schema       string `yaml:"schema"`        // JSON Schema file the resource and writes to it must match
+	Script       string `yaml:"script"`        // Lua script computing the body the resource is served with
+	Access       string `yaml:"access"`        // Who may change the resource over HTTP
+}
+
+// resourceMeta returns the settings of a resource, cached along with the
+// resources when caching is enabled
+func (s *BraidMockServer) resourceMeta(resourceID string) resourceMeta {
+	if !s.config.CacheResources {
+		return s.loadResourceMeta(resourceID)
+	}
+
+	s.mu.RLock()
+	meta, ok := s.metas[resourceID]
+	gen := s.cacheGen
+	s.mu.RUnlock()
+	if ok {
+		return meta
+	}
+	meta = s.loadResourceMeta(resourceID)
+
+	// Settings invalidated while loading may be stale already
+	s.mu.Lock()
+	if s.cacheGen == gen {
+		s.metas[resourceID] = meta
+	}
+	s.mu.Unlock()
+	return meta
+}
+
+// loadResourceMeta reads the settings of a resource, starting from the
+// global defaults, then applying matching resource rules from the config in
+// order, and finally the resource's sidecar file
+func (s *BraidMockServer) loadResourceMeta(resourceID string) resourceMeta {
+	meta := resourceMeta{
+		MergeType:    s.config.Braid.MergeType,
+		SnapshotOnly: s.config.Braid.SnapshotOnly,
+		PatchFormat:  s.config.Braid.PatchFormat,
+		Access:       config.AccessWritable,
+	}
+
+	for _, rule := range s.config.Resources {
+		if ok, _ := path.Match(rule.Path, resourceID); !ok {
+			continue
+		}
+		if rule.MergeType != "" {
+			meta.MergeType = rule.MergeType
+		}
+		if rule.ContentType != "" {
+			meta.ContentType = rule.ContentType
+		}
+		if rule.SnapshotOnly {
+			meta.SnapshotOnly = true
+		}
+		if rule.PatchFormat != "" {
+			meta.PatchFormat = rule.PatchFormat
+		}
+		if rule.Template {
+			meta.Template = true
+		}
+		if rule.PageSize != 0 {
+			meta.PageSize = rule.PageSize
+		}
+		if rule.PageEnvelope {
+			meta.PageEnvelope = true
+		}
+		if rule.Schema != "" {
+			meta.Schema = rule.Schema
+		}
+		if rule.Script != "" {
+			meta.Script = rule.Script
+		}
+		if rule.Access != "" {
+			meta.Access = rule.Access
+		}
+	}
+
+	// Fields set in the sidecar file override everything else
+	data, err := s.store.ReadMeta(resourceID)
+	if err != nil {
+		return meta
+	}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		log.Printf("Error parsing metadata for resource %s: %v", resourceID, err)
+	}
+
+	// A mistyped access value must not leave the resource writable
+	switch meta.Access {
+	case "":
+		meta.Access = config.AccessWritable
+	case config.AccessWritable, config.AccessAdminOnly, config.AccessReadOnly:
+	default:
+		log.Printf("Invalid access %q in metadata for resource %s, treating it as %s", meta.Access, resourceID, config.AccessReadOnly)
+		meta.Access = config.AccessReadOnly
+	}
+	return meta
+}
+
+// dynamic reports whether a resource is rendered or computed when it is
+// read, rather than served as stored
+func (meta resourceMeta) dynamic() bool {
+	return meta.Template || meta.Script != ""
+}
+
+// sendsPatches reports whether changes to a resource are sent as patches
+// rather than full bodies
+func (s *BraidMockServer) sendsPatches(resourceID string, meta resourceMeta) bool {
+	return !meta.SnapshotOnly && !s.isBinaryResource(resourceID) && !s.isLargeResource(resourceID)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gihan9a/braidmock/internal/config"
+)
+
+// mount is a directory of mock files served under a URL prefix
+type mount struct {
+	Prefix string // URL prefix without a trailing slash, empty for the root directory
+	Dir    string
+}
+
+// newMounts returns the configured mounts, longest prefix first, followed by
+// the root directory
+func newMounts(cfg *config.Config) []mount {
+	var mounts []mount
+	for _, m := range cfg.Mounts {
+		mounts = append(mounts, mount{Prefix: m.Prefix, Dir: m.Dir})
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].Prefix) > len(mounts[j].Prefix)
+	})
+	return append(mounts, mount{Dir: cfg.RootDir})
+}
+
+// mountForResource returns the mount serving a resource ID and the resource's
+// path relative to the mount's directory
+func (s *fileStore) mountForResource(resourceID string) (mount, string) {
+	for _, m := range s.mounts {
+		if m.Prefix == "" {
+			return m, strings.TrimPrefix(resourceID, "/")
+		}
+		if rest, ok := strings.CutPrefix(resourceID, m.Prefix+"/"); ok {
+			return m, rest
+		}
+	}
+	return mount{Dir: s.config.RootDir}, strings.TrimPrefix(resourceID, "/")
+}
+
+// mountForPath returns the mount whose directory contains a file path and the
+// path relative to that directory, or false if no mount contains it
+func (s *fileStore) mountForPath(path string) (mount, string, bool) {
+	for _, m := range s.mounts {
+		relPath, err := filepath.Rel(m.Dir, path)
+		if err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return m, relPath, true
+		}
+	}
+	return mount{}, "", false
+}
+
+// listingDirs returns the directories that may hold mock files of resources
+// under a URL prefix ending in a slash, none if the prefix could escape them
+func (s *fileStore) listingDirs(prefix string) []string {
+	if escapeReason(prefix) != "" {
+		return nil
+	}
+	var dirs []string
+	for _, m := range s.mounts {
+		switch {
+		case m.Prefix == "":
+			dirs = append(dirs, filepath.Join(m.Dir, strings.TrimPrefix(prefix, "/")))
+		case strings.HasPrefix(prefix, m.Prefix+"/"):
+			dirs = append(dirs, filepath.Join(m.Dir, strings.TrimPrefix(prefix, m.Prefix+"/")))
+		case strings.HasPrefix(m.Prefix+"/", prefix):
+			// The whole mount is under the prefix
+			dirs = append(dirs, m.Dir)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/utils"
+)
+
+// mutateValue returns the next value of a value mutated by a schedule, or
+// of a missing value when current is nil
+func mutateValue(current interface{}, mutation config.MutationConfig, rng *rand.Rand) (interface{}, error) {
+	if len(mutation.Pick) > 0 {
+		return mutation.Pick[rng.Intn(len(mutation.Pick))], nil
+	}
+
+	var number float64
+	switch value := current.(type) {
+	case nil:
+		if mutation.Min != nil {
+			number = *mutation.Min
+		}
+	case float64:
+		number = value
+	default:
+		return nil, fmt.Errorf("cannot change %v, not a number", current)
+	}
+
+	if mutation.Walk != 0 {
+		number += (rng.Float64()*2 - 1) * mutation.Walk
+		if mutation.Min != nil {
+			number = math.Max(number, *mutation.Min)
+		}
+		if mutation.Max != nil {
+			number = math.Min(number, *mutation.Max)
+		}
+	} else {
+		number += mutation.Increment
+	}
+
+	scale := math.Pow(10, float64(mutation.Decimals))
+	return math.Round(number*scale) / scale, nil
+}
+
+// currentValue returns the value a JSON Pointer refers to in a JSON
+// document, or nil if there is none
+func currentValue(data []byte, pointer string) interface{} {
+	raw, err := utils.ResolvePointer(data, pointer)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	json.Unmarshal(raw, &value)
+	return value
+}
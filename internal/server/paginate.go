@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Clients page through collections with the limit and offset query
+// parameters, or with the opaque cursor of the next page instead of an offset
+const (
+	limitParam  = "limit"
+	offsetParam = "offset"
+	cursorParam = "cursor"
+)
+
+// Headers describing the page of a collection in a response
+const (
+	totalCountHeader = "X-Total-Count"
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+// collectionPage is a page of a collection wrapped with its paging metadata
+type collectionPage struct {
+	Items      []json.RawMessage `json:"items"`
+	Total      int               `json:"total"`
+	Offset     int               `json:"offset"`
+	Limit      int               `json:"limit"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// paginate returns the page of a JSON array a request asks for and sets the
+// headers describing it. Resources that aren't arrays are returned whole.
+func paginate(w http.ResponseWriter, r *http.Request, data []byte, meta resourceMeta) ([]byte, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return data, nil
+	}
+
+	query := r.URL.Query()
+	limit := meta.PageSize
+	if value := query.Get(limitParam); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("Invalid limit: %s", value)
+		}
+		limit = n
+	}
+	offset := 0
+	if value := query.Get(offsetParam); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid offset: %s", value)
+		}
+		offset = n
+	}
+	if value := query.Get(cursorParam); value != "" {
+		n, err := decodeCursor(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cursor: %s", value)
+		}
+		offset = n
+	}
+
+	start := min(offset, len(items))
+	end := min(start+limit, len(items))
+	page := collectionPage{Items: items[start:end], Total: len(items), Offset: offset, Limit: limit}
+
+	w.Header().Set(totalCountHeader, strconv.Itoa(page.Total))
+	var links []string
+	if end < len(items) {
+		page.NextCursor = encodeCursor(end)
+		w.Header().Set(nextCursorHeader, page.NextCursor)
+		links = append(links, pageLink(r, cursorParam, page.NextCursor, limit, "next"))
+	}
+	if start > 0 {
+		links = append(links, pageLink(r, offsetParam, strconv.Itoa(max(start-limit, 0)), limit, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	var body interface{} = page.Items
+	if meta.PageEnvelope {
+		body = page
+	}
+	// Keep pages of indented collections indented
+	if bytes.Contains(bytes.TrimSpace(data), []byte("\n")) {
+		return json.MarshalIndent(body, "", "  ")
+	}
+	return json.Marshal(body)
+}
+
+// pageLink returns a Link header value referring to another page of the
+// requested collection
+func pageLink(r *http.Request, param, value string, limit int, rel string) string {
+	query := r.URL.Query()
+	query.Del(offsetParam)
+	query.Del(cursorParam)
+	query.Set(param, value)
+	query.Set(limitParam, strconv.Itoa(limit))
+	return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+}
+
+// encodeCursor returns the cursor of the page starting at an offset
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// decodeCursor returns the offset of the page a cursor refers to
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := strings.CutPrefix(string(data), "offset:")
+	if !ok {
+		return 0, fmt.Errorf("unknown cursor")
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("unknown cursor")
+	}
+	return offset, nil
+}
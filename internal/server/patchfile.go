@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/utils"
+
+	"github.com/wI2L/jsondiff"
+)
+
+// PatchMockFile applies a JSON Patch document to the mock file of a JSON
+// resource in the configured directories, e.g. from the command line. A
+// running server's watcher then sends the change to subscribers like any
+// other edit.
+func PatchMockFile(config *config.Config, resourceID string, patch jsondiff.Patch) error {
+	store, err := newFileStore(config)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	info, err := store.Stat(resourceID)
+	if err != nil {
+		return fmt.Errorf("resource %s not found: %w", resourceID, err)
+	}
+	if info.Type != "" {
+		return fmt.Errorf("resource %s is not JSON", resourceID)
+	}
+
+	data, err := store.Read(info.ID)
+	if err != nil {
+		return fmt.Errorf("error reading resource: %w", err)
+	}
+
+	patched, err := applyJSONPatch(data, patch)
+	if err != nil {
+		return err
+	}
+	if err := store.Write(info.ID, patched); err != nil {
+		return fmt.Errorf("error writing resource: %w", err)
+	}
+	return nil
+}
+
+// SetOperation returns the JSON Patch operation setting the value at a JSON
+// Pointer: object members are added or replaced, array elements at an index
+// are replaced and "-" appends to an array
+func SetOperation(pointer string, value interface{}) (jsondiff.Operation, error) {
+	tokens, err := utils.ParsePointer(pointer)
+	if err != nil {
+		return jsondiff.Operation{}, err
+	}
+
+	op := jsondiff.Operation{Type: jsondiff.OperationAdd, Path: pointer, Value: value}
+	if len(tokens) > 0 {
+		if _, err := strconv.Atoi(tokens[len(tokens)-1]); err == nil {
+			op.Type = jsondiff.OperationReplace
+		}
+	}
+	return op, nil
+}
+
+// applyJSONPatch applies the operations of a JSON Patch document (RFC 6902)
+// to a JSON document, keeping it indented if it was
+func applyJSONPatch(data []byte, patch jsondiff.Patch) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+
+	for _, op := range patch {
+		tokens, err := utils.ParsePointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Type {
+		case jsondiff.OperationAdd, jsondiff.OperationReplace, jsondiff.OperationRemove:
+			if op.Type != jsondiff.OperationAdd {
+				if _, err := lookupValue(doc, op.Path); err != nil {
+					return nil, err
+				}
+			}
+		case jsondiff.OperationMove, jsondiff.OperationCopy:
+			value, err := lookupValue(doc, op.From)
+			if err != nil {
+				return nil, err
+			}
+			if op.Type == jsondiff.OperationMove {
+				from, _ := utils.ParsePointer(op.From)
+				if doc, err = applyOperation(doc, from, jsondiff.Operation{Type: jsondiff.OperationRemove}); err != nil {
+					return nil, err
+				}
+			}
+			op = jsondiff.Operation{Type: jsondiff.OperationAdd, Value: value}
+		case jsondiff.OperationTest:
+			value, err := lookupValue(doc, op.Path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(value, normalizeValue(op.Value)) {
+				return nil, fmt.Errorf("test of %q failed", op.Path)
+			}
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported operation %q", op.Type)
+		}
+
+		if doc, err = applyOperation(doc, tokens, op); err != nil {
+			return nil, fmt.Errorf("cannot apply %s at %q: %w", op.Type, op.Path, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if bytes.Contains(bytes.TrimSpace(data), []byte("\n")) {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// lookupValue returns a copy of the value a JSON Pointer refers to in a
+// decoded JSON document
+func lookupValue(doc interface{}, pointer string) (interface{}, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := utils.ResolvePointer(data, pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	err = json.Unmarshal(raw, &value)
+	return value, err
+}
+
+// normalizeValue converts a value to the types of a decoded JSON document so
+// it can be compared with values from one
+func normalizeValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	json.Unmarshal(data, &normalized)
+	return normalized
+}
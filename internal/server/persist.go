@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gihan9a/braidmock/pkg/braidproto"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// persistBucket is the bbolt bucket holding the persisted state of each
+// resource, keyed by resource ID
+var persistBucket = []byte("resources")
+
+// persistedResource is the version state of a resource kept across restarts
+type persistedResource struct {
+	Version       string              `json:"version"`
+	Body          []byte              `json:"body"`
+	Updates       []braidproto.Update `json:"updates,omitempty"`
+	Times         []time.Time         `json:"times,omitempty"`
+	Bodies        [][]byte            `json:"bodies,omitempty"`
+	KnownVersions []string            `json:"known_versions,omitempty"`
+	Sequence      uint64              `json:"sequence,omitempty"`
+}
+
+// stateFile persists the versions, history and sequences of resources in a
+// bbolt database, so clients can resume from versions produced before the
+// server restarted
+type stateFile struct {
+	db *bolt.DB
+}
+
+// openStateFile opens the state file at path, creating it if needed
+func openStateFile(path string) (*stateFile, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(persistBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+	return &stateFile{db: db}, nil
+}
+
+// load returns the persisted state of all resources
+func (f *stateFile) load() (map[string]persistedResource, error) {
+	resources := make(map[string]persistedResource)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(persistBucket).ForEach(func(key, value []byte) error {
+			var resource persistedResource
+			if err := json.Unmarshal(value, &resource); err != nil {
+				return fmt.Errorf("invalid state of resource %s: %w", key, err)
+			}
+			resources[string(key)] = resource
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state file: %w", err)
+	}
+	return resources, nil
+}
+
+// save replaces the persisted state of a resource
+func (f *stateFile) save(resourceID string, resource persistedResource) error {
+	value, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(persistBucket).Put([]byte(resourceID), value)
+	})
+}
+
+// close closes the state file
+func (f *stateFile) close() {
+	f.db.Close()
+}
+
+// restoreState restores the versions, history and sequences loaded from the
+// state file. A resource whose mock file changed while the server was down
+// records the change from its persisted version the next time it is read.
+func (s *BraidMockServer) restoreState(resources map[string]persistedResource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for resourceID, resource := range resources {
+		// The history limits may have shrunk since the file was written
+		if len(resource.Times) != len(resource.Updates) {
+			resource.Times = make([]time.Time, len(resource.Updates))
+		}
+		if len(resource.Bodies) != len(resource.Updates) {
+			resource.Bodies = make([][]byte, len(resource.Updates))
+		}
+		history := &resourceHistory{
+			Version: resource.Version,
+			Body:    resource.Body,
+			Updates: resource.Updates,
+			Times:   resource.Times,
+			Bodies:  resource.Bodies,
+		}
+		history.trim(max(s.config.Braid.HistorySize, 0), s.config.Braid.HistoryBytes)
+		s.history[resourceID] = history
+		known := newVersionSet()
+		for _, version := range resource.KnownVersions {
+			known.add(version, s.knownVersionsLimit())
+		}
+		s.knownVersions[resourceID] = known
+		s.sequences.sequences[resourceID] = resource.Sequence
+	}
+	log.Printf("Loaded versions of %d resources from %s", len(resources), s.config.Braid.StateFile)
+}
+
+// persistResource saves the current version state of a resource to the
+// state file, if there is one
+func (s *BraidMockServer) persistResource(resourceID string) {
+	if s.state == nil {
+		return
+	}
+
+	s.mu.RLock()
+	history, exists := s.history[resourceID]
+	if !exists {
+		s.mu.RUnlock()
+		return
+	}
+	resource := persistedResource{
+		Version: history.Version,
+		Body:    history.Body,
+		Updates: append([]braidproto.Update(nil), history.Updates...),
+		Times:   append([]time.Time(nil), history.Times...),
+		Bodies:  append([][]byte(nil), history.Bodies...),
+	}
+	resource.KnownVersions = s.knownVersions[resourceID].list()
+	s.mu.RUnlock()
+	resource.Sequence = s.sequences.current(resourceID)
+
+	if err := s.state.save(resourceID, resource); err != nil {
+		log.Printf("Error persisting state of resource %s: %v", resourceID, err)
+	}
+}
+
+// persistAll saves the version state of every resource seen, catching up on
+// sequences of changes that bypass the history, such as pushes
+func (s *BraidMockServer) persistAll() {
+	if s.state == nil {
+		return
+	}
+
+	s.mu.RLock()
+	resourceIDs := sortedKeys(s.history)
+	s.mu.RUnlock()
+	for _, resourceID := range resourceIDs {
+		s.persistResource(resourceID)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileState is what polling compares to detect that a mock file changed
+type fileState struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// startPolling takes a first snapshot of the mock files and then polls them
+// for changes, for file systems where file system events don't work
+func (s *fileStore) startPolling() error {
+	states, err := s.scanFiles()
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(s.config.PollInterval) * time.Millisecond
+	log.Printf("Polling for file changes every %v", interval)
+	go s.pollFiles(states, interval)
+	return nil
+}
+
+// pollFiles handles mock files that were changed or added since the last scan
+// until the server is closed
+func (s *fileStore) pollFiles(states map[string]fileState, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			current, err := s.scanFiles()
+			if err != nil {
+				log.Printf("Error polling files: %v", err)
+				continue
+			}
+			for path, state := range current {
+				if previous, exists := states[path]; !exists || previous != state {
+					if strings.HasSuffix(path, metaSuffix) {
+						s.handleMetaChange(path)
+					} else {
+						s.handleFileChange(path)
+					}
+				}
+			}
+			for path := range states {
+				if _, exists := current[path]; !exists && strings.HasSuffix(path, metaSuffix) {
+					s.handleMetaChange(path)
+				}
+			}
+			states = current
+		}
+	}
+}
+
+// scanFiles returns the state of every mock file and sidecar file of all
+// mounts that isn't ignored
+func (s *fileStore) scanFiles() (map[string]fileState, error) {
+	states := make(map[string]fileState)
+	for _, m := range s.mounts {
+		err := walkFiles(m.Dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if s.isIgnored(path) || s.outsideMounts(path) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() && (s.isResourceFile(path) || strings.HasSuffix(path, metaSuffix)) {
+				states[path] = fileState{ModTime: info.ModTime(), Size: info.Size()}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
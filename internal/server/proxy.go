@@ -24,7 +24,7 @@ func (s *BraidMockServer) proxyRequest(w http.ResponseWriter, r *http.Request) {
 	// Create a new request
 	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, proxyURL.String(), r.Body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating proxy request: %v", err), http.StatusInternalServerError)
+		s.writeError(w, fmt.Sprintf("Error creating proxy request: %v", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -46,7 +46,7 @@ func (s *BraidMockServer) proxyRequest(w http.ResponseWriter, r *http.Request) {
 	// Send the request
 	resp, err := client.Do(proxyReq)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error proxying request: %v", err), http.StatusBadGateway)
+		s.writeError(w, fmt.Sprintf("Error proxying request: %v", err), http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
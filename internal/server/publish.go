@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/pkg/braidproto"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+)
+
+// publisher forwards resource changes to a message broker
+type publisher interface {
+	Publish(resourceID string, payload []byte) error
+	Close()
+}
+
+// newPublishers connects to all configured message brokers
+func newPublishers(cfg *config.Config) ([]publisher, error) {
+	var publishers []publisher
+
+	if cfg.NATS.URL != "" {
+		p, err := newNATSPublisher(cfg.NATS)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+
+	if cfg.MQTT.Broker != "" {
+		p, err := newMQTTPublisher(cfg.MQTT)
+		if err != nil {
+			for _, other := range publishers {
+				other.Close()
+			}
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+
+	return publishers, nil
+}
+
+// publishChange sends a resource change to all connected message brokers
+func (s *BraidMockServer) publishChange(resourceID string, update braidproto.Update) {
+	if len(s.publishers) == 0 {
+		return
+	}
+
+	payload, err := s.encodeChange(resourceID, update)
+	if err != nil {
+		log.Printf("Error encoding change payload: %v", err)
+		return
+	}
+
+	for _, p := range s.publishers {
+		if err := p.Publish(resourceID, payload); err != nil {
+			log.Printf("Error publishing change of %s: %v", resourceID, err)
+		}
+	}
+}
+
+// natsPublisher publishes changes to NATS subjects derived from resource paths
+type natsPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+// newNATSPublisher connects to a NATS server
+func newNATSPublisher(cfg config.NATSConfig) (*natsPublisher, error) {
+	conn, err := nats.Connect(cfg.URL, nats.Name("braidmock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	log.Printf("Publishing resource changes to NATS at %s", cfg.URL)
+	return &natsPublisher{conn: conn, prefix: cfg.SubjectPrefix}, nil
+}
+
+// Publish sends a change to the subject of the resource, e.g. /v1.0/users/me
+// is published to <prefix>.v1_0.users.me
+func (p *natsPublisher) Publish(resourceID string, payload []byte) error {
+	tokens := []string{p.prefix}
+	for _, segment := range strings.Split(strings.Trim(resourceID, "/"), "/") {
+		// Dots separate subject tokens and wildcards are reserved
+		tokens = append(tokens, strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(segment))
+	}
+	return p.conn.Publish(strings.Join(tokens, "."), payload)
+}
+
+// Close drains and closes the NATS connection
+func (p *natsPublisher) Close() {
+	p.conn.Drain()
+}
+
+// mqttPublisher publishes changes to MQTT topics derived from resource paths
+type mqttPublisher struct {
+	client mqtt.Client
+	prefix string
+	qos    byte
+}
+
+// newMQTTPublisher connects to an MQTT broker
+func newMQTTPublisher(cfg config.MQTTConfig) (*mqttPublisher, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetAutoReconnect(true)
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", cfg.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+
+	log.Printf("Publishing resource changes to MQTT at %s", cfg.Broker)
+	return &mqttPublisher{client: client, prefix: cfg.TopicPrefix, qos: byte(cfg.QoS)}, nil
+}
+
+// Publish sends a change to the topic of the resource, e.g. /v1.0/users/me
+// is published to <prefix>/v1.0/users/me
+func (p *mqttPublisher) Publish(resourceID string, payload []byte) error {
+	// Wildcards are reserved in topic names
+	topic := p.prefix + strings.NewReplacer("+", "_", "#", "_").Replace(resourceID)
+
+	token := p.client.Publish(topic, p.qos, false, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// Close disconnects from the MQTT broker
+func (p *mqttPublisher) Close() {
+	p.client.Disconnect(250)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// registryShards is the number of independently locked shards subscriptions
+// are spread over by resource
+const registryShards = 64
+
+// subscriptionRegistry holds subscriptions by resource. Resources are spread
+// over shards so that adding and removing subscriptions to different
+// resources doesn't contend on one lock, and each resource's subscriptions
+// have their own lock so that notifying them doesn't block other resources.
+type subscriptionRegistry struct {
+	shards [registryShards]registryShard
+}
+
+// registryShard holds the subscriptions of the resources hashed to it
+type registryShard struct {
+	resources map[string]*resourceSubscriptions
+	mu        sync.RWMutex
+}
+
+// resourceSubscriptions holds the subscriptions to a single resource by ID
+type resourceSubscriptions struct {
+	subs map[string]Subscription
+	mu   sync.Mutex
+}
+
+// newSubscriptionRegistry creates an empty subscription registry
+func newSubscriptionRegistry() *subscriptionRegistry {
+	registry := &subscriptionRegistry{}
+	for i := range registry.shards {
+		registry.shards[i].resources = make(map[string]*resourceSubscriptions)
+	}
+	return registry
+}
+
+// shard returns the shard holding a resource's subscriptions
+func (r *subscriptionRegistry) shard(resourceID string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(resourceID))
+	return &r.shards[h.Sum32()%registryShards]
+}
+
+// withResource calls fn with a resource's subscriptions locked, and reports
+// whether it has any. The shard stays read-locked meanwhile, so the
+// subscriptions can't be dropped from the shard and replaced by a new set
+// that fn wouldn't see.
+func (r *subscriptionRegistry) withResource(resourceID string, fn func(resource *resourceSubscriptions)) bool {
+	shard := r.shard(resourceID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	resource, exists := shard.resources[resourceID]
+	if !exists {
+		return false
+	}
+
+	resource.mu.Lock()
+	defer resource.mu.Unlock()
+	fn(resource)
+	return true
+}
+
+// add stores a subscription under its resource, returning the subscription
+// it replaced, if any
+func (r *subscriptionRegistry) add(sub Subscription) (Subscription, bool) {
+	shard := r.shard(sub.Resource)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	resource, exists := shard.resources[sub.Resource]
+	if !exists {
+		resource = &resourceSubscriptions{subs: make(map[string]Subscription)}
+		shard.resources[sub.Resource] = resource
+	}
+
+	resource.mu.Lock()
+	defer resource.mu.Unlock()
+	old, replaced := resource.subs[sub.ID]
+	resource.subs[sub.ID] = sub
+	return old, replaced
+}
+
+// addIfMissing stores a subscription under its resource unless one with the
+// same ID is already there, and reports whether it was added
+func (r *subscriptionRegistry) addIfMissing(sub Subscription) bool {
+	shard := r.shard(sub.Resource)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	resource, exists := shard.resources[sub.Resource]
+	if !exists {
+		resource = &resourceSubscriptions{subs: make(map[string]Subscription)}
+		shard.resources[sub.Resource] = resource
+	}
+
+	resource.mu.Lock()
+	defer resource.mu.Unlock()
+	if _, exists := resource.subs[sub.ID]; exists {
+		return false
+	}
+	resource.subs[sub.ID] = sub
+	return true
+}
+
+// remove deletes a subscription, returning it if it existed
+func (r *subscriptionRegistry) remove(resourceID, subID string) (Subscription, bool) {
+	shard := r.shard(resourceID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	resource, exists := shard.resources[resourceID]
+	if !exists {
+		return Subscription{}, false
+	}
+
+	resource.mu.Lock()
+	defer resource.mu.Unlock()
+	sub, exists := resource.subs[subID]
+	if !exists {
+		return Subscription{}, false
+	}
+	delete(resource.subs, subID)
+
+	// Clean up empty subscription maps
+	if len(resource.subs) == 0 {
+		delete(shard.resources, resourceID)
+	}
+	return sub, true
+}
+
+// removeAll deletes the subscriptions with the given ID from every resource,
+// such as the entries of a wildcard stream, and returns them
+func (r *subscriptionRegistry) removeAll(subID string) []Subscription {
+	var removed []Subscription
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.mu.Lock()
+		for resourceID, resource := range shard.resources {
+			resource.mu.Lock()
+			if sub, exists := resource.subs[subID]; exists {
+				removed = append(removed, sub)
+				delete(resource.subs, subID)
+			}
+			if len(resource.subs) == 0 {
+				delete(shard.resources, resourceID)
+			}
+			resource.mu.Unlock()
+		}
+		shard.mu.Unlock()
+	}
+	return removed
+}
+
+// list returns a snapshot of a resource's subscriptions
+func (r *subscriptionRegistry) list(resourceID string) []Subscription {
+	var subs []Subscription
+	r.withResource(resourceID, func(resource *resourceSubscriptions) {
+		subs = make([]Subscription, 0, len(resource.subs))
+		for _, sub := range resource.subs {
+			subs = append(subs, sub)
+		}
+	})
+	return subs
+}
+
+// get returns a subscription to a resource if it still exists
+func (r *subscriptionRegistry) get(resourceID, subID string) (Subscription, bool) {
+	var sub Subscription
+	var exists bool
+	r.withResource(resourceID, func(resource *resourceSubscriptions) {
+		sub, exists = resource.subs[subID]
+	})
+	return sub, exists
+}
+
+// count returns the number of subscriptions to a resource
+func (r *subscriptionRegistry) count(resourceID string) int {
+	count := 0
+	r.withResource(resourceID, func(resource *resourceSubscriptions) {
+		count = len(resource.subs)
+	})
+	return count
+}
+
+// update applies a change to a subscription if it still exists, and reports
+// whether it did
+func (r *subscriptionRegistry) update(resourceID, subID string, change func(sub *Subscription)) bool {
+	updated := false
+	r.withResource(resourceID, func(resource *resourceSubscriptions) {
+		sub, exists := resource.subs[subID]
+		if !exists {
+			return
+		}
+		change(&sub)
+		resource.subs[subID] = sub
+		updated = true
+	})
+	return updated
+}
+
+// updateAll applies a change to every subscription to a resource and
+// returns how many there were
+func (r *subscriptionRegistry) updateAll(resourceID string, change func(sub *Subscription)) int {
+	count := 0
+	r.withResource(resourceID, func(resource *resourceSubscriptions) {
+		for subID, sub := range resource.subs {
+			change(&sub)
+			resource.subs[subID] = sub
+		}
+		count = len(resource.subs)
+	})
+	return count
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"testing/fstest"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// discardEncoder drops the updates written to it
+type discardEncoder struct{}
+
+func (discardEncoder) Encode(update braidproto.Update) error { return nil }
+
+// discardFlusher flushes nothing
+type discardFlusher struct{}
+
+func (discardFlusher) Flush() {}
+
+// benchmarkNotify measures notifying n subscribers of one resource of a
+// change, alternating between two states of it
+func benchmarkNotify(b *testing.B, n int, snapshotOnly bool) {
+	// Each notification logs per subscriber
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(output) })
+
+	cfg, _ := config.LoadConfig("")
+	cfg.Braid.SnapshotOnly = snapshotOnly
+	s, err := NewBraidMockServerFromFS(cfg, fstest.MapFS{
+		"doc.braid": {Data: []byte(`{"items": [1, 2, 3], "title": "a"}`)},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(s.Close)
+
+	data, hash, err := s.readResource(nil, "/doc")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		s.AddSubscription("", "", "/doc", "", "", hash, nil, discardFlusher{}, discardEncoder{}, data)
+	}
+	states := [][]byte{
+		[]byte(`{"items": [1, 2, 3, 4], "title": "b"}`),
+		data,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.notifySubscribers("/doc", states[i%2])
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/subscriber")
+}
+
+func BenchmarkNotify10kSubscribers(b *testing.B) {
+	for _, snapshotOnly := range []bool{false, true} {
+		b.Run(fmt.Sprintf("snapshot_only=%t", snapshotOnly), func(b *testing.B) {
+			benchmarkNotify(b, 10000, snapshotOnly)
+		})
+	}
+}
+
+func BenchmarkRegistryList10k(b *testing.B) {
+	registry := newSubscriptionRegistry()
+	for i := 0; i < 10000; i++ {
+		registry.add(Subscription{ID: fmt.Sprint(i), Resource: "/doc"})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		registry.list("/doc")
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// requestIDHeader carries the ID correlating a request across services
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs accepted from clients
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of a request's ID
+type requestIDKey struct{}
+
+// requestIDMiddleware gives every request an ID, taken from its X-Request-ID
+// header or generated, which is returned in the response, included in log
+// lines about the request and forwarded upstream when proxying
+func (s *BraidMockServer) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = s.ids.NewID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether a client's request ID is safe to log and forward
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of a request, or an empty string outside the middleware
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs a message about the request or subscription with the given ID,
+// prefixed with the ID if there is one
+func logf(id, format string, args ...interface{}) {
+	if id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// escapeReason returns why a resource ID or prefix could resolve outside its
+// mock directory, or an empty string if it can't
+func escapeReason(resourceID string) string {
+	if strings.ContainsAny(resourceID, "\\\x00") {
+		return "contains a backslash or NUL byte"
+	}
+	for _, elem := range strings.Split(resourceID, "/") {
+		if elem == ".." {
+			return "resolves outside the mock directories"
+		}
+	}
+	return ""
+}
+
+// rejectionReason returns why a resource ID is never served from the mock
+// directories, or an empty string if it may be
+func (s *fileStore) rejectionReason(resourceID string) string {
+	if reason := s.pathReason(resourceID); reason != "" {
+		return reason
+	}
+	if path, ok := s.findMockFile(resourceID); ok && s.outsideMounts(path) {
+		return "resolves outside the mock directories through a symlink"
+	}
+	return ""
+}
+
+// pathReason returns why a resource ID is refused from its path alone,
+// without looking at the files it resolves to
+func (s *fileStore) pathReason(resourceID string) string {
+	if reason := escapeReason(resourceID); reason != "" {
+		return reason
+	}
+	_, relPath := s.mountForResource(resourceID)
+	if s.isHidden(relPath) {
+		return "is a hidden file"
+	}
+	return ""
+}
+
+// outsideMounts reports whether a file path lies outside every mock directory
+// once symlinks are resolved. Paths that don't exist yet are resolved through
+// their nearest existing parent, where they would be created.
+func (s *fileStore) outsideMounts(path string) bool {
+	realPath, err := resolveExisting(path)
+	if err != nil {
+		return true
+	}
+	for _, m := range s.mounts {
+		dir, err := resolveExisting(m.Dir)
+		if err != nil {
+			continue
+		}
+		relPath, err := filepath.Rel(dir, realPath)
+		if err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveExisting returns the absolute path a file path refers to with
+// symlinks resolved, keeping the elements below its nearest existing parent
+func resolveExisting(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		realPath, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(realPath, rest), nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, fs.ErrNotExist) || parent == path {
+			return "", err
+		}
+		if _, lerr := os.Lstat(path); lerr == nil {
+			// A broken symlink, which could be pointed anywhere
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// logRejectedPath logs a request for a resource the store refuses to serve
+// in strict mode, as a sign of a client probing for files it shouldn't see
+func (s *BraidMockServer) logRejectedPath(r *http.Request, resourceID string) {
+	if !s.config.StrictPaths {
+		return
+	}
+	store, ok := s.store.(diskStore)
+	if !ok {
+		return
+	}
+	if reason := store.rejectionReason(resourceID); reason != "" {
+		logf(requestID(r), "Rejected path %q: %s", resourceID, reason)
+	}
+}
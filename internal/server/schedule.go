@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+
+	"github.com/wI2L/jsondiff"
+)
+
+// scheduleNow is replaced with the time of the change in values set by schedules
+const scheduleNow = "$now"
+
+// startSchedules starts changing the scheduled resources on their intervals
+// until the server is closed
+func (s *BraidMockServer) startSchedules() {
+	for _, schedule := range s.config.Schedules {
+		log.Printf("Changing %s every %dms", schedule.Resource, schedule.Interval)
+		go s.runSchedule(schedule)
+	}
+}
+
+// runSchedule changes a resource every interval of its schedule
+func (s *BraidMockServer) runSchedule(schedule config.ScheduleConfig) {
+	interval := time.Duration(schedule.Interval) * time.Millisecond
+	rng := rand.New(rand.NewSource(s.clock.Now().UnixNano()))
+	timer := s.clock.NewTimer(interval)
+	defer timer.Stop()
+
+	for tick := 0; ; tick++ {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C():
+		}
+
+		if err := s.applySchedule(schedule, tick, rng); err != nil {
+			log.Printf("Error changing scheduled resource %s: %v", schedule.Resource, err)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// applySchedule makes the given change of a schedule to its resource,
+// rewriting it with the next of its files and then setting and mutating
+// its values
+func (s *BraidMockServer) applySchedule(schedule config.ScheduleConfig, tick int, rng *rand.Rand) error {
+	var data []byte
+	var err error
+	if len(schedule.Files) > 0 {
+		data, err = os.ReadFile(schedule.Files[tick%len(schedule.Files)])
+	} else {
+		data, err = s.store.Read(schedule.Resource)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(schedule.Set) > 0 || len(schedule.Mutate) > 0 {
+		// Change values in a stable order, so that pointers into each other apply predictably
+		now := s.clock.Now().UTC().Format(time.RFC3339)
+		var patch jsondiff.Patch
+		for _, pointer := range sortedKeys(schedule.Set) {
+			value := schedule.Set[pointer]
+			if value == scheduleNow {
+				value = now
+			}
+			op, err := SetOperation(pointer, value)
+			if err != nil {
+				return err
+			}
+			patch = append(patch, op)
+		}
+		for _, pointer := range sortedKeys(schedule.Mutate) {
+			value, err := mutateValue(currentValue(data, pointer), schedule.Mutate[pointer], rng)
+			if err != nil {
+				return fmt.Errorf("error mutating %s: %w", pointer, err)
+			}
+			op, err := SetOperation(pointer, value)
+			if err != nil {
+				return err
+			}
+			patch = append(patch, op)
+		}
+
+		if data, err = applyJSONPatch(data, patch); err != nil {
+			return fmt.Errorf("error changing values: %w", err)
+		}
+	}
+
+	return s.writeResource(nil, auditSchedule, schedule.Resource, data)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// validateSchema checks the content of a JSON resource against the JSON
+// Schema attached to it, if any. Schema files are compiled on every check,
+// so edits to them apply right away.
+func (s *BraidMockServer) validateSchema(resourceID string, data []byte) error {
+	schemaFile := s.resourceMeta(resourceID).Schema
+	if schemaFile == "" || s.resourceType(resourceID) != "" {
+		return nil
+	}
+
+	schema, err := jsonschema.NewCompiler().Compile(schemaFile)
+	if err != nil {
+		return fmt.Errorf("invalid schema %s: %w", schemaFile, err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := schema.Validate(doc); err != nil {
+		return fmt.Errorf("does not match schema %s: %w", schemaFile, err)
+	}
+	return nil
+}
+
+// validateFixtures reports the resources that don't match their schemas.
+// Templates and scripted resources are only checked when they change, since
+// rendering them may change their state.
+func (s *BraidMockServer) validateFixtures() {
+	resourceIDs, err := s.store.List("/")
+	if err != nil {
+		log.Printf("Error listing resources to validate: %v", err)
+		return
+	}
+
+	for _, resourceID := range resourceIDs {
+		meta := s.resourceMeta(resourceID)
+		if meta.Schema == "" || meta.dynamic() {
+			continue
+		}
+		data, err := s.store.Read(resourceID)
+		if err != nil {
+			continue
+		}
+		if err := s.validateSchema(resourceID, data); err != nil {
+			log.Printf("Warning: resource %s: %v", resourceID, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// scriptTimeout bounds how long the script of a resource may run
+const scriptTimeout = time.Second
+
+// scriptFunction is the function of a script called with the request and
+// the content of its resource
+const scriptFunction = "respond"
+
+// runScript runs the Lua script of a resource, returning the body its
+// respond function computes from the request being served, nil for change
+// events, and the content of the resource, decoded if it is JSON. Tables
+// are returned as JSON, strings as they are and nil for the content
+// unchanged. Scripts are read on every run, so edits to them apply right away.
+func (s *BraidMockServer) runScript(r *http.Request, resourceID, script string, data []byte) ([]byte, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	ctx, cancel := context.WithTimeout(ctx, scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	conv := newLuaConverter(L)
+	if err := L.DoFile(script); err != nil {
+		return nil, fmt.Errorf("error in script: %w", err)
+	}
+	respond, ok := L.GetGlobal(scriptFunction).(*lua.LFunction)
+	if !ok {
+		return nil, fmt.Errorf("script %s has no %s function", script, scriptFunction)
+	}
+
+	var resource lua.LValue = lua.LString(data)
+	if s.resourceType(resourceID) == "" {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("invalid JSON resource: %w", err)
+		}
+		resource = conv.toLua(value)
+	}
+	if err := L.CallByParam(lua.P{Fn: respond, NRet: 1, Protect: true}, conv.request(r, resourceID), resource); err != nil {
+		return nil, fmt.Errorf("error in script: %w", err)
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+
+	switch result := result.(type) {
+	case *lua.LNilType:
+		return data, nil
+	case lua.LString:
+		return []byte(result), nil
+	default:
+		return json.Marshal(conv.fromLua(result))
+	}
+}
+
+// luaConverter converts values between JSON and Lua. Lua tables don't tell
+// arrays from objects, so arrays decoded from JSON are marked with a
+// metatable, keeping empty ones arrays, and JSON null is the global null.
+type luaConverter struct {
+	L         *lua.LState
+	arrayMeta *lua.LTable
+	null      *lua.LUserData
+}
+
+func newLuaConverter(L *lua.LState) *luaConverter {
+	conv := &luaConverter{L: L, arrayMeta: L.NewTable(), null: L.NewUserData()}
+	L.SetGlobal("null", conv.null)
+	return conv
+}
+
+// request describes a request to a resource as a table, or is nil without one
+func (c *luaConverter) request(r *http.Request, resourceID string) lua.LValue {
+	if r == nil {
+		return lua.LNil
+	}
+	query := c.L.NewTable()
+	for name, values := range r.URL.Query() {
+		query.RawSetString(name, lua.LString(values[0]))
+	}
+	headers := c.L.NewTable()
+	for name, values := range r.Header {
+		headers.RawSetString(name, lua.LString(values[0]))
+	}
+
+	request := c.L.NewTable()
+	request.RawSetString("method", lua.LString(r.Method))
+	request.RawSetString("path", lua.LString(r.URL.Path))
+	request.RawSetString("resource", lua.LString(resourceID))
+	request.RawSetString("query", query)
+	request.RawSetString("headers", headers)
+	if body := requestBody(r); body != nil {
+		request.RawSetString("body", c.toLua(body))
+	}
+	return request
+}
+
+// toLua converts a decoded JSON value to Lua
+func (c *luaConverter) toLua(value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return c.null
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		table := c.L.NewTable()
+		for _, item := range v {
+			table.Append(c.toLua(item))
+		}
+		c.L.SetMetatable(table, c.arrayMeta)
+		return table
+	case map[string]interface{}:
+		table := c.L.NewTable()
+		for key, item := range v {
+			table.RawSetString(key, c.toLua(item))
+		}
+		return table
+	}
+	return lua.LNil
+}
+
+// fromLua converts a Lua value to JSON. Tables are arrays if they were
+// decoded from one, or have only the keys 1 to n.
+func (c *luaConverter) fromLua(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		keys := 0
+		v.ForEach(func(lua.LValue, lua.LValue) { keys++ })
+		if c.L.GetMetatable(v) == c.arrayMeta || (keys > 0 && keys == v.MaxN()) {
+			array := make([]interface{}, 0, v.MaxN())
+			for i := 1; i <= v.MaxN(); i++ {
+				array = append(array, c.fromLua(v.RawGetInt(i)))
+			}
+			return array
+		}
+		object := make(map[string]interface{}, keys)
+		v.ForEach(func(key, item lua.LValue) {
+			object[key.String()] = c.fromLua(item)
+		})
+		return object
+	}
+	return nil
+}
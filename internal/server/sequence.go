@@ -0,0 +1,51 @@
+package server
+
+import "sync"
+
+// sequenceState numbers the changes of each resource, so subscribers can
+// tell from the Sequence of updates whether they missed one, and serializes
+// the notification of each resource's changes, so every subscription gets
+// them in order
+type sequenceState struct {
+	mu        sync.Mutex
+	sequences map[string]uint64      // Sequence of the latest change, by resource
+	locks     map[string]*sync.Mutex // Held while notifying subscribers, by resource
+}
+
+func newSequenceState() *sequenceState {
+	return &sequenceState{
+		sequences: make(map[string]uint64),
+		locks:     make(map[string]*sync.Mutex),
+	}
+}
+
+// next numbers a new change of a resource
+func (t *sequenceState) next(resourceID string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sequences[resourceID]++
+	return t.sequences[resourceID]
+}
+
+// current returns the sequence of the latest change of a resource, 0 if it
+// hasn't changed yet
+func (t *sequenceState) current(resourceID string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sequences[resourceID]
+}
+
+// lock waits until no other change of a resource is being notified, and
+// returns a function to call once this one has been
+func (t *sequenceState) lock(resourceID string) func() {
+	t.mu.Lock()
+	lock, ok := t.locks[resourceID]
+	if !ok {
+		lock = &sync.Mutex{}
+		t.locks[resourceID] = lock
+	}
+	t.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
@@ -4,54 +4,225 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"net/http/httputil"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 
 	"gihan9a/braidmock/internal/config"
 	"gihan9a/braidmock/internal/utils"
+	"gihan9a/braidmock/pkg/braidproto"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/mux"
 )
 
+// Mock files are named "<name>.braid" for JSON resources and "<name>.braid.<ext>"
+// for other types, such as "<name>.braid.txt" for plain text. Fixtures named
+// "<name>.json" can be served as JSON resources too.
+const (
+	resourceSuffix    = ".braid"
+	textExtension     = "txt"
+	jsonFixtureSuffix = ".json"
+)
+
 // Subscription represents a client subscription to resource changes
 type Subscription struct {
-	ID           string
-	W            http.ResponseWriter
-	F            http.Flusher
-	LastResource []byte // Store the last resource state to calculate patches
-	LastHash     string // Store the hash of the last resource
+	ID          string
+	RequestID   string // ID of the request that opened the subscription, for correlating log lines
+	Session     string // Session of the subscriber, which doesn't see shared changes to resources it has written
+	Resource    string // Resource ID the subscription receives updates for
+	Wildcard    bool   // Whether the subscription belongs to a wildcard stream, labeling each update with its resource
+	Pointer     string // JSON Pointer the subscription is scoped to, empty for the whole resource
+	PatchFormat string // Patch format the subscriber asked for, empty for the resource's format
+	W           http.ResponseWriter
+	F           http.Flusher
+	Encoder     updateEncoder // Writes updates in the subscriber's wire format
+	LastHash    string        // Hash of the last resource state sent, whose content is kept in the version store to calculate patches
+	LastVersion []string      // Versions of the last update sent, used as Parents of the next one
 }
 
 // BraidMockServer implements a mock server for the Braid protocol
 type BraidMockServer struct {
 	config        *config.Config
-	subscriptions map[string]map[string]Subscription
+	subscriptions *subscriptionRegistry
+	wildcards     map[string]map[string]wildcardSubscription
 	versions      map[string]string
 	hashes        map[string]string
+	knownVersions map[string]*versionSet
+	history       map[string]*resourceHistory
+	cache         map[string]cachedResource
+	metas         map[string]resourceMeta // Settings of resources, cached with the resources
+	infos         map[string]ResourceInfo // Mock files of resources, cached with the resources
+	cacheGen      uint64                  // Incremented whenever cached settings or mock files are invalidated
+	largeHashes   map[string]largeHash    // Versions of large resources, by resource
+	templates     *templateState
+	sessions      *sessionState
+	tombstones    *tombstoneState
+	sequences     *sequenceState
+	traffic       *trafficRecorder // Recent exchanges exported as HAR, nil when not recording
+	audit         *auditLog        // Writes to resources, nil when not recording
+	state         *stateFile       // Versions and history kept across restarts, nil when kept in memory
+	states        *versionStore
+	hasher        utils.Hasher
+	ids           utils.IDGenerator
+	clock         utils.Clock
+	publishers    []publisher
+	cluster       cluster                           // Other instances of the mock, nil when running alone
+	instance      string                            // ID of this instance among the others
+	remoteWrites  *remoteWrites                     // Writes from other instances the store hasn't reported yet
+	middleware    []func(http.Handler) http.Handler // Middleware added with Use, wrapping the routes
+	hooks         []Hook
+	jwt           *jwtVerifier
+	authRules     []authRule
+	store         ResourceStore
 	reverseProxy  *httputil.ReverseProxy
+	streams       *streamCounter // Open subscription streams, counted against the subscription limits
+	done          chan struct{}  // Closed when the server is closed
 	mu            sync.RWMutex
-	watcher       *fsnotify.Watcher
 }
 
-// NewBraidMockServer creates a new BraidMockServer
+// NewBraidMockServer creates a new BraidMockServer serving mock files from
+// the configured directories, or the configured bucket
 func NewBraidMockServer(config *config.Config) (*BraidMockServer, error) {
-	// Create file watcher
-	watcher, err := fsnotify.NewWatcher()
+	var store ResourceStore
+	var err error
+	if config.Bucket.URL != "" {
+		store, err = newBucketStore(config)
+	} else {
+		store, err = newFileStore(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := NewBraidMockServerWithStore(config, store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return server, nil
+}
+
+// NewBraidMockServerWithStore creates a new BraidMockServer serving resources
+// from the given store, which the server closes when it is closed
+func NewBraidMockServerWithStore(config *config.Config, store ResourceStore) (_ *BraidMockServer, err error) {
+	// Release what was opened so far if a later step fails
+	var closers []func()
+	defer func() {
+		if err != nil {
+			for i := len(closers) - 1; i >= 0; i-- {
+				closers[i]()
+			}
+		}
+	}()
+
+	// Create version hasher
+	hasher, err := utils.NewHasher(config.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect to message brokers that changes are published to
+	publishers, err := newPublishers(config)
+	if err != nil {
+		return nil, err
+	}
+	closers = append(closers, func() {
+		for _, p := range publishers {
+			p.Close()
+		}
+	})
+
+	// Load simulated auth outcomes
+	authRules, err := loadAuthRules(config.Auth.RulesFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+		return nil, err
+	}
+
+	// Set up JWT validation
+	jwtVerifier, err := newJWTVerifier(config.Auth.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if jwtVerifier != nil {
+		closers = append(closers, jwtVerifier.Close)
+	}
+
+	// Join the other instances of the mock
+	peers, err := newCluster(config)
+	if err != nil {
+		return nil, err
+	}
+	if peers != nil {
+		closers = append(closers, peers.Close)
+	}
+
+	// Record writes to resources, kept for the admin API and appended to the audit file
+	var audit *auditLog
+	auditEntries := config.Admin.AuditEntries
+	if !config.Admin.Enabled {
+		auditEntries = 0
+	}
+	if auditEntries > 0 || config.Admin.AuditFile != "" {
+		if audit, err = newAuditLog(auditEntries, config.Admin.AuditFile); err != nil {
+			return nil, err
+		}
+		closers = append(closers, audit.close)
+	}
+
+	// Resume the versions and history of the last run
+	var state *stateFile
+	var persisted map[string]persistedResource
+	if config.Braid.StateFile != "" {
+		if state, err = openStateFile(config.Braid.StateFile); err != nil {
+			return nil, err
+		}
+		closers = append(closers, state.close)
+		if persisted, err = state.load(); err != nil {
+			return nil, err
+		}
 	}
 
 	server := &BraidMockServer{
 		config:        config,
-		subscriptions: make(map[string]map[string]Subscription),
+		subscriptions: newSubscriptionRegistry(),
+		streams:       newStreamCounter(),
+		wildcards:     make(map[string]map[string]wildcardSubscription),
 		versions:      make(map[string]string),
 		hashes:        make(map[string]string),
-		watcher:       watcher,
+		knownVersions: make(map[string]*versionSet),
+		history:       make(map[string]*resourceHistory),
+		cache:         make(map[string]cachedResource),
+		metas:         make(map[string]resourceMeta),
+		infos:         make(map[string]ResourceInfo),
+		largeHashes:   make(map[string]largeHash),
+		templates:     newTemplateState(),
+		sessions:      newSessionState(),
+		audit:         audit,
+		state:         state,
+		tombstones:    newTombstoneState(),
+		sequences:     newSequenceState(),
+		states:        newVersionStore(),
+		hasher:        hasher,
+		ids:           utils.UUIDGenerator{},
+		clock:         utils.RealClock{},
+		publishers:    publishers,
+		cluster:       peers,
+		instance:      utils.UUIDGenerator{}.NewID(),
+		remoteWrites:  newRemoteWrites(),
+		jwt:           jwtVerifier,
+		authRules:     authRules,
+		store:         store,
+		done:          make(chan struct{}),
+	}
+
+	// Record traffic for export through the admin API
+	if config.Admin.Enabled && config.Admin.HAREntries > 0 {
+		server.traffic = &trafficRecorder{max: config.Admin.HAREntries}
+	}
+	if state != nil {
+		server.restoreState(persisted)
 	}
 
 	// Configure reverse proxy if URL is provided
@@ -59,9 +230,6 @@ func NewBraidMockServer(config *config.Config) (*BraidMockServer, error) {
 		server.setupProxy()
 	}
 
-	// Start watching for file changes
-	go server.watchFiles()
-
 	return server, nil
 }
 
@@ -89,6 +257,10 @@ func (s *BraidMockServer) setupProxy() {
 			}
 		},
 		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logf(requestID(r), "Error proxying %s: %v", r.URL.Path, err)
+			s.writeError(w, fmt.Sprintf("Error proxying request: %v", err), http.StatusBadGateway)
+		},
 	}
 
 	log.Printf("Proxy mode enabled: Requests not found locally will be forwarded to %s", s.config.ProxyURL.String())
@@ -99,117 +271,200 @@ func (s *BraidMockServer) setupProxy() {
 
 // Close cleans up resources used by the server
 func (s *BraidMockServer) Close() {
-	if s.watcher != nil {
-		s.watcher.Close()
+	close(s.done)
+	s.store.Close()
+	if s.audit != nil {
+		s.audit.close()
+	}
+	if s.state != nil {
+		s.persistAll()
+		s.state.close()
+	}
+	for _, p := range s.publishers {
+		p.Close()
 	}
+	if s.cluster != nil {
+		s.cluster.Close()
+	}
+	if s.jwt != nil {
+		s.jwt.Close()
+	}
+}
+
+// SetIDGenerator replaces the generator of subscription, connection and
+// request IDs, e.g. with a utils.SequentialIDGenerator for deterministic tests
+func (s *BraidMockServer) SetIDGenerator(ids utils.IDGenerator) {
+	s.ids = ids
+}
+
+// SetClock replaces the clock timing subscriptions and webhook retries, e.g.
+// with a utils.FakeClock so tests don't have to wait in real time
+func (s *BraidMockServer) SetClock(clock utils.Clock) {
+	s.clock = clock
 }
 
-// SetupWatchers recursively adds directories to the watcher
+// SetupWatchers validates the resources against their schemas, then starts
+// watching the store for changes to resources, and the configured schedules
+// changing them
 func (s *BraidMockServer) SetupWatchers() error {
-	return filepath.Walk(s.config.RootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	s.validateFixtures()
+	if store, ok := s.store.(deletionWatcher); ok {
+		store.WatchDeletions(s.handleStoreDeletion)
+	}
+	if store, ok := s.store.(metaWatcher); ok {
+		store.WatchMeta(s.invalidateMeta)
+	}
+	if err := s.store.Watch(s.handleStoreChange); err != nil {
+		return err
+	}
+	if s.cluster != nil {
+		if err := s.cluster.Subscribe(s.handleClusterMessage); err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return s.watcher.Add(path)
-		}
-		return nil
-	})
+	}
+	s.startSchedules()
+	return nil
 }
 
-// watchFiles monitors file changes and sends updates to subscribers
-func (s *BraidMockServer) watchFiles() {
-	for {
-		select {
-		case event, ok := <-s.watcher.Events:
-			if !ok {
-				return
-			}
-
-			// Only process .braid file writes
-			if !strings.HasSuffix(event.Name, ".braid") || event.Op&fsnotify.Write != fsnotify.Write {
-				continue
-			}
-
-			// Get resource ID from file path
-			resourceID, err := s.getResourceIDFromPath(event.Name)
-			if err != nil {
-				log.Printf("Error determining resource ID: %v", err)
-				continue
-			}
-
-			log.Printf("File changed: %s, resourceID: %s", event.Name, resourceID)
-
-			// Read updated content
-			data, err := os.ReadFile(event.Name)
-			if err != nil {
-				log.Printf("Error reading file: %v", err)
-				continue
-			}
+// handleResourceChange records the new state of a changed resource and sends
+// it to subscribers, along with resources whose templates include it
+func (s *BraidMockServer) handleResourceChange(resourceID string, data []byte) {
+	// Writing a deleted resource brings it back
+	s.tombstones.remove("", resourceID)
+	s.observeWrite(resourceID, data)
+
+	if s.resourceMeta(resourceID).dynamic() {
+		var err error
+		if data, err = s.renderResource(nil, resourceID, data); err != nil {
+			log.Printf("Error rendering resource %s: %v", resourceID, err)
+			return
+		}
+	}
 
-			// Calculate hash for the resource
-			hash := utils.CalculateHash(data)
+	// Changed fixtures are still served, but drifting from their schema is reported
+	if err := s.validateSchema(resourceID, data); err != nil {
+		log.Printf("Warning: resource %s: %v", resourceID, err)
+	}
 
-			s.mu.Lock()
-			s.versions[resourceID] = hash
-			s.hashes[resourceID] = hash
-			s.mu.Unlock()
+	// Record the new version of the resource. Resources seen for the first
+	// time have no change to record, but are audited as created.
+	unlock := s.sequences.lock(resourceID)
+	s.mu.RLock()
+	_, seen := s.history[resourceID]
+	s.mu.RUnlock()
+	hash := s.observeResource(resourceID, data)
+	if !seen {
+		s.auditChange(resourceID, braidproto.Update{Version: []string{hash}, Body: string(data)})
+	}
+	s.cacheResource(resourceID, data, hash)
 
-			// Notify subscribers
-			s.notifySubscribers(resourceID, data)
+	// Notify subscribers
+	s.notifySubscribers(resourceID, data)
+	unlock()
 
-		case err, ok := <-s.watcher.Errors:
-			if !ok {
-				return
-			}
-			log.Printf("Watcher error: %v", err)
+	// Resources including this one change with it. Include cycles fail to
+	// render, so they stop here.
+	for _, includer := range s.templates.includersOf(resourceID) {
+		if !s.resourceMeta(includer).Template {
+			continue
 		}
+		data, err := s.store.Read(includer)
+		if err != nil {
+			continue
+		}
+		s.handleResourceChange(includer, data)
 	}
 }
 
-// getResourceIDFromPath converts a file path to a resource ID
-func (s *BraidMockServer) getResourceIDFromPath(path string) (string, error) {
-	// Make the path relative to the root directory
-	relPath, err := filepath.Rel(s.config.RootDir, path)
-	if err != nil {
-		return "", err
-	}
-
-	// Remove .braid extension
-	resourceID := strings.TrimSuffix(relPath, ".braid")
+// UpdateResource replaces the body of an existing resource in the server's
+// store and sends the change to subscribers, e.g. from tests serving
+// embedded fixtures
+func (s *BraidMockServer) UpdateResource(resourceID string, data []byte) error {
+	return s.writeResource(nil, auditAPI, resourceID, data)
+}
 
-	// Convert Windows path separators to URL path separators
-	resourceID = strings.ReplaceAll(resourceID, "\\", "/")
+// resolveResourceID returns the resource ID a request path refers to. Unless
+// trailing slashes are strict, a path with or without one falls back to the
+// other form when only that has a mock file.
+func (s *BraidMockServer) resolveResourceID(requestPath string) string {
+	resourceID := requestPath
+	if s.config.TrailingSlash != config.TrailingSlashStrict && !s.store.Exists(requestPath) {
+		alternative := requestPath + "/"
+		if strings.HasSuffix(requestPath, "/") {
+			alternative = strings.TrimSuffix(requestPath, "/")
+		}
+		if alternative != "" && s.store.Exists(alternative) {
+			resourceID = alternative
+		}
+	}
 
-	// Ensure the path starts with /
-	if !strings.HasPrefix(resourceID, "/") {
-		resourceID = "/" + resourceID
+	// Resources are known by the ID their store gives them, such as the case
+	// of their mock file when matching regardless of case
+	if info, err := s.store.Stat(resourceID); err == nil {
+		resourceID = info.ID
 	}
+	return resourceID
+}
 
-	return resourceID, nil
+// resourceType returns the extension of a typed resource such as "txt", or
+// an empty string for JSON resources
+func (s *BraidMockServer) resourceType(resourceID string) string {
+	info, _ := s.statResource(resourceID)
+	return info.Type
 }
 
-// getPathFromResourceID converts a resource ID to a file path
-func (s *BraidMockServer) getPathFromResourceID(resourceID string) string {
-	// Remove leading / if present
-	if strings.HasPrefix(resourceID, "/") {
-		resourceID = resourceID[1:]
-	}
+// isTextResource reports whether a resource is served from a plain-text mock file
+func (s *BraidMockServer) isTextResource(resourceID string) bool {
+	return s.resourceType(resourceID) == textExtension
+}
 
-	// Create complete path
-	return filepath.Join(s.config.RootDir, resourceID+".braid")
+// isBinaryResource reports whether a resource is neither JSON nor plain text,
+// so changes to it can only be sent as full bodies
+func (s *BraidMockServer) isBinaryResource(resourceID string) bool {
+	ext := s.resourceType(resourceID)
+	return ext != "" && ext != textExtension
 }
 
-// fileExists checks if a mock file exists for the given resource ID
-func (s *BraidMockServer) fileExists(resourceID string) bool {
-	filePath := s.getPathFromResourceID(resourceID)
-	_, err := os.Stat(filePath)
-	return err == nil
+// contentType returns the media type a resource is served as, from its
+// settings, the configured extension map or the extension's registered type
+func (s *BraidMockServer) contentType(resourceID string, meta resourceMeta) string {
+	if meta.ContentType != "" {
+		return meta.ContentType
+	}
+
+	switch ext := s.resourceType(resourceID); ext {
+	case "":
+		return "application/json"
+	case textExtension:
+		return "text/plain; charset=utf-8"
+	default:
+		if contentType, ok := s.config.Braid.ContentTypes[ext]; ok {
+			return contentType
+		}
+		if contentType := mime.TypeByExtension("." + ext); contentType != "" {
+			return contentType
+		}
+		return "application/octet-stream"
+	}
 }
 
 // SetupRoutes configures the HTTP routes for the server
 func (s *BraidMockServer) SetupRoutes() http.Handler {
 	router := mux.NewRouter()
+	router.Use(s.requestIDMiddleware, s.limitsMiddleware, s.wireTraceMiddleware, s.headersMiddleware, s.authRulesMiddleware, s.authMiddleware, s.compressionMiddleware, s.recordingMiddleware, s.chaosMiddleware, s.hookMiddleware)
+	for _, middleware := range s.middleware {
+		router.Use(middleware)
+	}
+	if s.config.Admin.Enabled {
+		s.setupAdminRoutes(router.PathPrefix(s.config.Admin.Prefix).Subrouter())
+		router.HandleFunc(s.config.Admin.UIPath, s.handleDashboard).Methods("GET")
+		router.HandleFunc(s.config.Admin.UIPath+"/", s.handleDashboard).Methods("GET")
+		router.PathPrefix(s.config.Admin.EditPath+"/").HandlerFunc(s.handleEditor).Methods("GET", "PUT")
+	}
+	if s.config.WebSocket.Enabled {
+		router.HandleFunc(s.config.WebSocket.Path, s.handleWebSocket)
+	}
+	router.HandleFunc(s.config.IndexPath, s.handleIndex).Methods("GET", "HEAD")
 	router.PathPrefix("/").HandlerFunc(s.handleBraidRequest)
 	return router
 }
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// sessionState holds the resources written in isolated sessions. Sessions
+// see the shared resources until they write one, and from then on their own
+// copy of it.
+type sessionState struct {
+	mu        sync.RWMutex
+	resources map[string]map[string][]byte // Written content, by session and resource
+}
+
+func newSessionState() *sessionState {
+	return &sessionState{resources: make(map[string]map[string][]byte)}
+}
+
+// read returns a session's copy of a resource, or false if it has none
+func (t *sessionState) read(session, resourceID string) ([]byte, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	data, ok := t.resources[session][resourceID]
+	return data, ok
+}
+
+// has reports whether a session has its own copy of a resource
+func (t *sessionState) has(session, resourceID string) bool {
+	_, ok := t.read(session, resourceID)
+	return ok
+}
+
+// write replaces a session's copy of a resource
+func (t *sessionState) write(session, resourceID string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resources[session] == nil {
+		t.resources[session] = make(map[string][]byte)
+	}
+	t.resources[session][resourceID] = data
+}
+
+// reset discards the copies of a session, returning the resources it had written
+func (t *sessionState) reset(session string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resourceIDs := sortedKeys(t.resources[session])
+	delete(t.resources, session)
+	return resourceIDs
+}
+
+// list returns the resources written in each session
+func (t *sessionState) list() map[string][]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	sessions := make(map[string][]string, len(t.resources))
+	for session, resources := range t.resources {
+		sessions[session] = sortedKeys(resources)
+	}
+	return sessions
+}
+
+// requestSession returns the session of a request, from the configured
+// header or cookie. Requests without either, nil requests and all requests
+// while sessions are disabled share the resources of the session "".
+func (s *BraidMockServer) requestSession(r *http.Request) string {
+	if r == nil || !s.config.Sessions.Enabled {
+		return ""
+	}
+	if session := r.Header.Get(s.config.Sessions.Header); session != "" {
+		return session
+	}
+	if cookie, err := r.Cookie(s.config.Sessions.Cookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// resourceExists reports whether a resource exists in the store, or in the
+// session of a request
+func (s *BraidMockServer) resourceExists(r *http.Request, resourceID string) bool {
+	return s.store.Exists(resourceID) || s.sessions.has(s.requestSession(r), resourceID)
+}
+
+// readStoredResource returns the stored content of a resource, or the copy
+// written in the session of a request if it has one
+func (s *BraidMockServer) readStoredResource(r *http.Request, resourceID string) ([]byte, error) {
+	if data, ok := s.sessions.read(s.requestSession(r), resourceID); ok {
+		return data, nil
+	}
+	return s.store.Read(resourceID)
+}
+
+// readSessionResource returns a session's copy of a resource and its
+// version. Session copies have no history, so their versions are only
+// recorded as known.
+func (s *BraidMockServer) readSessionResource(r *http.Request, resourceID string, data []byte) ([]byte, string, error) {
+	if s.resourceMeta(resourceID).dynamic() {
+		var err error
+		if data, err = s.renderResource(r, resourceID, data); err != nil {
+			return nil, "", err
+		}
+	}
+	hash := s.hasher.Hash(data)
+	s.recordVersions(resourceID, hash)
+	return data, hash, nil
+}
+
+// writeSessionResource replaces a session's copy of a resource and sends it
+// to the subscribers in the session
+func (s *BraidMockServer) writeSessionResource(r *http.Request, source, session, resourceID string, data []byte) error {
+	if s.audit != nil {
+		if old, err := s.readStoredResource(r, resourceID); err == nil {
+			s.auditUpdate(r, source, resourceID, s.changeUpdate(resourceID, s.hasher.Hash(old), s.hasher.Hash(data), old, data))
+		}
+	}
+
+	s.sessions.write(session, resourceID, data)
+	s.tombstones.remove(session, resourceID)
+	log.Printf("Resource %s written in session %s", resourceID, session)
+
+	data, _, err := s.readSessionResource(nil, resourceID, data)
+	if err != nil {
+		return err
+	}
+	unlock := s.sequences.lock(resourceID)
+	s.sequences.next(resourceID)
+	s.notifySession(session, resourceID, data)
+	unlock()
+	return nil
+}
+
+// resetSession discards the copies and deletions of a session, sending the
+// shared resources back to the subscribers in the session
+func (s *BraidMockServer) resetSession(session string) []string {
+	resourceIDs := s.sessions.reset(session)
+	for _, resourceID := range s.tombstones.reset(session) {
+		if !slices.Contains(resourceIDs, resourceID) {
+			resourceIDs = append(resourceIDs, resourceID)
+		}
+	}
+	slices.Sort(resourceIDs)
+	for _, resourceID := range resourceIDs {
+		data, _, err := s.readResource(nil, resourceID)
+		if err != nil {
+			continue
+		}
+		unlock := s.sequences.lock(resourceID)
+		s.sequences.next(resourceID)
+		s.notifySession(session, resourceID, data)
+		unlock()
+	}
+	log.Printf("Session %s reset (%d resources)", session, len(resourceIDs))
+	return resourceIDs
+}
+
+// handleAdminSessions lists the sessions with the resources they have written
+func (s *BraidMockServer) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.sessions.list())
+}
+
+// handleAdminResetSession discards the copies of the session named by the
+// session query parameter
+func (s *BraidMockServer) handleAdminResetSession(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	if session == "" {
+		http.Error(w, "Missing session parameter", http.StatusBadRequest)
+		return
+	}
+	resourceIDs := s.resetSession(session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session":   session,
+		"resources": resourceIDs,
+	})
+}
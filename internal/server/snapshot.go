@@ -0,0 +1,263 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// snapshotManifest is the first entry of a snapshot, describing the
+// resources whose mock files follow it
+const snapshotManifest = "manifest.json"
+
+// snapshotResource describes a resource in a snapshot along with its version history
+type snapshotResource struct {
+	Resource      string              `json:"resource"`
+	File          string              `json:"file"`                     // Name of the entry holding the resource's content
+	Version       string              `json:"version,omitempty"`        // Version of the content, if the server had seen it
+	KnownVersions []string            `json:"known_versions,omitempty"` // Versions the server has produced for the resource
+	Updates       []braidproto.Update `json:"updates,omitempty"`        // Buffered updates leading up to the version
+	Times         []time.Time         `json:"times,omitempty"`          // When each of the updates was recorded
+}
+
+// WriteSnapshot writes all resources and their version history to w as a
+// gzipped tarball. Apart from the manifest, its entries are laid out like
+// mock files, so the tarball can also be extracted and served directly.
+func (s *BraidMockServer) WriteSnapshot(w io.Writer) error {
+	resourceIDs, err := s.store.List("/")
+	if err != nil {
+		return fmt.Errorf("error listing resources: %w", err)
+	}
+
+	resources := make([]snapshotResource, 0, len(resourceIDs))
+	for _, resourceID := range resourceIDs {
+		resources = append(resources, s.snapshotResource(resourceID))
+	}
+	manifest, err := json.MarshalIndent(resources, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modTime := s.clock.Now()
+	if err := writeTarEntry(tw, snapshotManifest, int64(len(manifest)), modTime, strings.NewReader(string(manifest))); err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		info, err := s.store.Stat(resource.Resource)
+		if err != nil {
+			return fmt.Errorf("error reading resource %s: %w", resource.Resource, err)
+		}
+		file, err := s.store.Open(resource.Resource)
+		if err != nil {
+			return fmt.Errorf("error reading resource %s: %w", resource.Resource, err)
+		}
+		err = writeTarEntry(tw, resource.File, info.Size, modTime, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// RestoreSnapshot replaces the content of the resources in a snapshot
+// written by WriteSnapshot and restores their version history. Subscribers
+// receive the restored content like any other change. Resources that aren't
+// in the snapshot are left alone.
+func (s *BraidMockServer) RestoreSnapshot(r io.Reader) error {
+	return s.restoreSnapshot(r, nil)
+}
+
+// errRestoreRefused is returned when a snapshot can't be restored because
+// the check of one of its resources refused it
+var errRestoreRefused = errors.New("snapshot restore refused")
+
+// restoreSnapshot restores a snapshot like RestoreSnapshot, if check, when
+// given, allows writing every resource in it. Nothing is restored otherwise.
+func (s *BraidMockServer) restoreSnapshot(r io.Reader, check func(resourceID string) bool) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != snapshotManifest {
+		return fmt.Errorf("invalid snapshot: missing %s", snapshotManifest)
+	}
+	var resources []snapshotResource
+	if err := json.NewDecoder(tr).Decode(&resources); err != nil {
+		return fmt.Errorf("invalid snapshot manifest: %w", err)
+	}
+	byFile := make(map[string]snapshotResource, len(resources))
+	for _, resource := range resources {
+		if check != nil && !check(resource.Resource) {
+			return errRestoreRefused
+		}
+		byFile[resource.File] = resource
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
+
+		resource, ok := byFile[header.Name]
+		if !ok {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
+
+		// Restore the history first, so the change event of the write
+		// continues it instead of recording the restored content as new
+		s.auditRestore(resource, data)
+		s.restoreHistory(resource, data)
+		s.persistResource(resource.Resource)
+		if err := s.store.Write(resource.Resource, data); err != nil {
+			return fmt.Errorf("error restoring resource %s: %w", resource.Resource, err)
+		}
+	}
+}
+
+// snapshotResource describes a resource and its current version history
+func (s *BraidMockServer) snapshotResource(resourceID string) snapshotResource {
+	info, _ := s.store.Stat(resourceID)
+	file := strings.TrimPrefix(resourceID, "/")
+	if file == "" || strings.HasSuffix(file, "/") {
+		file += s.config.IndexName
+	}
+	file += resourceSuffix
+	if info.Type != "" {
+		file += "." + info.Type
+	}
+	resource := snapshotResource{Resource: resourceID, File: path.Clean(file)}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if history, exists := s.history[resourceID]; exists {
+		resource.Version = history.Version
+		resource.Updates = append([]braidproto.Update(nil), history.Updates...)
+		resource.Times = append([]time.Time(nil), history.Times...)
+	}
+	resource.KnownVersions = s.knownVersions[resourceID].list()
+	return resource
+}
+
+// restoreHistory replaces the version history of a resource with the one
+// from a snapshot
+func (s *BraidMockServer) restoreHistory(resource snapshotResource, data []byte) {
+	if resource.Version == "" {
+		return
+	}
+
+	// Snapshots from before updates were timed have unknown times, and
+	// snapshots don't keep past bodies
+	if len(resource.Times) != len(resource.Updates) {
+		resource.Times = make([]time.Time, len(resource.Updates))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history[resource.Resource] = &resourceHistory{
+		Version: resource.Version,
+		Body:    data,
+		Updates: resource.Updates,
+		Times:   resource.Times,
+		Bodies:  make([][]byte, len(resource.Updates)),
+	}
+	known := newVersionSet()
+	for _, version := range resource.KnownVersions {
+		known.add(version, s.knownVersionsLimit())
+	}
+	s.knownVersions[resource.Resource] = known
+}
+
+// auditRestore records the restore of a resource in the audit log. Restoring
+// its history hides the change from the store's change event, which only
+// records the restore of resources without history.
+func (s *BraidMockServer) auditRestore(resource snapshotResource, data []byte) {
+	if s.audit == nil {
+		return
+	}
+	if resource.Version == "" {
+		s.expectWrite(nil, auditRestore, resource.Resource, data)
+		return
+	}
+
+	var oldVersion string
+	var oldData []byte
+	s.mu.RLock()
+	if history, exists := s.history[resource.Resource]; exists {
+		oldVersion, oldData = history.Version, history.Body
+	}
+	s.mu.RUnlock()
+	if oldVersion == "" || oldVersion == resource.Version {
+		return
+	}
+	update := s.changeUpdate(resource.Resource, oldVersion, resource.Version, oldData, data)
+	s.auditUpdate(nil, auditRestore, resource.Resource, update)
+}
+
+// writeTarEntry writes a file entry with the given content to a tarball
+func writeTarEntry(tw *tar.Writer, name string, size int64, modTime time.Time, content io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, content)
+	return err
+}
+
+// handleAdminSnapshot downloads a snapshot of all resources and their version history
+func (s *BraidMockServer) handleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="braid-mock-snapshot.tar.gz"`)
+	if err := s.WriteSnapshot(w); err != nil {
+		// Part of the tarball may have been sent already, so the error can only be logged
+		logf(requestID(r), "Error writing snapshot: %v", err)
+	}
+}
+
+// handleAdminRestore restores the resources and version history of an uploaded snapshot
+func (s *BraidMockServer) handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	err := s.restoreSnapshot(r.Body, func(resourceID string) bool {
+		return s.checkWriteAccess(w, r, auditAdmin, resourceID)
+	})
+	if errors.Is(err, errRestoreRefused) {
+		// The access check has responded already
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Snapshot restored")
+	w.WriteHeader(http.StatusNoContent)
+}
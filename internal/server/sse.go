@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// updateEncoder writes updates to a subscriber in its wire format
+type updateEncoder interface {
+	Encode(update braidproto.Update) error
+}
+
+// acceptsEventStream reports whether a request asks for Server-Sent Events,
+// as browsers' EventSource does
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// sseEncoder writes updates as Server-Sent Events carrying the update as JSON
+type sseEncoder struct {
+	w io.Writer
+}
+
+// newSSEEncoder creates a new sseEncoder writing to w
+func newSSEEncoder(w io.Writer) *sseEncoder {
+	return &sseEncoder{w: w}
+}
+
+// Encode writes a single update as an "update" event
+func (e *sseEncoder) Encode(update braidproto.Update) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: update\n")
+	if len(update.Version) > 0 {
+		fmt.Fprintf(&buf, "id: %s\n", braidproto.FormatVersions(update.Version))
+	}
+	fmt.Fprintf(&buf, "data: %s\n\n", data)
+
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// EncodeWarning writes a message as a "warning" event, with a data line per
+// line of the message so line breaks in it can't end the event early
+func (e *sseEncoder) EncodeWarning(message string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: warning\n")
+	message = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(message)
+	for _, line := range strings.Split(message, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
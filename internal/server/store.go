@@ -0,0 +1,64 @@
+package server
+
+import (
+	"io"
+	"time"
+)
+
+// ResourceInfo describes a stored resource
+type ResourceInfo struct {
+	ID      string    // ID the store knows the resource by, which may differ from the requested one, e.g. in case
+	Type    string    // Extension of a typed resource such as "txt" or "png", empty for JSON resources
+	Size    int64     // Size of the content in bytes
+	ModTime time.Time // When the content last changed, zero for stores that don't know
+}
+
+// ResourceStore holds the content of mock resources, so resources can be
+// served from backends other than the file system
+type ResourceStore interface {
+	// Stat returns information about a resource, or an error if it doesn't exist
+	Stat(resourceID string) (ResourceInfo, error)
+
+	// Exists reports whether a resource exists
+	Exists(resourceID string) bool
+
+	// Read returns the content of a resource
+	Read(resourceID string) ([]byte, error)
+
+	// Open returns a reader for the content of a resource, for streaming
+	// resources too large to read at once
+	Open(resourceID string) (io.ReadCloser, error)
+
+	// ReadMeta returns the content of a resource's sidecar settings
+	ReadMeta(resourceID string) ([]byte, error)
+
+	// Write replaces the content of a resource. Stores report the change
+	// through Watch.
+	Write(resourceID string, data []byte) error
+
+	// List returns the IDs of all resources under a path prefix ending in a slash
+	List(prefix string) ([]string, error)
+
+	// Watch starts calling onChange with the new content of resources
+	// whenever they change, until the store is closed
+	Watch(onChange func(resourceID string, data []byte)) error
+
+	// Close stops watching for changes and releases the store's resources
+	Close() error
+}
+
+// metaWatcher is implemented by stores that report changes to the sidecar
+// settings files of resources
+type metaWatcher interface {
+	// WatchMeta calls onChange with the resources whose sidecar files were
+	// changed or deleted, once Watch has started
+	WatchMeta(onChange func(resourceID string))
+}
+
+// deletionWatcher is implemented by stores whose resources can be deleted
+// outside the server, such as objects deleted from a bucket
+type deletionWatcher interface {
+	// WatchDeletions calls onDelete with the resources that were deleted,
+	// once Watch has started
+	WatchDeletions(onDelete func(resourceID string))
+}
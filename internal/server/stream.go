@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/utils"
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// warningTimeout bounds how long a subscriber being disconnected for falling
+// behind gets to receive the warning telling it why
+const warningTimeout = time.Second
+
+// queueWaitTimeout bounds how long a change notification waits for room in
+// a full queue before disconnecting its subscriber, so that one stalled
+// client can't hold up notifications to everyone else
+const queueWaitTimeout = time.Second
+
+var (
+	// errStreamClosed is returned when writing to a subscription whose
+	// request has already finished
+	errStreamClosed = errors.New("subscription stream closed")
+
+	// errSlowSubscriber is returned when a subscription is disconnected for
+	// not keeping up with its updates
+	errSlowSubscriber = errors.New("subscriber too slow, disconnecting")
+)
+
+// warningEncoder is implemented by encoders that can tell a subscriber why
+// its stream is being closed
+type warningEncoder interface {
+	EncodeWarning(message string) error
+}
+
+// latestEncoder is implemented by encoders that may replace queued updates
+// with the latest state of their resource instead of queueing another one
+type latestEncoder interface {
+	EncodeLatest(update braidproto.Update, latest func() braidproto.Update) error
+}
+
+// streamFrame is an update queued for a subscriber, or a warning closing its stream
+type streamFrame struct {
+	Update  braidproto.Update
+	Warning string
+}
+
+// subscriberStream owns the response of a subscription. Updates are queued
+// and written one frame at a time by a single goroutine, so that change
+// notifications neither interleave with each other or the initial state nor
+// wait for slow clients until the queue is full, when the configured
+// backpressure policy applies. A failed write closes the stream, telling its
+// handler that the client is gone.
+type subscriberStream struct {
+	w           http.ResponseWriter
+	encoder     updateEncoder
+	flusher     http.Flusher
+	policy      string
+	capacity    int
+	queue       []streamFrame
+	started     bool
+	closed      bool
+	draining    bool
+	failed      chan struct{}
+	written     chan struct{}
+	stopped     chan struct{}
+	mu          sync.Mutex
+	queueChange *sync.Cond
+}
+
+// newSubscriberStream creates a stream writing updates to w with the given
+// encoder, queueing them as the backpressure settings say
+func newSubscriberStream(w http.ResponseWriter, encoder updateEncoder, flusher http.Flusher, backpressure config.BackpressureConfig) *subscriberStream {
+	st := &subscriberStream{
+		w:        w,
+		encoder:  encoder,
+		flusher:  flusher,
+		policy:   backpressure.Policy,
+		capacity: max(backpressure.BufferSize, 1),
+		failed:   make(chan struct{}),
+		written:  make(chan struct{}, 1),
+		stopped:  make(chan struct{}),
+	}
+	st.queueChange = sync.NewCond(&st.mu)
+	return st
+}
+
+// Start writes the initial state of the subscription with the given
+// function, then starts delivering queued updates, which are therefore
+// always written after it
+func (st *subscriberStream) Start(write func(encoder updateEncoder)) {
+	st.mu.Lock()
+	if st.closed || st.started {
+		st.mu.Unlock()
+		return
+	}
+	st.started = true
+	st.mu.Unlock()
+
+	// Changes notified while the initial state is written are queued, not
+	// kept waiting for it
+	write(st.encoder)
+	err := flushResponse(st.flusher)
+	go st.run()
+
+	if err != nil {
+		st.mu.Lock()
+		st.fail()
+		st.mu.Unlock()
+		return
+	}
+	st.wrote()
+}
+
+// Encode queues a single update
+func (st *subscriberStream) Encode(update braidproto.Update) error {
+	return st.enqueue(update, nil)
+}
+
+// EncodeLatest queues a single update, or if the queue is full and the
+// policy is to coalesce, replaces the queued updates to its resource with
+// its latest state
+func (st *subscriberStream) EncodeLatest(update braidproto.Update, latest func() braidproto.Update) error {
+	return st.enqueue(update, latest)
+}
+
+// Flush does nothing, since queued updates are flushed once written
+func (st *subscriberStream) Flush() {}
+
+// FlushError reports whether the stream can still be written to
+func (st *subscriberStream) FlushError() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.closed || st.draining {
+		return errStreamClosed
+	}
+	return nil
+}
+
+// Failed returns a channel that is closed once a write to the stream fails
+// or the subscriber is disconnected
+func (st *subscriberStream) Failed() <-chan struct{} {
+	return st.failed
+}
+
+// Written returns a channel that receives a value after updates are written
+func (st *subscriberStream) Written() <-chan struct{} {
+	return st.written
+}
+
+// Disconnect discards any queued updates and closes the stream after
+// writing a warning with the given message
+func (st *subscriberStream) Disconnect(message string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.closed && !st.draining {
+		st.disconnect(message)
+	}
+}
+
+// Close stops any further writes, once the subscription's request is done,
+// and waits for the write in progress to finish
+func (st *subscriberStream) Close() {
+	st.mu.Lock()
+	st.closed = true
+	started := st.started
+	st.queueChange.Broadcast()
+	st.mu.Unlock()
+
+	if !started {
+		return
+	}
+	controller := http.NewResponseController(st.w)
+	select {
+	case <-st.stopped:
+	default:
+		// Don't wait for a write blocked on a client that stopped reading
+		controller.SetWriteDeadline(time.Now())
+		<-st.stopped
+	}
+
+	// Let the response be finished normally if the client is still there
+	controller.SetWriteDeadline(time.Time{})
+}
+
+// enqueue adds an update to the queue, applying the backpressure policy
+// while it is full. Waiting for room is bounded by queueWaitTimeout, after
+// which the subscriber is disconnected.
+func (st *subscriberStream) enqueue(update braidproto.Update, latest func() braidproto.Update) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var timeout *time.Timer
+	expired := false
+	for !st.closed && !st.draining && len(st.queue) >= st.capacity {
+		switch st.policy {
+		case config.BackpressureCoalesce:
+			if latest != nil && st.coalesce(update.URL, latest()) {
+				st.queueChange.Broadcast()
+				return nil
+			}
+		case config.BackpressureDisconnect:
+			expired = true
+		}
+
+		if expired {
+			st.disconnect(fmt.Sprintf("Subscriber fell behind by more than %d updates", st.capacity))
+			return errSlowSubscriber
+		}
+		if timeout == nil {
+			timeout = time.AfterFunc(queueWaitTimeout, func() {
+				st.mu.Lock()
+				defer st.mu.Unlock()
+				expired = true
+				st.queueChange.Broadcast()
+			})
+			defer timeout.Stop()
+		}
+		st.queueChange.Wait()
+	}
+	if st.closed || st.draining {
+		return errStreamClosed
+	}
+
+	st.queue = append(st.queue, streamFrame{Update: update})
+	st.queueChange.Broadcast()
+	return nil
+}
+
+// coalesce replaces the queued updates to a resource with its latest state,
+// based on the version the subscriber had before them, and reports whether
+// there were any. The caller must hold st.mu.
+func (st *subscriberStream) coalesce(url string, latest braidproto.Update) bool {
+	queue := st.queue[:0:0]
+	var parents []string
+	replaced := false
+	for _, frame := range st.queue {
+		if frame.Warning == "" && frame.Update.URL == url {
+			if !replaced {
+				parents = frame.Update.Parents
+				replaced = true
+			}
+			continue
+		}
+		queue = append(queue, frame)
+	}
+	if !replaced {
+		return false
+	}
+
+	latest.Parents = parents
+	st.queue = append(queue, streamFrame{Update: latest})
+	return true
+}
+
+// disconnect discards the queued updates in favor of a warning, after which
+// the stream is closed. The caller must hold st.mu.
+func (st *subscriberStream) disconnect(message string) {
+	st.draining = true
+	st.queue = []streamFrame{{Warning: message}}
+	st.queueChange.Broadcast()
+
+	// Give up on the warning too if the client doesn't read it in time
+	http.NewResponseController(st.w).SetWriteDeadline(time.Now().Add(warningTimeout))
+	if !st.started {
+		st.fail()
+	}
+}
+
+// run writes queued frames until the stream is closed or a write fails,
+// flushing whenever the queue runs empty
+func (st *subscriberStream) run() {
+	defer close(st.stopped)
+
+	for {
+		st.mu.Lock()
+		for len(st.queue) == 0 && !st.closed {
+			st.queueChange.Wait()
+		}
+		if st.closed {
+			st.mu.Unlock()
+			return
+		}
+		frame := st.queue[0]
+		st.queue = st.queue[1:]
+		idle := len(st.queue) == 0
+		st.queueChange.Broadcast()
+		st.mu.Unlock()
+
+		var err error
+		if frame.Warning != "" {
+			if encoder, ok := st.encoder.(warningEncoder); ok {
+				err = encoder.EncodeWarning(frame.Warning)
+			}
+		} else {
+			err = st.encoder.Encode(frame.Update)
+		}
+		if err == nil && (idle || frame.Warning != "") {
+			err = flushResponse(st.flusher)
+		}
+
+		if err != nil || frame.Warning != "" {
+			st.mu.Lock()
+			st.fail()
+			st.mu.Unlock()
+			return
+		}
+		st.wrote()
+	}
+}
+
+// wrote signals that updates were written, without waiting for the signal
+// to be received
+func (st *subscriberStream) wrote() {
+	select {
+	case st.written <- struct{}{}:
+	default:
+	}
+}
+
+// fail closes the stream after a write error or disconnect. The caller must
+// hold st.mu.
+func (st *subscriberStream) fail() {
+	if !st.closed {
+		st.closed = true
+		close(st.failed)
+		st.queueChange.Broadcast()
+	}
+}
+
+// holdStream keeps a subscription's stream open until the client
+// disconnects or can no longer be written to, or the stream reaches its
+// maximum duration or idle timeout, when the client is advised to reconnect.
+// The stream ends with ctx, its log lines naming the request and path it is for.
+func (s *BraidMockServer) holdStream(ctx context.Context, id, path string, stream *subscriberStream) {
+	var expired, idle <-chan time.Time
+	if seconds := s.config.Braid.Subscriptions.MaxDuration; seconds > 0 {
+		timer := s.clock.NewTimer(time.Duration(seconds) * time.Second)
+		defer timer.Stop()
+		expired = timer.C()
+	}
+
+	var idleTimer utils.Timer
+	idleTimeout := time.Duration(s.config.Braid.Subscriptions.IdleTimeout) * time.Second
+	if idleTimeout > 0 {
+		idleTimer = s.clock.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stream.Failed():
+			return
+		case <-stream.Written():
+			if idleTimer != nil {
+				idleTimer.Reset(idleTimeout)
+			}
+		case <-expired:
+			logf(id, "Subscription to %s reached its maximum duration", path)
+			stream.Disconnect("Subscription reached its maximum duration, please reconnect")
+			expired, idle = nil, nil
+		case <-idle:
+			logf(id, "Subscription to %s timed out after no updates", path)
+			stream.Disconnect("Subscription timed out without updates, please reconnect")
+			expired, idle = nil, nil
+		}
+	}
+}
+
+// encodeUpdate writes an update with an encoder, letting it replace queued
+// updates with the latest state of the resource if it supports that
+func encodeUpdate(encoder updateEncoder, update braidproto.Update, latest func() braidproto.Update) error {
+	if encoder, ok := encoder.(latestEncoder); ok {
+		return encoder.EncodeLatest(update, latest)
+	}
+	return encoder.Encode(update)
+}
+
+// flushResponse flushes a response, returning the write error if the
+// response reports it
+func flushResponse(f http.Flusher) error {
+	if f, ok := f.(interface{ FlushError() error }); ok {
+		return f.FlushError()
+	}
+	f.Flush()
+	return nil
+}
+
+// flushingWriter flushes a subscription's response as configured instead of
+// whenever its stream asks to, which is once its queue runs empty
+type flushingWriter struct {
+	http.ResponseWriter
+	flush    config.FlushConfig
+	clock    utils.Clock
+	buffered int // Bytes written since the last flush
+}
+
+// Write writes a frame, flushing it right away in frame mode
+func (fw *flushingWriter) Write(data []byte) (int, error) {
+	n, err := fw.ResponseWriter.Write(data)
+	fw.buffered += n
+	if err == nil && fw.flush.Mode == config.FlushFrame {
+		err = fw.flushNow()
+	}
+	return n, err
+}
+
+// Flush flushes the response as the configured mode allows
+func (fw *flushingWriter) Flush() {
+	fw.FlushError()
+}
+
+// FlushError flushes the response as the configured mode allows, returning
+// the write error if there is one
+func (fw *flushingWriter) FlushError() error {
+	if fw.buffered == 0 {
+		return nil
+	}
+	switch fw.flush.Mode {
+	case config.FlushBytes:
+		if fw.buffered < fw.flush.Bytes {
+			return nil
+		}
+	case config.FlushDelay:
+		fw.clock.Sleep(time.Duration(fw.flush.Delay) * time.Millisecond)
+	}
+	return fw.flushNow()
+}
+
+func (fw *flushingWriter) flushNow() error {
+	fw.buffered = 0
+	return http.NewResponseController(fw.ResponseWriter).Flush()
+}
+
+// Unwrap lets response controllers reach the underlying response writer
+func (fw *flushingWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
@@ -1,159 +1,297 @@
 package server
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 
 	"gihan9a/braidmock/internal/utils"
-
-	"github.com/wI2L/jsondiff"
+	"gihan9a/braidmock/pkg/braidproto"
 )
 
-// AddSubscription adds a new subscription for a resource
-func (s *BraidMockServer) AddSubscription(resourceID string, w http.ResponseWriter, f http.Flusher, initialResource []byte) string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// AddSubscription adds a new subscription in a session for a resource at the
+// given version, scoped to the given JSON Pointer, or to the whole resource
+// if it is empty, and receiving patches in the given format, or the
+// resource's if it is empty
+func (s *BraidMockServer) AddSubscription(requestID, session, resourceID, pointer, patchFormat, version string, w http.ResponseWriter, f http.Flusher, encoder updateEncoder, initialResource []byte) string {
+	subID := s.ids.NewID()
+	hash := s.hasher.Hash(initialResource)
 
-	subID := utils.GenerateRandomID()
-	hash := utils.CalculateHash(initialResource)
+	s.registerSubscription(Subscription{
+		ID:          subID,
+		RequestID:   requestID,
+		Session:     session,
+		Resource:    resourceID,
+		Pointer:     pointer,
+		PatchFormat: patchFormat,
+		W:           w,
+		F:           f,
+		Encoder:     encoder,
+		LastHash:    hash,
+		LastVersion: []string{version},
+	}, initialResource)
 
-	if _, exists := s.subscriptions[resourceID]; !exists {
-		s.subscriptions[resourceID] = make(map[string]Subscription)
+	logf(requestID, "Added subscription %s for resource %s%s", subID, resourceID, pointer)
+	return subID
+}
+
+// registerSubscription stores a subscription under its resource, along with
+// the state it was last sent
+func (s *BraidMockServer) registerSubscription(sub Subscription, data []byte) {
+	s.acquireState(sub, data)
+	if old, replaced := s.subscriptions.add(sub); replaced {
+		s.releaseState(old)
 	}
+}
 
-	s.subscriptions[resourceID][subID] = Subscription{
-		ID:           subID,
-		W:            w,
-		F:            f,
-		LastResource: initialResource,
-		LastHash:     hash,
+// retainState points a subscription at the last state it was sent
+func (s *BraidMockServer) retainState(sub *Subscription, hash string, data []byte) {
+	s.releaseState(*sub)
+	sub.LastHash = hash
+	s.acquireState(*sub, data)
+}
+
+// acquireState keeps the content of the last state a subscription was sent
+// in the shared version store, unless the resource is too large to be diffed
+func (s *BraidMockServer) acquireState(sub Subscription, data []byte) {
+	if sub.LastHash == "" {
+		return
+	}
+	if s.isLargeResource(sub.Resource) {
+		data = nil
 	}
+	s.states.acquire(sub.Resource, sub.LastHash, data)
+}
 
-	log.Printf("Added subscription %s for resource %s", subID, resourceID)
-	return subID
+// releaseState drops a subscription's reference to the last state it was sent
+func (s *BraidMockServer) releaseState(sub Subscription) {
+	if sub.LastHash != "" {
+		s.states.release(sub.Resource, sub.LastHash)
+	}
 }
 
 // RemoveSubscription removes a subscription
 func (s *BraidMockServer) RemoveSubscription(resourceID, subID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if subs, exists := s.subscriptions[resourceID]; exists {
-		delete(subs, subID)
-		log.Printf("Removed subscription %s for resource %s", subID, resourceID)
+	if sub, exists := s.subscriptions.remove(resourceID, subID); exists {
+		s.releaseState(sub)
+		logf(sub.RequestID, "Removed subscription %s for resource %s", subID, resourceID)
+	}
+}
 
-		// Clean up empty subscription maps
-		if len(subs) == 0 {
-			delete(s.subscriptions, resourceID)
-		}
+// dropSubscription removes a subscription that could not be written to,
+// rather than waiting for its request to be cancelled
+func (s *BraidMockServer) dropSubscription(sub Subscription, err error) {
+	logf(sub.RequestID, "Error sending update to subscription %s for resource %s: %v, dropping it", sub.ID, sub.Resource, err)
+	if sub.Wildcard {
+		s.dropWildcardSubscription(sub.ID)
+		return
 	}
+	s.RemoveSubscription(sub.Resource, sub.ID)
 }
 
-// notifySubscribers sends an update to all subscribers of a resource
+// notifySubscribers sends an update to all subscribers of a resource,
+// except those in sessions that have written their own copy of it
 func (s *BraidMockServer) notifySubscribers(resourceID string, newData []byte) {
-	s.mu.RLock()
-	subs := s.subscriptions[resourceID]
-	s.mu.RUnlock()
+	// Wildcard streams pick up resources they haven't seen yet
+	s.attachWildcardSubscriptions(resourceID)
 
+	s.notifyMatching(resourceID, newData, func(sub Subscription) bool {
+		return !s.sessions.has(sub.Session, resourceID)
+	})
+}
+
+// notifySession sends an update to the subscribers of a resource in a session
+func (s *BraidMockServer) notifySession(session, resourceID string, newData []byte) {
+	s.attachWildcardSubscriptions(resourceID)
+
+	s.notifyMatching(resourceID, newData, func(sub Subscription) bool {
+		return sub.Session == session
+	})
+}
+
+// notifyMatching sends an update to the subscribers of a resource matching a filter
+func (s *BraidMockServer) notifyMatching(resourceID string, newData []byte, match func(Subscription) bool) {
+	var subs []Subscription
+	for _, sub := range s.subscriptions.list(resourceID) {
+		if match(sub) {
+			subs = append(subs, sub)
+		}
+	}
 	if len(subs) == 0 {
 		return
 	}
 
-	newHash := utils.CalculateHash(newData)
+	newHash := s.hasher.Hash(newData)
+	meta := s.resourceMeta(resourceID)
 	log.Printf("Notifying %d subscribers for resource %s", len(subs), resourceID)
 
 	// Process each subscription
-	for subID, sub := range subs {
-		if sub.LastHash == newHash {
-			log.Printf("Resource %s unchanged for subscription %s, skipping update", resourceID, subID)
-			continue
+	for _, sub := range subs {
+		s.notifySubscription(sub, meta, newData, newHash)
+	}
+}
+
+// notifySubscription sends the new state of a resource to one of its
+// subscribers, as a patch from the state it was last sent where possible
+func (s *BraidMockServer) notifySubscription(sub Subscription, meta resourceMeta, newData []byte, newHash string) {
+	// Scoped subscriptions only see their part of the resource
+	view, viewHash := newData, newHash
+	if sub.Pointer != "" {
+		view = resourceView(newData, sub.Pointer)
+		viewHash = s.hasher.Hash(view)
+	}
+
+	if sub.LastHash == viewHash {
+		logf(sub.RequestID, "Resource %s unchanged for subscription %s, skipping update", sub.Resource, sub.ID)
+		return
+	}
+
+	// Create and send update
+	var err error
+	if sub.LastHash == "" || !s.sendsPatches(sub.Resource, meta) {
+		// First update, or one that can't be patched - send full resource
+		err = s.sendFullUpdate(sub, meta, view, newHash)
+	} else {
+		// Subsequent update - send patch if possible
+		if err = s.sendPatchUpdate(sub, meta, view, newHash); err != nil {
+			logf(sub.RequestID, "Error sending patch update to subscription %s: %v, falling back to full update", sub.ID, err)
+			err = s.sendFullUpdate(sub, meta, view, newHash)
 		}
+	}
+	if err != nil {
+		s.dropSubscription(sub, err)
+		return
+	}
 
-		// Create and send update
-		if len(sub.LastResource) == 0 {
-			// First update - send full resource
-			s.sendFullUpdate(sub, newData, newHash)
-		} else {
-			// Subsequent update - send patch if possible
-			err := s.sendPatchUpdate(sub, newData, newHash)
-			if err != nil {
-				log.Printf("Error sending patch update: %v, falling back to full update", err)
-				s.sendFullUpdate(sub, newData, newHash)
-			}
+	// Point this subscription at the state it was just sent
+	s.subscriptions.update(sub.Resource, sub.ID, func(subscription *Subscription) {
+		s.retainState(subscription, viewHash, view)
+		subscription.LastVersion = []string{newHash}
+	})
+}
+
+// renotifySubscribers resends the full state of a resource to all its
+// subscribers, even if they are already up to date, and returns how many there were
+func (s *BraidMockServer) renotifySubscribers(resourceID string, data []byte) int {
+	count := s.subscriptions.updateAll(resourceID, func(sub *Subscription) {
+		// Forgetting the last state makes the next notification a full update
+		s.retainState(sub, "", nil)
+	})
+
+	s.notifySubscribers(resourceID, data)
+	return count
+}
+
+// resourceView returns the part of a resource a JSON Pointer refers to, or
+// null if the pointer no longer resolves
+func resourceView(data []byte, pointer string) []byte {
+	view, err := utils.ResolvePointer(data, pointer)
+	if err != nil {
+		return []byte("null")
+	}
+	return view
+}
+
+// broadcastUpdate sends a prebuilt update, such as a merge with several
+// parents, to all subscribers of a resource and returns how many received it
+func (s *BraidMockServer) broadcastUpdate(resourceID string, update braidproto.Update) int {
+	subs := s.subscriptions.list(resourceID)
+
+	log.Printf("Broadcasting update %v to %d subscribers for resource %s", update.Version, len(subs), resourceID)
+	s.recordVersions(resourceID, update.Version...)
+
+	sent := 0
+	for _, sub := range subs {
+		// Wildcard streams need to know which resource the update is for
+		frame := update
+		if sub.Wildcard {
+			frame.URL = resourceID
 		}
 
-		// Update the last resource and hash for this subscription
-		s.mu.Lock()
-		if subscriptions, exists := s.subscriptions[resourceID]; exists {
-			if subscription, exists := subscriptions[subID]; exists {
-				subscription.LastResource = make([]byte, len(newData))
-				copy(subscription.LastResource, newData)
-				subscription.LastHash = newHash
-				subscriptions[subID] = subscription
-			}
+		err := sub.Encoder.Encode(frame)
+		if err == nil {
+			err = flushResponse(sub.F)
 		}
-		s.mu.Unlock()
+		if err != nil {
+			s.dropSubscription(sub, err)
+			continue
+		}
+		sent++
+
+		// Later updates build on the broadcast versions, and on its body if it had one
+		s.subscriptions.update(resourceID, sub.ID, func(subscription *Subscription) {
+			subscription.LastVersion = update.Version
+			if len(update.Patches) == 0 {
+				body := []byte(update.Body)
+				s.retainState(subscription, s.hasher.Hash(body), body)
+			}
+		})
 	}
+
+	return sent
 }
 
 // sendFullUpdate sends a full resource update to a subscriber
-func (s *BraidMockServer) sendFullUpdate(sub Subscription, data []byte, hash string) error {
-	// Write headers
-	fmt.Fprintf(sub.W, "Version: %s\r\n", hash)
-	fmt.Fprintf(sub.W, "Parents: \r\n")
-	fmt.Fprintf(sub.W, "Content-Length: %d\r\n", len(data))
-	fmt.Fprintf(sub.W, "\r\n")
-
-	// Write body
-	if _, err := sub.W.Write(data); err != nil {
-		return err
+func (s *BraidMockServer) sendFullUpdate(sub Subscription, meta resourceMeta, data []byte, hash string) error {
+	update := braidproto.Update{
+		Version:   []string{hash},
+		MergeType: meta.MergeType,
+		Body:      string(data),
+		Sequence:  s.sequences.current(sub.Resource),
+	}
+	if sub.LastHash != "" {
+		// Snapshots replacing a known state build on its version
+		update.Parents = sub.LastVersion
+	}
+	if sub.Wildcard {
+		update.URL = sub.Resource
 	}
 
-	// Add separator for subscription stream
-	fmt.Fprintf(sub.W, "\r\n\r\n\r\n\r\n\r\n")
-	sub.F.Flush()
-	return nil
+	latest := func() braidproto.Update { return update }
+	if err := encodeUpdate(sub.Encoder, update, latest); err != nil {
+		return err
+	}
+	return flushResponse(sub.F)
 }
 
 // sendPatchUpdate sends a patch update to a subscriber
-func (s *BraidMockServer) sendPatchUpdate(sub Subscription, newData []byte, newHash string) error {
+func (s *BraidMockServer) sendPatchUpdate(sub Subscription, meta resourceMeta, newData []byte, newHash string) error {
 	// Calculate patch
-	patchOperations, err := jsondiff.CompareJSON(sub.LastResource, newData)
+	format := meta.PatchFormat
+	if sub.PatchFormat != "" {
+		format = sub.PatchFormat
+	}
+	patches, err := s.diffResource(sub.Resource, format, s.states.get(sub.Resource, sub.LastHash), newData)
 	if err != nil {
 		return err
 	}
 
-	if len(patchOperations) == 0 {
+	if len(patches) == 0 {
 		// No changes detected
 		return nil
 	}
 
-	// Write headers
-	fmt.Fprintf(sub.W, "Version: %s\r\n", newHash)
-	fmt.Fprintf(sub.W, "Parents: %s\r\n", sub.LastHash)
-
-	// Write patches header if more than one patch
-	if len(patchOperations) > 1 {
-		fmt.Fprintf(sub.W, "Patches: %d\r\n\r\n", len(patchOperations))
+	update := braidproto.Update{
+		Version:   []string{newHash},
+		Parents:   sub.LastVersion,
+		MergeType: meta.MergeType,
+		Patches:   patches,
+		Sequence:  s.sequences.current(sub.Resource),
+	}
+	if sub.Wildcard {
+		update.URL = sub.Resource
 	}
 
-	// Write each patch
-	for i, op := range patchOperations {
-		if i > 0 {
-			fmt.Fprintf(sub.W, "\r\n\r\n")
+	latest := func() braidproto.Update {
+		return braidproto.Update{
+			URL:       update.URL,
+			Version:   update.Version,
+			MergeType: update.MergeType,
+			Body:      string(newData),
+			Sequence:  update.Sequence,
 		}
-
-		valueJSON, _ := json.Marshal(op.Value)
-		fmt.Fprintf(sub.W, "Content-Length: %d\r\n", len(valueJSON))
-		fmt.Fprintf(sub.W, "Content-Range: %s %s\r\n", op.Type, op.Path)
-		fmt.Fprintf(sub.W, "\r\n")
-		fmt.Fprintf(sub.W, "%s", string(valueJSON))
 	}
-
-	// Add separator for subscription stream
-	fmt.Fprintf(sub.W, "\r\n\r\n\r\n\r\n\r\n")
-	sub.F.Flush()
-	return nil
+	if err := encodeUpdate(sub.Encoder, update, latest); err != nil {
+		return err
+	}
+	return flushResponse(sub.F)
 }
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+)
+
+// templateState holds the counters and per-client values templates keep
+// across renders
+type templateState struct {
+	mu             sync.Mutex
+	counters       map[string]int64
+	clientCounters map[string]map[string]int64
+	clientValues   map[string]map[string]interface{}
+	includers      map[string]map[string]bool // Resources whose templates include a resource, by the included resource
+}
+
+func newTemplateState() *templateState {
+	return &templateState{
+		counters:       make(map[string]int64),
+		clientCounters: make(map[string]map[string]int64),
+		clientValues:   make(map[string]map[string]interface{}),
+		includers:      make(map[string]map[string]bool),
+	}
+}
+
+// next increments a counter shared by all clients and returns its new value
+func (t *templateState) next(name string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counters[name]++
+	return t.counters[name]
+}
+
+// nextForClient increments a counter of a client and returns its new value
+func (t *templateState) nextForClient(client, name string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clientCounters[client] == nil {
+		t.clientCounters[client] = make(map[string]int64)
+	}
+	t.clientCounters[client][name]++
+	return t.clientCounters[client][name]
+}
+
+// get returns a value stored for a client, or an empty string if there is none
+func (t *templateState) get(client, key string) interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if value, ok := t.clientValues[client][key]; ok {
+		return value
+	}
+	return ""
+}
+
+// set stores a value for a client
+func (t *templateState) set(client, key string, value interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clientValues[client] == nil {
+		t.clientValues[client] = make(map[string]interface{})
+	}
+	t.clientValues[client][key] = value
+}
+
+// addInclude records that the template of a resource includes another one
+func (t *templateState) addInclude(includer, included string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.includers[included] == nil {
+		t.includers[included] = make(map[string]bool)
+	}
+	t.includers[included][includer] = true
+}
+
+// includersOf returns the resources whose templates have included a
+// resource. Includes are never forgotten, but re-rendering a template that
+// no longer includes the resource leaves its content unchanged.
+func (t *templateState) includersOf(included string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return sortedKeys(t.includers[included])
+}
+
+// templateClient returns the ID of the client making a request, from the
+// configured header or cookie. Requests without either, and renders for
+// change events, share the state of the anonymous client "".
+func (s *BraidMockServer) templateClient(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if client := r.Header.Get(s.config.Templates.ClientHeader); client != "" {
+		return client
+	}
+	if cookie, err := r.Cookie(s.config.Templates.ClientCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// renderTemplate renders the template of a resource for the client making a
+// request, or for no client when r is nil
+func (s *BraidMockServer) renderTemplate(r *http.Request, resourceID string, data []byte) ([]byte, error) {
+	return s.renderIncludedTemplate(r, resourceID, data, nil)
+}
+
+// renderIncludedTemplate renders the template of a resource included by the
+// templates of the given resources, outermost first
+func (s *BraidMockServer) renderIncludedTemplate(r *http.Request, resourceID string, data []byte, includers []string) ([]byte, error) {
+	tmpl, err := template.New(resourceID).Funcs(s.templateFuncs(r, resourceID, includers)).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, newTemplateData(r)); err != nil {
+		return nil, fmt.Errorf("error rendering template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// templateData is the request a template is rendered for, as the template's
+// dot, e.g. {{.Body.name}}
+type templateData struct {
+	Method  string
+	Path    string
+	Query   map[string]string // First value of each query parameter
+	Headers map[string]string // First value of each header, by canonical name
+	Body    interface{}       // Request body, decoded if it is JSON
+}
+
+// newTemplateData describes a request for templates. Renders for change
+// events have no request, and are given empty fields so templates using
+// them still render.
+func newTemplateData(r *http.Request) templateData {
+	data := templateData{
+		Query:   make(map[string]string),
+		Headers: make(map[string]string),
+		Body:    map[string]interface{}{},
+	}
+	if r == nil {
+		return data
+	}
+
+	data.Method = r.Method
+	data.Path = r.URL.Path
+	for name, values := range r.URL.Query() {
+		data.Query[name] = values[0]
+	}
+	for name, values := range r.Header {
+		data.Headers[name] = values[0]
+	}
+	if body := requestBody(r); body != nil {
+		data.Body = body
+	}
+	return data
+}
+
+// requestBody returns the body of a request, decoded if it is JSON, or nil
+// if it has none. The body is kept for reading again, since includes and
+// scripts rendering the same request need it too.
+func requestBody(r *http.Request) interface{} {
+	if r.Body == nil {
+		return nil
+	}
+	raw, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return string(raw)
+	}
+	return body
+}
+
+// templateFuncs returns the functions available to the template of a
+// resource rendered for a request. With a configured seed, the fake data
+// they generate is the same on every render, and differs between resources.
+func (s *BraidMockServer) templateFuncs(r *http.Request, resourceID string, includers []string) template.FuncMap {
+	var seed uint64
+	if s.config.Templates.Seed != 0 {
+		h := fnv.New64a()
+		h.Write([]byte(resourceID))
+		seed = s.config.Templates.Seed ^ h.Sum64()
+	}
+	faker := gofakeit.New(seed)
+	client := s.templateClient(r)
+
+	return template.FuncMap{
+		// People and companies
+		"name":      faker.Name,
+		"firstName": faker.FirstName,
+		"lastName":  faker.LastName,
+		"username":  faker.Username,
+		"email":     faker.Email,
+		"phone":     faker.Phone,
+		"company":   faker.Company,
+		"jobTitle":  faker.JobTitle,
+
+		// Addresses
+		"street":  faker.Street,
+		"city":    faker.City,
+		"state":   faker.State,
+		"zip":     faker.Zip,
+		"country": faker.Country,
+
+		// Text
+		"word":      faker.Word,
+		"lorem":     faker.LoremIpsumSentence,
+		"sentence":  func() string { return faker.Sentence() },
+		"paragraph": func() string { return faker.Paragraph() },
+
+		// Values
+		"uuid":  faker.UUID,
+		"url":   faker.URL,
+		"int":   faker.IntRange,
+		"float": faker.Float64Range,
+		"price": faker.Price,
+		"bool":  faker.Bool,
+		"date":  func() string { return faker.Date().UTC().Format(time.RFC3339) },
+
+		// State kept across requests
+		"counter":       s.templates.next,
+		"clientCounter": func(name string) int64 { return s.templates.nextForClient(client, name) },
+		"clientID":      func() string { return client },
+		"get":           func(key string) interface{} { return s.templates.get(client, key) },
+		"set": func(key string, value interface{}) string {
+			s.templates.set(client, key, value)
+			return ""
+		},
+
+		// Composing resources
+		"include": func(included string) (string, error) {
+			return s.includeResource(r, resourceID, included, includers)
+		},
+
+		// Helpers for generating JSON
+		"seq":  templateSeq,
+		"json": templateJSON,
+	}
+}
+
+// includeResource returns the content of a resource included by the
+// template of another, rendering it if it is a template itself. Paths not
+// starting with a slash are relative to the including resource.
+func (s *BraidMockServer) includeResource(r *http.Request, resourceID, included string, includers []string) (string, error) {
+	if !strings.HasPrefix(included, "/") {
+		included = path.Join(path.Dir(resourceID), included)
+	}
+	included = s.resolveResourceID(included)
+
+	includers = append(includers, resourceID)
+	if slices.Contains(includers, included) {
+		return "", fmt.Errorf("%s includes itself", included)
+	}
+	s.templates.addInclude(resourceID, included)
+
+	data, err := s.readStoredResource(r, included)
+	if err != nil {
+		return "", fmt.Errorf("error including %s: %w", included, err)
+	}
+	meta := s.resourceMeta(included)
+	if meta.Template {
+		if data, err = s.renderIncludedTemplate(r, included, data, includers); err != nil {
+			return "", err
+		}
+	}
+	if meta.Script != "" {
+		if data, err = s.runScript(r, included, meta.Script, data); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// templateSeq returns the numbers from 0 up to n, to repeat parts of a
+// template with range
+func templateSeq(n int) []int {
+	seq := make([]int, n)
+	for i := range seq {
+		seq[i] = i
+	}
+	return seq
+}
+
+// templateJSON encodes a value as JSON, e.g. to quote generated strings
+func templateJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
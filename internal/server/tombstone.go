@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// tombstoneState holds the resources soft-deleted in each session. Deleted
+// resources keep their mock files and are served as gone until written again.
+type tombstoneState struct {
+	mu      sync.RWMutex
+	deleted map[string]map[string]bool // Deleted resources, by session
+}
+
+func newTombstoneState() *tombstoneState {
+	return &tombstoneState{deleted: make(map[string]map[string]bool)}
+}
+
+// has reports whether a resource is deleted in a session
+func (t *tombstoneState) has(session, resourceID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.deleted[session][resourceID]
+}
+
+// add marks a resource as deleted in a session
+func (t *tombstoneState) add(session, resourceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.deleted[session] == nil {
+		t.deleted[session] = make(map[string]bool)
+	}
+	t.deleted[session][resourceID] = true
+}
+
+// remove brings a resource deleted in a session back
+func (t *tombstoneState) remove(session, resourceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.deleted[session], resourceID)
+	if len(t.deleted[session]) == 0 {
+		delete(t.deleted, session)
+	}
+}
+
+// reset brings back the resources deleted in a session, returning them
+func (t *tombstoneState) reset(session string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resourceIDs := sortedKeys(t.deleted[session])
+	delete(t.deleted, session)
+	return resourceIDs
+}
+
+// isDeleted reports whether a resource is deleted in the session of a
+// request, or for everyone but sessions with their own copy of it
+func (s *BraidMockServer) isDeleted(r *http.Request, resourceID string) bool {
+	session := s.requestSession(r)
+	if s.tombstones.has(session, resourceID) {
+		return true
+	}
+	return s.tombstones.has("", resourceID) && !s.sessions.has(session, resourceID)
+}
+
+// handleDelete soft-deletes a resource, in the session of the request if it
+// has one, and sends its deletion to the subscribers that saw it
+func (s *BraidMockServer) handleDelete(w http.ResponseWriter, r *http.Request, resourceID string) {
+	session := s.requestSession(r)
+	if _, hash, err := s.readResource(r, resourceID); err == nil {
+		s.auditUpdate(r, auditClient, resourceID, braidproto.Update{
+			Version: []string{s.hasher.Hash([]byte(s.config.Tombstones.Body))},
+			Parents: []string{hash},
+			Body:    s.config.Tombstones.Body,
+			Status:  http.StatusGone,
+		})
+	}
+	s.tombstones.add(session, resourceID)
+	logf(requestID(r), "Resource %s deleted", resourceID)
+	if session == "" {
+		s.shareChange(clusterMessage{Kind: clusterDelete, Resource: resourceID})
+	}
+
+	s.notifyDeleted(resourceID, func(sub Subscription) bool {
+		if session != "" {
+			return sub.Session == session
+		}
+		return !s.sessions.has(sub.Session, resourceID)
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleStoreDeletion marks a resource deleted from the store as deleted,
+// like a DELETE outside any session, and sends its deletion to subscribers
+func (s *BraidMockServer) handleStoreDeletion(resourceID string) {
+	log.Printf("Resource %s deleted from the store", resourceID)
+	s.invalidateResource(resourceID)
+	s.tombstones.add("", resourceID)
+	s.notifyDeleted(resourceID, func(sub Subscription) bool {
+		return !s.sessions.has(sub.Session, resourceID)
+	})
+}
+
+// writeGone responds to a request for a deleted resource with 410 Gone
+func (s *BraidMockServer) writeGone(w http.ResponseWriter) {
+	body := []byte(s.config.Tombstones.Body)
+	if json.Valid(body) {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusGone)
+	w.Write(body)
+}
+
+// notifyDeleted sends the subscribers of a resource matching a filter an
+// update with status 410 and the tombstone body. Their next update is a full
+// one, should the resource come back.
+func (s *BraidMockServer) notifyDeleted(resourceID string, match func(Subscription) bool) {
+	body := s.config.Tombstones.Body
+	version := s.hasher.Hash([]byte(body))
+	s.recordVersions(resourceID, version)
+
+	unlock := s.sequences.lock(resourceID)
+	defer unlock()
+	sequence := s.sequences.next(resourceID)
+	for _, sub := range s.subscriptions.list(resourceID) {
+		if !match(sub) {
+			continue
+		}
+		update := braidproto.Update{
+			Version:  []string{version},
+			Parents:  sub.LastVersion,
+			Body:     body,
+			Status:   http.StatusGone,
+			Sequence: sequence,
+		}
+		if sub.Wildcard {
+			update.URL = resourceID
+		}
+
+		err := sub.Encoder.Encode(update)
+		if err == nil {
+			err = flushResponse(sub.F)
+		}
+		if err != nil {
+			s.dropSubscription(sub, err)
+			continue
+		}
+		s.subscriptions.update(resourceID, sub.ID, func(subscription *Subscription) {
+			s.retainState(subscription, "", nil)
+			subscription.LastVersion = update.Version
+		})
+	}
+	log.Printf("Sent deletion of resource %s to subscribers", resourceID)
+}
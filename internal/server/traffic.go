@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gihan9a/braidmock/internal/har"
+)
+
+// harBodyLimit is the most of a request or response body recorded, so
+// long-running subscriptions don't grow the recording without bound
+const harBodyLimit = 1 << 20
+
+// trafficRecorder keeps the most recent exchanges with the server for
+// export as HAR
+type trafficRecorder struct {
+	mu      sync.Mutex
+	entries []har.Entry
+	max     int
+}
+
+// add records an exchange, dropping the oldest when the recording is full
+func (t *trafficRecorder) add(entry har.Entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, entry)
+	if len(t.entries) > t.max {
+		t.entries = t.entries[len(t.entries)-t.max:]
+	}
+}
+
+// archive returns the recorded exchanges as an HTTP Archive, in the order
+// they started
+func (t *trafficRecorder) archive() har.HAR {
+	t.mu.Lock()
+	entries := append([]har.Entry{}, t.entries...)
+	t.mu.Unlock()
+
+	// Exchanges are recorded when they end, so subscriptions come after
+	// requests made while they were open
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartedDateTime < entries[j].StartedDateTime })
+	return har.HAR{Log: har.Log{
+		Version: har.Version,
+		Creator: har.Creator{Name: "braid-mock", Version: "1.0"},
+		Entries: entries,
+	}}
+}
+
+// clear forgets all recorded exchanges
+func (t *trafficRecorder) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = nil
+}
+
+// recordingMiddleware records requests and their responses for export as
+// HAR. Admin requests and WebSocket connections aren't recorded.
+func (s *BraidMockServer) recordingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.traffic == nil || strings.HasPrefix(r.URL.Path, s.config.Admin.Prefix) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		started := s.clock.Now()
+		requestBody := &limitedBuffer{}
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, requestBody), r.Body}
+		}
+		rw := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+		s.traffic.add(s.harEntry(r, requestBody.data, rw, started))
+	})
+}
+
+// harEntry describes a recorded exchange in HAR
+func (s *BraidMockServer) harEntry(r *http.Request, requestBody []byte, rw *recordingResponseWriter, started time.Time) har.Entry {
+	elapsed := float64(s.clock.Now().Sub(started)) / float64(time.Millisecond)
+
+	u := *r.URL
+	u.Host = r.Host
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+
+	request := har.Request{
+		Method:      r.Method,
+		URL:         u.String(),
+		HTTPVersion: r.Proto,
+		Headers:     harHeaders(r.Header),
+		QueryString: []har.NameValue{},
+		Cookies:     []har.NameValue{},
+		HeadersSize: -1,
+		BodySize:    len(requestBody),
+	}
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			request.QueryString = append(request.QueryString, har.NameValue{Name: name, Value: value})
+		}
+	}
+	for _, cookie := range r.Cookies() {
+		request.Cookies = append(request.Cookies, har.NameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	if len(requestBody) > 0 {
+		request.PostData = &har.PostData{MimeType: r.Header.Get("Content-Type"), Text: string(requestBody)}
+	}
+
+	content := har.Content{Size: len(rw.body.data), MimeType: rw.Header().Get("Content-Type")}
+	if isTextMedia(content.MimeType) && utf8.Valid(rw.body.data) {
+		content.Text = string(rw.body.data)
+	} else if len(rw.body.data) > 0 {
+		content.Text = base64.StdEncoding.EncodeToString(rw.body.data)
+		content.Encoding = "base64"
+	}
+
+	return har.Entry{
+		StartedDateTime: started.UTC().Format(time.RFC3339Nano),
+		Time:            elapsed,
+		Request:         request,
+		Response: har.Response{
+			Status:      rw.status,
+			StatusText:  http.StatusText(rw.status),
+			HTTPVersion: r.Proto,
+			Headers:     harHeaders(rw.Header()),
+			Cookies:     []har.NameValue{},
+			Content:     content,
+			RedirectURL: rw.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    rw.body.written,
+		},
+		Timings: har.Timings{Wait: elapsed},
+	}
+}
+
+// harHeaders lists headers in HAR, sorted by name
+func harHeaders(header http.Header) []har.NameValue {
+	headers := []har.NameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, har.NameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+// isTextMedia reports whether bodies of a media type are text, which HAR
+// holds as is rather than base64 encoded
+func isTextMedia(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// limitedBuffer keeps the first harBodyLimit bytes written to it, while
+// counting all of them
+type limitedBuffer struct {
+	data    []byte
+	written int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.written += len(p)
+	if room := harBodyLimit - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// recordingResponseWriter records the status and body of a response as it
+// is written
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	body        limitedBuffer
+	wroteHeader bool
+}
+
+func (rw *recordingResponseWriter) WriteHeader(statusCode int) {
+	if !rw.wroteHeader {
+		rw.status = statusCode
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingResponseWriter) Write(data []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(data)
+	return rw.ResponseWriter.Write(data)
+}
+
+// Flush sends the response written so far, as subscriptions do with every update
+func (rw *recordingResponseWriter) Flush() {
+	rw.FlushError()
+}
+
+// FlushError flushes the response, returning the write error if there is one
+func (rw *recordingResponseWriter) FlushError() error {
+	return http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap lets response controllers reach the underlying response writer
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// handleAdminHAR downloads the recorded traffic as HAR
+func (s *BraidMockServer) handleAdminHAR(w http.ResponseWriter, r *http.Request) {
+	if s.traffic == nil {
+		http.Error(w, "Traffic recording is disabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="braid-mock.har"`)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.traffic.archive()); err != nil {
+		log.Printf("Error writing HAR: %v", err)
+	}
+}
+
+// handleAdminClearHAR forgets the recorded traffic
+func (s *BraidMockServer) handleAdminClearHAR(w http.ResponseWriter, r *http.Request) {
+	if s.traffic != nil {
+		s.traffic.clear()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
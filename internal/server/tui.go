@@ -0,0 +1,362 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"golang.org/x/term"
+)
+
+// tuiFeedSize is how many lines of the feed the terminal UI keeps
+const tuiFeedSize = 500
+
+// tuiRow is a resource listed by the terminal UI
+type tuiRow struct {
+	resource    string
+	version     string
+	subscribers int
+	size        int64
+}
+
+// TUI is a terminal UI listing the resources of a server with their live
+// subscriber counts, above a scrolling feed of the requests it handles, the
+// updates it sends and its log
+type TUI struct {
+	NopHook
+	server   *BraidMockServer
+	in, out  *os.File
+	terminal *term.State // State of the terminal before the UI started
+	mu       sync.Mutex
+	feed     []string
+	partial  []byte // Log output not ending in a newline yet
+	selected int
+	running  bool
+	paused   bool // Set while an editor has the terminal
+}
+
+// NewTUI creates a terminal UI for a server on the process's terminal. It
+// must be called before the server handles requests, so the feed sees them.
+func NewTUI(s *BraidMockServer) *TUI {
+	t := &TUI{server: s, in: os.Stdin, out: os.Stdout}
+	s.AddHook(t)
+	return t
+}
+
+// HookRequest adds a request to the feed
+func (t *TUI) HookRequest(w http.ResponseWriter, r *http.Request) bool {
+	method := r.Method
+	if r.Header.Get("Subscribe") != "" {
+		method = "SUBSCRIBE"
+	}
+	t.addEvent(fmt.Sprintf("%s %s from %s", method, r.URL.RequestURI(), r.RemoteAddr))
+	return true
+}
+
+// HookUpdate adds an update sent to a subscriber to the feed
+func (t *TUI) HookUpdate(r *http.Request, resourceID string, update braidproto.Update) braidproto.Update {
+	t.addEvent(fmt.Sprintf("Update %s of %s to %s", braidproto.FormatVersions(update.Version), resourceID, r.RemoteAddr))
+	return update
+}
+
+// Write adds log output to the feed, line by line
+func (t *TUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.addLineLocked(string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// addEvent adds a timestamped line to the feed, like the log's
+func (t *TUI) addEvent(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addLineLocked(t.server.clock.Now().Format("15:04:05") + " " + line)
+}
+
+func (t *TUI) addLineLocked(line string) {
+	t.feed = append(t.feed, line)
+	if len(t.feed) > tuiFeedSize {
+		t.feed = t.feed[len(t.feed)-tuiFeedSize:]
+	}
+}
+
+// Run shows the UI until it is quit with q or Ctrl-C, showing the log in the
+// feed meanwhile. It fails if standard input is not a terminal.
+func (t *TUI) Run() error {
+	fd := int(t.in.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("standard input is not a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to set up terminal: %w", err)
+	}
+	t.terminal = state
+	defer term.Restore(fd, state)
+
+	logOutput, logFlags := log.Writer(), log.Flags()
+	log.SetOutput(t)
+	log.SetFlags(log.Ltime)
+	defer func() {
+		log.SetOutput(logOutput)
+		log.SetFlags(logFlags)
+	}()
+
+	t.enterScreen()
+	defer t.leaveScreen()
+
+	done := make(chan struct{})
+	defer close(done)
+	go t.redraw(done)
+
+	key := make([]byte, 16)
+	for {
+		t.draw()
+		n, err := t.in.Read(key)
+		if err != nil {
+			return err
+		}
+		switch string(key[:n]) {
+		case "q", "\x03":
+			return nil
+		case "k", "\x1b[A":
+			t.move(-1)
+		case "j", "\x1b[B":
+			t.move(1)
+		case "r":
+			t.renotifySelected()
+		case "e":
+			t.editSelected(fd)
+		}
+	}
+}
+
+// enterScreen switches to the alternate screen with the cursor hidden
+func (t *TUI) enterScreen() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = true
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+}
+
+// leaveScreen stops drawing and switches back to the normal screen
+func (t *TUI) leaveScreen() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = false
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+}
+
+// redraw draws the UI every half second until done is closed, keeping the
+// subscriber counts and feed up to date
+func (t *TUI) redraw(done chan struct{}) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			t.draw()
+		}
+	}
+}
+
+// rows returns the resources to list, sorted by path
+func (t *TUI) rows() []tuiRow {
+	s := t.server
+	resourceIDs, err := s.store.List("/")
+	if err != nil {
+		return nil
+	}
+
+	rows := make([]tuiRow, 0, len(resourceIDs))
+	for _, resourceID := range resourceIDs {
+		row := tuiRow{resource: resourceID, version: "-", subscribers: s.subscriptions.count(resourceID)}
+		if info, err := s.store.Stat(resourceID); err == nil {
+			row.size = info.Size
+		}
+		s.mu.RLock()
+		if version, ok := s.versions[resourceID]; ok {
+			row.version = version
+		}
+		s.mu.RUnlock()
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// selectedResource returns the resource selected in the list, if any
+func (t *TUI) selectedResource() (string, bool) {
+	rows := t.rows()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(rows) == 0 {
+		return "", false
+	}
+	return rows[min(t.selected, len(rows)-1)].resource, true
+}
+
+// move moves the selection up or down the list
+func (t *TUI) move(delta int) {
+	count := len(t.rows())
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.selected = max(min(t.selected+delta, count-1), 0)
+}
+
+// renotifySelected resends the selected resource to its subscribers
+func (t *TUI) renotifySelected() {
+	resourceID, ok := t.selectedResource()
+	if !ok {
+		return
+	}
+	sent, err := t.server.renotify(resourceID)
+	if err != nil {
+		t.addEvent(fmt.Sprintf("Error re-notifying subscribers of %s: %v", resourceID, err))
+		return
+	}
+	t.addEvent(fmt.Sprintf("Re-notified %d subscribers of %s", sent, resourceID))
+}
+
+// editSelected opens the mock file of the selected resource in $EDITOR,
+// giving it the terminal until it exits. The watcher picks up the changes.
+func (t *TUI) editSelected(fd int) {
+	resourceID, ok := t.selectedResource()
+	if !ok {
+		return
+	}
+	store, ok := t.server.store.(diskStore)
+	var filePath string
+	if ok {
+		filePath, ok = store.mockFilePath(resourceID)
+	}
+	if !ok {
+		t.addEvent(fmt.Sprintf("No mock file on disk to edit for %s", resourceID))
+		return
+	}
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	t.mu.Lock()
+	t.paused = true
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+	t.mu.Unlock()
+
+	// Give the editor the terminal as it was before the UI started
+	term.Restore(fd, t.terminal)
+	cmd := exec.Command(editor[0], append(editor[1:], filePath)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = t.in, t.out, os.Stderr
+	runErr := cmd.Run()
+	term.MakeRaw(fd)
+
+	t.mu.Lock()
+	t.paused = false
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	t.mu.Unlock()
+
+	if runErr != nil {
+		t.addEvent(fmt.Sprintf("Error running %s: %v", editor[0], runErr))
+	}
+}
+
+// draw renders the resource list and the end of the feed to fit the terminal
+func (t *TUI) draw() {
+	rows := t.rows()
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.running || t.paused {
+		return
+	}
+	t.selected = max(min(t.selected, len(rows)-1), 0)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	line := func(text string) {
+		b.WriteString(truncateLine(text, width))
+		b.WriteString("\x1b[0m\r\n")
+	}
+
+	line(fmt.Sprintf("\x1b[1mbraid-mock\x1b[0m  %d resources  ↑/↓ select  r re-notify  e edit  q quit", len(rows)))
+	line(fmt.Sprintf("\x1b[4m  %-40s %-20s %11s %10s", "RESOURCE", "VERSION", "SUBSCRIBERS", "SIZE"))
+
+	// Scroll the list to keep the selection in view, giving half the screen
+	// to the feed
+	listHeight := max(min(len(rows), height/2-2), 1)
+	first := max(t.selected-listHeight+1, 0)
+	for i := first; i < len(rows) && i < first+listHeight; i++ {
+		row := rows[i]
+		text := fmt.Sprintf("  %-40s %-20s %11d %10d", row.resource, row.version, row.subscribers, row.size)
+		if i == t.selected {
+			text = "\x1b[7m>" + text[1:]
+		}
+		line(text)
+	}
+	for i := len(rows); i < listHeight; i++ {
+		line("")
+	}
+
+	line("")
+	line("\x1b[4mFeed")
+	feedHeight := max(height-listHeight-5, 0)
+	feed := t.feed[max(len(t.feed)-feedHeight, 0):]
+	for i, text := range feed {
+		if i == len(feed)-1 {
+			// Avoid scrolling the screen past the last line
+			b.WriteString(truncateLine(text, width))
+			break
+		}
+		line(text)
+	}
+	fmt.Fprint(t.out, b.String())
+}
+
+// truncateLine cuts text to a number of visible characters, skipping over
+// escape sequences
+func truncateLine(text string, width int) string {
+	visible := 0
+	for i := 0; i < len(text); {
+		if text[i] == '\x1b' {
+			// Escape sequences end with a letter
+			end := strings.IndexFunc(text[i+1:], func(r rune) bool { return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' })
+			if end < 0 {
+				return text
+			}
+			i += end + 2
+			continue
+		}
+		if visible == width {
+			return text[:i]
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		visible++
+	}
+	return text
+}
@@ -0,0 +1,70 @@
+package server
+
+import "sync"
+
+// storedVersion is the content of a resource at one version, shared by all
+// subscriptions whose last update left them at it
+type storedVersion struct {
+	Data []byte
+	Refs int
+}
+
+// versionStore keeps the resource states subscriptions were last sent once
+// per resource and content hash, so that subscribers at the same version
+// share a single copy to diff the next update against
+type versionStore struct {
+	versions map[string]map[string]*storedVersion
+	mu       sync.Mutex
+}
+
+// newVersionStore creates an empty version store
+func newVersionStore() *versionStore {
+	return &versionStore{versions: make(map[string]map[string]*storedVersion)}
+}
+
+// acquire adds a reference to the state of a resource with the given hash,
+// storing its content if no subscription references it yet
+func (v *versionStore) acquire(resourceID, hash string, data []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, exists := v.versions[resourceID]; !exists {
+		v.versions[resourceID] = make(map[string]*storedVersion)
+	}
+	if version, exists := v.versions[resourceID][hash]; exists {
+		version.Refs++
+		return
+	}
+	v.versions[resourceID][hash] = &storedVersion{Data: append([]byte(nil), data...), Refs: 1}
+}
+
+// release drops a reference to the state of a resource with the given hash,
+// forgetting its content once no subscription references it
+func (v *versionStore) release(resourceID, hash string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	version, exists := v.versions[resourceID][hash]
+	if !exists {
+		return
+	}
+	if version.Refs--; version.Refs > 0 {
+		return
+	}
+	delete(v.versions[resourceID], hash)
+	if len(v.versions[resourceID]) == 0 {
+		delete(v.versions, resourceID)
+	}
+}
+
+// get returns the stored content of a resource with the given hash, or nil
+// if it isn't stored
+func (v *versionStore) get(resourceID, hash string) []byte {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if version, exists := v.versions[resourceID][hash]; exists {
+		return version.Data
+	}
+	return nil
+}
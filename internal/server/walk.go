@@ -0,0 +1,69 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// walkFiles walks the file tree rooted at a directory like filepath.Walk, but
+// follows symlinks to files and directories. Each real directory is visited
+// once, so symlink loops terminate and directories linked from several
+// places are only reported under the first path found.
+func walkFiles(root string, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = walkPath(root, info, make(map[string]bool), fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkPath walks a single path for walkFiles, tracking visited real directories
+func walkPath(path string, info os.FileInfo, visited map[string]bool, fn filepath.WalkFunc) error {
+	// Follow symlinks, skipping broken ones
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		info = target
+	}
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	if visited[realPath] {
+		return nil
+	}
+	visited[realPath] = true
+
+	if err := fn(path, info, nil); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		childInfo, err := entry.Info()
+		if err != nil {
+			if err := fn(child, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := walkPath(child, childInfo, visited, fn); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
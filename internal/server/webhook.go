@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// changePayload describes a resource change sent to webhooks and message brokers
+type changePayload struct {
+	Resource   string             `json:"resource"`
+	OldVersion string             `json:"old_version"`
+	NewVersion string             `json:"new_version"`
+	Patches    []braidproto.Patch `json:"patches,omitempty"`
+	Body       string             `json:"body,omitempty"`
+	Timestamp  time.Time          `json:"timestamp"`
+}
+
+// encodeChange encodes the update between two versions of a resource as a JSON change payload
+func (s *BraidMockServer) encodeChange(resourceID string, update braidproto.Update) ([]byte, error) {
+	payload := changePayload{
+		Resource:  resourceID,
+		Patches:   update.Patches,
+		Body:      update.Body,
+		Timestamp: s.clock.Now(),
+	}
+	if len(update.Parents) > 0 {
+		payload.OldVersion = update.Parents[0]
+	}
+	if len(update.Version) > 0 {
+		payload.NewVersion = update.Version[0]
+	}
+
+	return json.Marshal(payload)
+}
+
+// sendWebhooks posts a resource change to all configured webhooks in the background
+func (s *BraidMockServer) sendWebhooks(resourceID string, update braidproto.Update) {
+	if len(s.config.Webhooks.URLs) == 0 {
+		return
+	}
+
+	body, err := s.encodeChange(resourceID, update)
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	for _, url := range s.config.Webhooks.URLs {
+		go s.deliverWebhook(url, body)
+	}
+}
+
+// deliverWebhook posts a payload to a webhook, retrying with exponential
+// backoff until it succeeds or the configured retries are exhausted
+func (s *BraidMockServer) deliverWebhook(url string, body []byte) {
+	client := &http.Client{Timeout: time.Duration(s.config.Webhooks.Timeout) * time.Second}
+	delay := time.Second
+
+	for attempt := 0; attempt <= s.config.Webhooks.Retries; attempt++ {
+		if attempt > 0 {
+			s.clock.Sleep(delay)
+			delay *= 2
+		}
+
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+
+		log.Printf("Webhook %s failed (attempt %d of %d): %v", url, attempt+1, s.config.Webhooks.Retries+1, err)
+	}
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gihan9a/braidmock/internal/utils"
+	"gihan9a/braidmock/pkg/braidproto"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket message types
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsUpdate      = "update"
+	wsError       = "error"
+)
+
+// wsMessage is a message exchanged over the WebSocket bridge
+type wsMessage struct {
+	Type     string             `json:"type"`               // subscribe, unsubscribe, update or error
+	Resource string             `json:"resource,omitempty"` // Resource the message refers to
+	Range    string             `json:"range,omitempty"`    // Optional JSON Pointer to scope a subscription to
+	Update   *braidproto.Update `json:"update,omitempty"`   // Update sent by the server
+	Error    string             `json:"error,omitempty"`    // Error sent by the server
+}
+
+// wsWriteTimeout bounds how long a message may take to write, since the
+// subscriptions sharing a connection can't each set its write deadline
+const wsWriteTimeout = 10 * time.Second
+
+// wsConn serializes writes to a WebSocket connection shared by several subscriptions
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+	err  error // First write error, after which the connection is unusable
+}
+
+// send writes a message to the connection
+func (c *wsConn) send(msg wsMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	c.err = c.conn.WriteJSON(msg)
+	return c.err
+}
+
+// Flush does nothing, since messages are written whole
+func (c *wsConn) Flush() {}
+
+// FlushError returns the write error of the connection, if any
+func (c *wsConn) FlushError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// wsEncoder writes the updates of one resource as WebSocket messages
+type wsEncoder struct {
+	conn     *wsConn
+	resource string
+}
+
+// Encode sends a single update message
+func (e *wsEncoder) Encode(update braidproto.Update) error {
+	return e.conn.send(wsMessage{Type: wsUpdate, Resource: e.resource, Update: &update})
+}
+
+// EncodeWarning sends the reason a subscription is being closed as an error message
+func (e *wsEncoder) EncodeWarning(message string) error {
+	return e.conn.send(wsMessage{Type: wsError, Resource: e.resource, Error: message})
+}
+
+// wsSubscription is a subscription of a WebSocket connection to a resource
+type wsSubscription struct {
+	id       string
+	resource string
+	stream   *subscriberStream
+	cancel   context.CancelFunc // Ends the subscription, e.g. when the client unsubscribes
+}
+
+// closeWebSocketSubscription removes a WebSocket subscription, stops writing
+// its updates and releases its place under the subscription limits
+func (s *BraidMockServer) closeWebSocketSubscription(sub *wsSubscription) {
+	s.RemoveSubscription(sub.resource, sub.id)
+	sub.stream.Close()
+	s.releaseStream(sub.resource, false)
+}
+
+// wsUpgrader upgrades bridge requests; any origin is accepted as this is a mock
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleWebSocket bridges Braid subscriptions to a WebSocket connection, with
+// clients sending subscribe and unsubscribe messages for individual resources
+func (s *BraidMockServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logf(requestID(r), "WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	conn := &wsConn{conn: ws}
+	connID := s.ids.NewID()
+	ctx, cancel := context.WithCancel(r.Context())
+
+	// Subscriptions by the resource names clients subscribed with, removed
+	// once they end so that clients can subscribe again
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	subscribed := make(map[string]*wsSubscription)
+
+	logf(requestID(r), "WebSocket connection %s opened", connID)
+
+	// End all subscriptions of this connection once it closes
+	defer func() {
+		cancel()
+		wg.Wait()
+		logf(requestID(r), "WebSocket connection %s closed", connID)
+	}()
+
+	for {
+		var msg wsMessage
+		if err := ws.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logf(requestID(r), "WebSocket read error on %s: %v", connID, err)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case wsSubscribe:
+			mu.Lock()
+			_, exists := subscribed[msg.Resource]
+			mu.Unlock()
+			if exists {
+				continue
+			}
+			sub, err := s.subscribeWebSocket(conn, r, connID, msg.Resource, msg.Range)
+			if err != nil {
+				conn.send(wsMessage{Type: wsError, Resource: msg.Resource, Error: err.Error()})
+				continue
+			}
+
+			var subCtx context.Context
+			subCtx, sub.cancel = context.WithCancel(ctx)
+			mu.Lock()
+			subscribed[msg.Resource] = sub
+			mu.Unlock()
+
+			// Hold the subscription like a subscription request's, until it
+			// is unsubscribed, fails or times out
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				s.holdStream(subCtx, requestID(r), name, sub.stream)
+				mu.Lock()
+				if subscribed[name] == sub {
+					delete(subscribed, name)
+				}
+				mu.Unlock()
+				s.closeWebSocketSubscription(sub)
+			}(msg.Resource)
+
+		case wsUnsubscribe:
+			mu.Lock()
+			if sub, exists := subscribed[msg.Resource]; exists {
+				sub.cancel()
+				delete(subscribed, msg.Resource)
+			}
+			mu.Unlock()
+
+		default:
+			conn.send(wsMessage{Type: wsError, Resource: msg.Resource, Error: "unknown message type: " + msg.Type})
+		}
+	}
+}
+
+// subscribeWebSocket subscribes the WebSocket connection opened by a request
+// to a resource, refusing it as a subscription request for the resource
+// would be, and sends its initial state. Like a subscription request's, its
+// updates are queued and written by its own stream, which the caller must
+// close with closeWebSocketSubscription.
+func (s *BraidMockServer) subscribeWebSocket(conn *wsConn, r *http.Request, connID, name, pointer string) (*wsSubscription, error) {
+	// The connection's own request passed the auth rules, its subscriptions not yet
+	if rule, ok := s.matchAuthRule(name, s.presentedToken(r)); ok {
+		logf(requestID(r), "Refusing WebSocket subscription to %s by auth rule", name)
+		return nil, fmt.Errorf("access denied: %d %s", rule.Status, http.StatusText(rule.Status))
+	}
+
+	resourceID := s.resolveResourceID(name)
+	if !s.resourceExists(r, resourceID) {
+		s.logRejectedPath(r, resourceID)
+		return nil, errors.New("resource not found")
+	}
+	if s.isDeleted(r, resourceID) {
+		return nil, errors.New("resource deleted")
+	}
+
+	data, hash, err := s.readResource(r, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scope the subscription to part of the resource if requested
+	if pointer != "" {
+		if data, err = utils.ResolvePointer(data, pointer); err != nil {
+			return nil, err
+		}
+	}
+
+	// Each subscription counts as a stream against the limits, as it would over HTTP
+	if message := s.streams.reserve(resourceID, false, s.config.Braid.Subscriptions); message != "" {
+		logf(requestID(r), "Refusing WebSocket subscription to %s: %s", resourceID, message)
+		return nil, errors.New(message)
+	}
+
+	encoder := s.hookEncoder(r, resourceID, &wsEncoder{conn: conn, resource: name})
+	stream := newSubscriberStream(nil, encoder, conn, s.config.Braid.Backpressure)
+	sub := &wsSubscription{id: s.ids.NewID(), resource: resourceID, stream: stream}
+
+	s.registerSubscription(Subscription{
+		ID:          sub.id,
+		RequestID:   requestID(r),
+		Session:     s.requestSession(r),
+		Resource:    resourceID,
+		Pointer:     pointer,
+		F:           stream,
+		Encoder:     stream,
+		LastHash:    s.hasher.Hash(data),
+		LastVersion: []string{hash},
+	}, data)
+	logf(requestID(r), "Added WebSocket subscription %s on %s for resource %s%s", sub.id, connID, resourceID, pointer)
+
+	// Changes notified from now on are queued until the initial state is written
+	stream.Start(func(encoder updateEncoder) {
+		encoder.Encode(braidproto.Update{
+			Version:   []string{hash},
+			MergeType: s.resourceMeta(resourceID).MergeType,
+			Body:      string(data),
+			Sequence:  s.sequences.current(resourceID),
+		})
+	})
+	return sub, nil
+}
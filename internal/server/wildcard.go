@@ -0,0 +1,164 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// wildcardSubscription is a single stream receiving updates for every resource under a prefix
+type wildcardSubscription struct {
+	RequestID string
+	Session   string
+	W         http.ResponseWriter
+	F         http.Flusher
+	Encoder   updateEncoder
+}
+
+// isWildcardRequest reports whether a request asks to subscribe to a whole path prefix
+func isWildcardRequest(r *http.Request) bool {
+	return r.Header.Get("Wildcard") == "true" || r.URL.Query().Get("wildcard") == "true"
+}
+
+// handleWildcardSubscription subscribes a client to every resource under the
+// request path, labeling each update on the stream with its resource URL
+func (s *BraidMockServer) handleWildcardSubscription(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Path
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	resources, err := s.store.List(prefix)
+	if err != nil {
+		s.logRejectedPath(r, prefix)
+		s.writeError(w, "Resource not found", http.StatusNotFound)
+		return
+	}
+
+	if !s.reserveStream(w, r, prefix, true) {
+		return
+	}
+	defer s.releaseStream(prefix, true)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	stream, ok := s.startStream(w, r, "")
+	if !ok {
+		return
+	}
+	defer stream.Close()
+
+	subID := s.ids.NewID()
+
+	s.mu.Lock()
+	if _, exists := s.wildcards[prefix]; !exists {
+		s.wildcards[prefix] = make(map[string]wildcardSubscription)
+	}
+	s.wildcards[prefix][subID] = wildcardSubscription{RequestID: requestID(r), Session: s.requestSession(r), W: w, F: stream, Encoder: stream}
+	s.mu.Unlock()
+
+	logf(requestID(r), "Added wildcard subscription %s for prefix %s (%d resources)", subID, prefix, len(resources))
+
+	// Send initial state of every matching resource, before any changes
+	// notified meanwhile
+	stream.Start(func(encoder updateEncoder) {
+		for _, resourceID := range resources {
+			data, hash, err := s.readResource(r, resourceID)
+			if err != nil {
+				logf(requestID(r), "Error reading resource %s: %v", resourceID, err)
+				continue
+			}
+
+			s.registerSubscription(Subscription{
+				ID:          subID,
+				RequestID:   requestID(r),
+				Session:     s.requestSession(r),
+				Resource:    resourceID,
+				Wildcard:    true,
+				W:           w,
+				F:           stream,
+				Encoder:     stream,
+				LastHash:    hash,
+				LastVersion: []string{hash},
+			}, data)
+
+			encoder.Encode(braidproto.Update{
+				URL:       resourceID,
+				Version:   []string{hash},
+				MergeType: s.resourceMeta(resourceID).MergeType,
+				Body:      string(data),
+				Sequence:  s.sequences.current(resourceID),
+			})
+		}
+	})
+
+	// Keep the connection open until the subscription ends
+	s.holdStream(r.Context(), requestID(r), r.URL.Path, stream)
+	s.removeWildcardSubscription(prefix, subID)
+}
+
+// attachWildcardSubscriptions adds entries for wildcard streams covering a
+// resource that they don't track yet, e.g. because its file was just created
+func (s *BraidMockServer) attachWildcardSubscriptions(resourceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for prefix, subs := range s.wildcards {
+		if !strings.HasPrefix(resourceID, prefix) {
+			continue
+		}
+
+		for subID, wildcard := range subs {
+			// No last resource, so the first update is sent in full
+			s.subscriptions.addIfMissing(Subscription{
+				ID:        subID,
+				RequestID: wildcard.RequestID,
+				Session:   wildcard.Session,
+				Resource:  resourceID,
+				Wildcard:  true,
+				W:         wildcard.W,
+				F:         wildcard.F,
+				Encoder:   wildcard.Encoder,
+			})
+		}
+	}
+}
+
+// dropWildcardSubscription removes the wildcard stream with the given ID,
+// whichever prefix it is for
+func (s *BraidMockServer) dropWildcardSubscription(subID string) {
+	s.mu.RLock()
+	var prefixes []string
+	for prefix, subs := range s.wildcards {
+		if _, exists := subs[subID]; exists {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, prefix := range prefixes {
+		s.removeWildcardSubscription(prefix, subID)
+	}
+}
+
+// removeWildcardSubscription removes a wildcard stream and all its per-resource entries
+func (s *BraidMockServer) removeWildcardSubscription(prefix, subID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wildcard, exists := s.wildcards[prefix][subID]
+	if !exists {
+		return
+	}
+	delete(s.wildcards[prefix], subID)
+	if len(s.wildcards[prefix]) == 0 {
+		delete(s.wildcards, prefix)
+	}
+
+	for _, sub := range s.subscriptions.removeAll(subID) {
+		s.releaseState(sub)
+	}
+
+	logf(wildcard.RequestID, "Removed wildcard subscription %s for prefix %s", subID, prefix)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// wireTraceMiddleware logs the response of every subscription byte for byte
+// as it is written, along with the points it is flushed at, when wire tracing
+// is enabled. Each line of a frame is logged separately, quoted, so headers,
+// separators and bodies show exactly as clients parse them.
+func (s *BraidMockServer) wireTraceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.TraceWire || !wantsStream(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tw := &wireTraceWriter{ResponseWriter: w, id: requestID(r)}
+		logf(tw.id, "wire: subscription %s %s opened", r.Method, r.URL.RequestURI())
+		next.ServeHTTP(tw, r)
+		logf(tw.id, "wire: subscription closed after %d bytes", tw.written)
+	})
+}
+
+// wireTraceWriter logs what is written to a response and when it is flushed
+type wireTraceWriter struct {
+	http.ResponseWriter
+	id          string
+	written     int
+	wroteHeader bool
+}
+
+func (tw *wireTraceWriter) WriteHeader(statusCode int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		logf(tw.id, "wire: status %d", statusCode)
+		header := tw.Header()
+		for _, name := range sortedKeys(header) {
+			for _, value := range header[name] {
+				logf(tw.id, "wire: header %s: %s", name, value)
+			}
+		}
+	}
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (tw *wireTraceWriter) Write(data []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	offset := tw.written
+	logf(tw.id, "wire: frame of %d bytes", len(data))
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) > 0 {
+			logf(tw.id, "wire: %8d %q", offset, line)
+			offset += len(line)
+		}
+	}
+
+	n, err := tw.ResponseWriter.Write(data)
+	tw.written += n
+	if err != nil {
+		logf(tw.id, "wire: write failed after %d of %d bytes: %v", n, len(data), err)
+	}
+	return n, err
+}
+
+// Flush sends the response written so far, as subscriptions do with every update
+func (tw *wireTraceWriter) Flush() {
+	tw.FlushError()
+}
+
+// FlushError flushes the response, returning the write error if there is one
+func (tw *wireTraceWriter) FlushError() error {
+	err := http.NewResponseController(tw.ResponseWriter).Flush()
+	if err != nil {
+		logf(tw.id, "wire: flush at %d bytes failed: %v", tw.written, err)
+	} else {
+		logf(tw.id, "wire: flush at %d bytes", tw.written)
+	}
+	return err
+}
+
+// Unwrap lets response controllers reach the underlying response writer
+func (tw *wireTraceWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
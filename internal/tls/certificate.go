@@ -1,6 +1,9 @@
 package tls
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -15,8 +18,41 @@ import (
 	"time"
 )
 
-// EnsureCertificate ensures a certificate exists, generating one if needed
-func EnsureCertificate(certFile, keyFile string) error {
+// Supported key types for generated certificates
+const (
+	KeyTypeRSA   = "rsa"
+	KeyTypeECDSA = "ecdsa"
+)
+
+// CertOptions controls the contents of generated self-signed certificates
+type CertOptions struct {
+	DNSNames    []string      // DNS names in addition to localhost
+	IPAddresses []string      // IP addresses in addition to 127.0.0.1
+	Validity    time.Duration // How long the certificate is valid, defaults to 1 year
+	KeyType     string        // KeyTypeRSA or KeyTypeECDSA, defaults to RSA
+}
+
+// dnsNames returns all DNS names the certificate must cover
+func (o CertOptions) dnsNames() []string {
+	return append([]string{"localhost"}, o.DNSNames...)
+}
+
+// ipAddresses returns all IP addresses the certificate must cover
+func (o CertOptions) ipAddresses() ([]net.IP, error) {
+	ips := []net.IP{net.ParseIP("127.0.0.1")}
+	for _, addr := range o.IPAddresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", addr)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// EnsureCertificate ensures a usable certificate exists, generating one if it
+// is missing, expired or doesn't cover all required names and addresses
+func EnsureCertificate(certFile, keyFile string, opts CertOptions) error {
 	// Check if certificate files already exist
 	certExists := false
 	keyExists := false
@@ -31,17 +67,69 @@ func EnsureCertificate(certFile, keyFile string) error {
 
 	// Generate only if both files don't exist
 	if !certExists || !keyExists {
-		return generateSelfSignedCert(certFile, keyFile)
+		return generateSelfSignedCert(certFile, keyFile, opts)
+	}
+
+	// Regenerate certificates that are no longer suitable
+	if reason := checkCertificate(certFile, opts); reason != "" {
+		log.Printf("Existing certificate %s, regenerating", reason)
+		return generateSelfSignedCert(certFile, keyFile, opts)
 	}
 
 	log.Println("Using existing certificate files")
 	return nil
 }
 
+// checkCertificate returns why an existing certificate can't be used, or an
+// empty string if it can
+func checkCertificate(certFile string, opts CertOptions) string {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return "is unreadable"
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "is not a PEM certificate"
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "cannot be parsed"
+	}
+
+	if time.Now().After(cert.NotAfter) {
+		return "has expired"
+	}
+
+	for _, name := range opts.dnsNames() {
+		if cert.VerifyHostname(name) != nil {
+			return fmt.Sprintf("does not cover %s", name)
+		}
+	}
+
+	ips, err := opts.ipAddresses()
+	if err != nil {
+		return err.Error()
+	}
+	for _, ip := range ips {
+		if cert.VerifyHostname(ip.String()) != nil {
+			return fmt.Sprintf("does not cover %s", ip)
+		}
+	}
+
+	return ""
+}
+
 // generateSelfSignedCert creates a self-signed certificate and key
-func generateSelfSignedCert(certFile, keyFile string) error {
+func generateSelfSignedCert(certFile, keyFile string, opts CertOptions) error {
 	log.Println("Generating self-signed certificate...")
 
+	ips, err := opts.ipAddresses()
+	if err != nil {
+		return err
+	}
+
 	// Create certificate directory if it doesn't exist
 	certDir := filepath.Dir(certFile)
 	if _, err := os.Stat(certDir); os.IsNotExist(err) {
@@ -51,20 +139,29 @@ func generateSelfSignedCert(certFile, keyFile string) error {
 	}
 
 	// Generate private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	privateKey, publicKey, keyBlock, err := generateKey(opts.KeyType)
 	if err != nil {
 		return fmt.Errorf("failed to generate private key: %w", err)
 	}
 
 	// Prepare certificate template
+	validity := opts.Validity
+	if validity <= 0 {
+		validity = 365 * 24 * time.Hour // Valid for 1 year
+	}
 	notBefore := time.Now()
-	notAfter := notBefore.Add(365 * 24 * time.Hour) // Valid for 1 year
+	notAfter := notBefore.Add(validity)
 
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
 		return fmt.Errorf("failed to generate serial number: %w", err)
 	}
 
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, isRSA := privateKey.(*rsa.PrivateKey); isRSA {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
@@ -73,15 +170,15 @@ func generateSelfSignedCert(certFile, keyFile string) error {
 		},
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
-		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
-		DNSNames:              []string{"localhost"},
+		IPAddresses:           ips,
+		DNSNames:              opts.dnsNames(),
 	}
 
 	// Create certificate
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey, privateKey)
 	if err != nil {
 		return fmt.Errorf("failed to create certificate: %w", err)
 	}
@@ -104,8 +201,7 @@ func generateSelfSignedCert(certFile, keyFile string) error {
 	}
 	defer keyOut.Close()
 
-	privBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	if err := pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: privBytes}); err != nil {
+	if err := pem.Encode(keyOut, keyBlock); err != nil {
 		return fmt.Errorf("failed to write private key: %w", err)
 	}
 
@@ -114,3 +210,32 @@ func generateSelfSignedCert(certFile, keyFile string) error {
 
 	return nil
 }
+
+// generateKey generates a private key of the given type along with its
+// public key and PEM encoding
+func generateKey(keyType string) (crypto.Signer, crypto.PublicKey, *pem.Block, error) {
+	switch keyType {
+	case KeyTypeRSA, "":
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+		return privateKey, &privateKey.PublicKey, block, nil
+
+	case KeyTypeECDSA:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		privBytes, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}
+		return privateKey, &privateKey.PublicKey, block, nil
+
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown key type: %s", keyType)
+	}
+}
@@ -0,0 +1,48 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ClientAuthConfig creates a TLS configuration that requires client certificates
+// signed by a CA in caFile. If allowedSubjects is not empty, the client
+// certificate's common name or full subject must also be one of them.
+func ClientAuthConfig(caFile string, allowedSubjects []string) (*tls.Config, error) {
+	caData, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %s", caFile)
+	}
+
+	config := &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+
+	if len(allowedSubjects) > 0 {
+		allowed := make(map[string]bool, len(allowedSubjects))
+		for _, subject := range allowedSubjects {
+			allowed[subject] = true
+		}
+
+		// Chains have already been verified against the CA bundle at this point
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				leaf := chain[0]
+				if allowed[leaf.Subject.CommonName] || allowed[leaf.Subject.String()] {
+					return nil
+				}
+			}
+			return fmt.Errorf("client certificate subject not allowed")
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers, so that code waiting on time can
+// be driven by a FakeClock in tests instead of real sleeps
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+// Timer is a timer created by a Clock, behaving like a time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// RealClock is the system clock
+type RealClock struct{}
+
+// Now returns the current time
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer creates a timer firing after d
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// Sleep pauses the calling goroutine for d
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// realTimer adapts a time.Timer to the Timer interface
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// FakeClock is a Clock whose time only moves when Advance is called, firing
+// the timers and waking the sleepers that are due
+type FakeClock struct {
+	now    time.Time
+	timers map[*fakeTimer]bool
+	mu     sync.Mutex
+}
+
+// NewFakeClock creates a fake clock set to the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, timers: make(map[*fakeTimer]bool)}
+}
+
+// Now returns the fake current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a timer firing once the clock is advanced by d
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Sleep blocks until the clock is advanced by d
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing every timer that is due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if !t.deadline.After(c.now) {
+			delete(c.timers, t)
+			select {
+			case t.c <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+// Waiters returns the number of timers and sleepers that haven't fired yet,
+// so tests can wait for the code under test to start waiting before advancing
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// fakeTimer is a timer of a FakeClock
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.clock.timers[t]
+	t.deadline = t.clock.now.Add(d)
+	t.clock.timers[t] = true
+	return active
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Supported hash algorithms for version identifiers
+const (
+	HashCRC32  = "crc32"
+	HashSHA256 = "sha256"
+	HashUUID   = "uuid-per-update"
+)
+
+// sha256Length is the number of hex characters kept from a SHA-256 digest
+const sha256Length = 16
+
+// Hasher generates version identifiers for resource content
+type Hasher interface {
+	Hash(data []byte) string
+	HashReader(r io.Reader) (string, error) // Hashes content incrementally as it is read
+}
+
+// NewHasher returns the Hasher for the named algorithm
+func NewHasher(algorithm string) (Hasher, error) {
+	switch algorithm {
+	case HashCRC32:
+		return CRC32Hasher{}, nil
+	case HashSHA256, "":
+		return SHA256Hasher{Length: sha256Length}, nil
+	case HashUUID:
+		return NewUUIDHasher(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm: %s", algorithm)
+	}
+}
+
+// CRC32Hasher generates versions from a CRC32 checksum of the content
+type CRC32Hasher struct{}
+
+// Hash returns the CRC32 checksum of the data
+func (CRC32Hasher) Hash(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+// HashReader returns the CRC32 checksum of the content read from r
+func (CRC32Hasher) HashReader(r io.Reader) (string, error) {
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", h.Sum32()), nil
+}
+
+// SHA256Hasher generates versions from a SHA-256 digest of the content
+type SHA256Hasher struct {
+	Length int // Number of hex characters to keep, 0 keeps the full digest
+}
+
+// Hash returns the optionally truncated SHA-256 digest of the data
+func (h SHA256Hasher) Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return h.digest(sum[:])
+}
+
+// HashReader returns the optionally truncated SHA-256 digest of the content read from r
+func (h SHA256Hasher) HashReader(r io.Reader) (string, error) {
+	sum, err := sha256Reader(r)
+	if err != nil {
+		return "", err
+	}
+	return h.digest(sum[:]), nil
+}
+
+// digest encodes a SHA-256 sum, truncated to the configured length
+func (h SHA256Hasher) digest(sum []byte) string {
+	digest := hex.EncodeToString(sum)
+	if h.Length > 0 && h.Length < len(digest) {
+		digest = digest[:h.Length]
+	}
+	return digest
+}
+
+// sha256Reader returns the SHA-256 sum of the content read from r
+func sha256Reader(r io.Reader) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// uuidHasherSize bounds how many contents a UUIDHasher remembers the UUIDs of
+const uuidHasherSize = 4096
+
+// Renewer is implemented by hashers whose versions stand for changes rather
+// than content, which need a fresh version whenever a resource changes
+type Renewer interface {
+	Renew(data []byte) string
+}
+
+// UUIDHasher assigns a random UUID to every change it is told about, so
+// versions carry no information about the content itself. Content it hasn't
+// been told about gets a UUID too, which it keeps for the most recently
+// seen contents so the same content keeps the same version meanwhile.
+type UUIDHasher struct {
+	mu    sync.Mutex
+	seen  map[[sha256.Size]byte]string
+	order [][sha256.Size]byte // Sums in seen, oldest first
+}
+
+// NewUUIDHasher creates a new UUIDHasher
+func NewUUIDHasher() *UUIDHasher {
+	return &UUIDHasher{seen: make(map[[sha256.Size]byte]string)}
+}
+
+// Hash returns the UUID assigned to the data, generating one if needed
+func (h *UUIDHasher) Hash(data []byte) string {
+	return h.id(sha256.Sum256(data), false)
+}
+
+// HashReader returns the UUID assigned to the content read from r, generating one if needed
+func (h *UUIDHasher) HashReader(r io.Reader) (string, error) {
+	sum, err := sha256Reader(r)
+	if err != nil {
+		return "", err
+	}
+	return h.id(sum, false), nil
+}
+
+// Renew assigns a fresh UUID to the data, which a resource changed to, even
+// if it had one from an earlier change to the same content
+func (h *UUIDHasher) Renew(data []byte) string {
+	return h.id(sha256.Sum256(data), true)
+}
+
+// id returns the UUID assigned to content with the given SHA-256 sum,
+// generating one if there is none or renew is set
+func (h *UUIDHasher) id(sum [sha256.Size]byte, renew bool) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id, ok := h.seen[sum]
+	if ok && !renew {
+		return id
+	}
+
+	id = newUUID()
+	if !ok {
+		if len(h.order) >= uuidHasherSize {
+			delete(h.seen, h.order[0])
+			h.order = h.order[1:]
+		}
+		h.order = append(h.order, sum)
+	}
+	h.seen[sum] = id
+	return id
+}
+
+// newUUID generates a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
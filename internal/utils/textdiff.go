@@ -0,0 +1,28 @@
+package utils
+
+// DiffText returns the single replacement turning oldText into newText, as
+// the range [start:end] of oldText in code points and the text replacing it.
+// It returns false if the texts are equal.
+func DiffText(oldText, newText string) (start, end int, replacement string, changed bool) {
+	if oldText == newText {
+		return 0, 0, "", false
+	}
+
+	oldRunes := []rune(oldText)
+	newRunes := []rune(newText)
+
+	// Skip the common prefix
+	prefix := 0
+	for prefix < len(oldRunes) && prefix < len(newRunes) && oldRunes[prefix] == newRunes[prefix] {
+		prefix++
+	}
+
+	// Skip the common suffix, without overlapping the prefix
+	suffix := 0
+	for suffix < len(oldRunes)-prefix && suffix < len(newRunes)-prefix &&
+		oldRunes[len(oldRunes)-1-suffix] == newRunes[len(newRunes)-1-suffix] {
+		suffix++
+	}
+
+	return prefix, len(oldRunes) - suffix, string(newRunes[prefix : len(newRunes)-suffix]), true
+}
@@ -2,17 +2,31 @@ package utils
 
 import (
 	"fmt"
-	"hash/crc32"
-	"time"
+	"sync/atomic"
 )
 
-// CalculateHash generates a CRC32 hash of the data
-func CalculateHash(data []byte) string {
-	table := crc32.MakeTable(crc32.IEEE)
-	return fmt.Sprintf("\"%08x\"", crc32.Checksum(data, table))
+// IDGenerator generates unique IDs for subscriptions, connections and requests
+type IDGenerator interface {
+	NewID() string
 }
 
-// GenerateRandomID generates a random ID for subscriptions
-func GenerateRandomID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+// UUIDGenerator generates random version 4 UUIDs, which unlike timestamps
+// don't collide when many IDs are generated at once
+type UUIDGenerator struct{}
+
+// NewID returns a new random UUID
+func (UUIDGenerator) NewID() string {
+	return newUUID()
+}
+
+// SequentialIDGenerator generates IDs from a counter, such as "sub-1",
+// "sub-2", for tests that need predictable IDs
+type SequentialIDGenerator struct {
+	Prefix string
+	next   atomic.Uint64
+}
+
+// NewID returns the next ID in the sequence
+func (g *SequentialIDGenerator) NewID() string {
+	return fmt.Sprintf("%s%d", g.Prefix, g.next.Add(1))
 }
@@ -0,0 +1,258 @@
+// Package braidmocktest helps Go tests of Braid clients, and of the mock
+// server itself, run a mock server and check the updates it sends
+package braidmocktest
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gihan9a/braidmock/internal/config"
+	"gihan9a/braidmock/internal/server"
+	"gihan9a/braidmock/pkg/braidproto"
+)
+
+// DefaultTimeout is how long assertions wait for an update before failing the test
+const DefaultTimeout = 5 * time.Second
+
+// Server is a mock server serving fixtures for the duration of a test
+type Server struct {
+	Mock *server.BraidMockServer // The mock server, e.g. to update resources with UpdateResource
+	URL  string                  // Base URL of the server, e.g. http://127.0.0.1:1234
+
+	t testing.TB
+}
+
+// NewServer starts a mock server serving the mock files in fixtures with the
+// default configuration, which is closed when the test ends
+func NewServer(t testing.TB, fixtures fs.FS) *Server {
+	t.Helper()
+
+	cfg, err := config.LoadConfig("")
+	if err != nil {
+		t.Fatalf("braidmocktest: error loading default config: %v", err)
+	}
+	return NewServerWithConfig(t, cfg, fixtures)
+}
+
+// NewServerWithConfig starts a mock server serving the mock files in
+// fixtures with the given configuration, which is closed when the test ends
+func NewServerWithConfig(t testing.TB, cfg *config.Config, fixtures fs.FS) *Server {
+	t.Helper()
+
+	mock, err := server.NewBraidMockServerFromFS(cfg, fixtures)
+	if err != nil {
+		t.Fatalf("braidmocktest: error creating mock server: %v", err)
+	}
+	ts := httptest.NewServer(mock.SetupRoutes())
+	t.Cleanup(func() {
+		ts.Close()
+		mock.Close()
+	})
+
+	return &Server{Mock: mock, URL: ts.URL, t: t}
+}
+
+// Update replaces the body of a resource, failing the test if it can't
+func (s *Server) Update(resourceID string, body string) {
+	s.t.Helper()
+
+	if err := s.Mock.UpdateResource(resourceID, []byte(body)); err != nil {
+		s.t.Fatalf("braidmocktest: error updating %s: %v", resourceID, err)
+	}
+}
+
+// Client returns a test client for the server
+func (s *Server) Client() *Client {
+	return NewClient(s.t, s.URL)
+}
+
+// Client performs Braid requests against a server, failing the test when they fail
+type Client struct {
+	BaseURL string             // URL that request paths are relative to
+	Timeout time.Duration      // How long assertions wait for updates
+	Braid   *braidproto.Client // Underlying client, e.g. to set extra headers
+
+	t testing.TB
+}
+
+// NewClient creates a test client for the server at baseURL
+func NewClient(t testing.TB, baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Timeout: DefaultTimeout,
+		Braid:   braidproto.NewClient(nil),
+		t:       t,
+	}
+}
+
+// Get fetches the current state of a resource
+func (c *Client) Get(path string) braidproto.Update {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	update, err := c.Braid.Get(ctx, c.BaseURL+path)
+	if err != nil {
+		c.t.Fatalf("braidmocktest: GET %s: %v", path, err)
+	}
+	return *update
+}
+
+// Subscribe subscribes to a resource until the test ends
+func (c *Client) Subscribe(path string) *Subscription {
+	c.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := c.Braid.Subscribe(ctx, c.BaseURL+path)
+	if err != nil {
+		cancel()
+		c.t.Fatalf("braidmocktest: subscribing to %s: %v", path, err)
+	}
+	c.t.Cleanup(cancel)
+
+	return &Subscription{
+		Path:    path,
+		Header:  sub.Header,
+		Timeout: c.Timeout,
+		sub:     sub,
+		cancel:  cancel,
+		t:       c.t,
+	}
+}
+
+// Subscription is an open subscription whose updates are checked in the
+// order they arrive
+type Subscription struct {
+	Path    string        // Path the subscription was made to
+	Header  http.Header   // Response headers of the subscription request
+	Timeout time.Duration // How long assertions wait for updates
+
+	sub    *braidproto.Subscription
+	cancel context.CancelFunc
+	t      testing.TB
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+// Next returns the next update, failing the test if none arrives in time or
+// the stream ends
+func (s *Subscription) Next() braidproto.Update {
+	s.t.Helper()
+
+	timer := time.NewTimer(s.Timeout)
+	defer timer.Stop()
+
+	select {
+	case update, ok := <-s.sub.Updates:
+		if !ok {
+			if err := s.sub.Err(); err != nil {
+				s.t.Fatalf("braidmocktest: subscription to %s failed: %v", s.Path, err)
+			}
+			s.t.Fatalf("braidmocktest: subscription to %s ended", s.Path)
+		}
+		return update
+	case <-timer.C:
+		s.t.Fatalf("braidmocktest: no update to %s within %v", s.Path, s.Timeout)
+	}
+	return braidproto.Update{}
+}
+
+// ExpectSnapshot checks that the next update is the full state of the
+// resource with the given body, compared as JSON if both are JSON. An empty
+// body accepts any snapshot.
+func (s *Subscription) ExpectSnapshot(body string) braidproto.Update {
+	s.t.Helper()
+
+	update := s.Next()
+	if len(update.Patches) > 0 {
+		s.t.Fatalf("braidmocktest: expected a snapshot of %s, got %d patches: %+v", s.Path, len(update.Patches), update.Patches)
+	}
+	if body != "" && !sameBody(update.Body, body) {
+		s.t.Fatalf("braidmocktest: expected snapshot of %s to be %s, got %s", s.Path, body, update.Body)
+	}
+	return update
+}
+
+// ExpectPatchAt checks that the next update is a patch changing the given
+// range, such as a JSON Pointer like "/foo/bar", and returns that patch.
+// JSON Patch documents match if one of their operations is at the path.
+func (s *Subscription) ExpectPatchAt(path string) braidproto.Patch {
+	s.t.Helper()
+
+	update := s.Next()
+	if len(update.Patches) == 0 {
+		s.t.Fatalf("braidmocktest: expected a patch at %s of %s, got a snapshot: %s", path, s.Path, update.Body)
+	}
+	for _, patch := range update.Patches {
+		if patch.Range == path || slices.Contains(jsonPatchPaths(patch), path) {
+			return patch
+		}
+	}
+	s.t.Fatalf("braidmocktest: expected a patch at %s of %s, got %+v", path, s.Path, update.Patches)
+	return braidproto.Patch{}
+}
+
+// WaitForVersion skips updates until one with the given version arrives and
+// returns it, failing the test if none does in time
+func (s *Subscription) WaitForVersion(version string) braidproto.Update {
+	s.t.Helper()
+
+	deadline := time.Now().Add(s.Timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			s.t.Fatalf("braidmocktest: version %s of %s not received within %v", version, s.Path, s.Timeout)
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case update, ok := <-s.sub.Updates:
+			timer.Stop()
+			if !ok {
+				s.t.Fatalf("braidmocktest: subscription to %s ended before version %s", s.Path, version)
+			}
+			if slices.Contains(update.Version, version) {
+				return update
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+// sameBody reports whether two bodies are equal, as JSON values if both are JSON
+func sameBody(got, want string) bool {
+	var gotValue, wantValue interface{}
+	if json.Unmarshal([]byte(got), &gotValue) == nil && json.Unmarshal([]byte(want), &wantValue) == nil {
+		return reflect.DeepEqual(gotValue, wantValue)
+	}
+	return got == want
+}
+
+// jsonPatchPaths returns the paths of the operations of a JSON Patch
+// document, or nothing if the patch isn't one
+func jsonPatchPaths(patch braidproto.Patch) []string {
+	var operations []struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal([]byte(patch.Content), &operations) != nil {
+		return nil
+	}
+
+	paths := make([]string, len(operations))
+	for i, op := range operations {
+		paths[i] = op.Path
+	}
+	return paths
+}
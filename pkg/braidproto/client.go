@@ -0,0 +1,171 @@
+package braidproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Braid protocol status codes
+const (
+	StatusSubscribed     = 209 // Successful subscription
+	StatusVersionUnknown = 309 // Requested Version or Parents are unknown to the server
+)
+
+// Client performs Braid GET and Subscribe requests
+type Client struct {
+	HTTPClient   *http.Client // HTTP client used for requests
+	Header       http.Header  // Extra headers sent with every request
+	MaxFrameSize int          // Limit on the content of an update received by subscriptions, 0 for DefaultMaxFrameSize, -1 for none
+}
+
+// NewClient creates a new Client, using http.DefaultClient if httpClient is nil
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		HTTPClient: httpClient,
+		Header:     make(http.Header),
+	}
+}
+
+// Subscription is an open subscription to a Braid resource
+type Subscription struct {
+	Updates <-chan Update // Updates received from the server, closed when the stream ends
+	Header  http.Header   // Response headers of the subscription request
+
+	mu  sync.Mutex
+	err error
+}
+
+// Err returns the error that ended the subscription, if any. A warning the
+// server sent before closing the stream is returned as a *WarningError.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Get fetches the current state of a resource as a single Update
+func (c *Client) Get(ctx context.Context, url string) (*Update, error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	version, err := ParseVersions(resp.Header.Get("Version"))
+	if err != nil {
+		return nil, err
+	}
+	parents, err := ParseVersions(resp.Header.Get("Parents"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Update{
+		Version:   version,
+		Parents:   parents,
+		MergeType: resp.Header.Get("Merge-Type"),
+		Body:      string(body),
+	}, nil
+}
+
+// Subscribe subscribes to a resource and delivers every update on the returned
+// Subscription until the context is cancelled or the server closes the stream
+func (c *Client) Subscribe(ctx context.Context, url string) (*Subscription, error) {
+	req, err := c.newRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Subscribe", "true")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != StatusSubscribed && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	updates := make(chan Update)
+	sub := &Subscription{
+		Updates: updates,
+		Header:  resp.Header,
+	}
+
+	go func() {
+		defer close(updates)
+		defer resp.Body.Close()
+
+		decoder := NewDecoder(resp.Body)
+		if c.MaxFrameSize != 0 {
+			decoder.MaxFrameSize = c.MaxFrameSize
+		}
+		for {
+			update, err := decoder.Decode()
+			var warning *WarningError
+			if errors.As(err, &warning) {
+				// Keep the warning to explain why the server closes the stream next
+				sub.mu.Lock()
+				sub.err = err
+				sub.mu.Unlock()
+				continue
+			}
+			if err != nil {
+				// A cancelled context or a cleanly closed stream is not an
+				// error, and one after a warning is explained by it
+				sub.mu.Lock()
+				if ctx.Err() == nil && !errors.Is(err, io.EOF) && sub.err == nil {
+					sub.err = err
+				}
+				sub.mu.Unlock()
+				return
+			}
+
+			select {
+			case updates <- update:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+// newRequest creates a GET request carrying the client's extra headers
+func (c *Client) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	for key, values := range c.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	return req, nil
+}
@@ -0,0 +1,37 @@
+package braidproto
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubscribeWarning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(StatusSubscribed)
+		e := NewEncoder(w)
+		e.Encode(Update{Version: []string{"v1"}, Body: "{}"})
+		e.EncodeWarning("Subscriber fell behind by more than 64 updates")
+	}))
+	defer server.Close()
+
+	sub, err := NewClient(nil).Subscribe(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []Update
+	for update := range sub.Updates {
+		updates = append(updates, update)
+	}
+	if len(updates) != 1 || updates[0].Body != "{}" {
+		t.Errorf("received updates %+v, want only the initial one", updates)
+	}
+
+	var warning *WarningError
+	if !errors.As(sub.Err(), &warning) || warning.Message != "Subscriber fell behind by more than 64 updates" {
+		t.Errorf("Err() = %v, want the server's warning", sub.Err())
+	}
+}
@@ -0,0 +1,320 @@
+package braidproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTrip encodes updates with an encoder and decodes them again
+func roundTrip(t *testing.T, encoder func(w io.Writer) *Encoder, updates ...Update) []Update {
+	t.Helper()
+
+	var buf bytes.Buffer
+	e := encoder(&buf)
+	for _, update := range updates {
+		if err := e.Encode(update); err != nil {
+			t.Fatalf("Encode(%+v): %v", update, err)
+		}
+	}
+
+	var decoded []Update
+	for update, err := range NewDecoder(&buf).All() {
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		decoded = append(decoded, update)
+	}
+	return decoded
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		update Update
+	}{
+		{
+			name:   "body",
+			update: Update{Version: []string{"v2"}, Parents: []string{"v1"}, Body: `{"a": 1}`},
+		},
+		{
+			name:   "empty body",
+			update: Update{Version: []string{"v1"}},
+		},
+		{
+			name: "all headers",
+			update: Update{
+				URL:       "/users/me",
+				Version:   []string{"v3", "v4"},
+				Parents:   []string{"v1", "v2"},
+				MergeType: "sync9",
+				Body:      "gone",
+				Status:    410,
+				Sequence:  7,
+			},
+		},
+		{
+			name: "single patch",
+			update: Update{
+				Version: []string{"v2"},
+				Parents: []string{"v1"},
+				Patches: []Patch{{Unit: "json", Range: ".a", Content: "2"}},
+			},
+		},
+		{
+			name: "patch without range",
+			update: Update{
+				Version: []string{"v2"},
+				Parents: []string{"v1"},
+				Patches: []Patch{{Unit: "json-patch", Content: `[{"op":"remove","path":"/a"}]`}},
+			},
+		},
+		{
+			name: "multiple patches",
+			update: Update{
+				Version: []string{"v2"},
+				Parents: []string{"v1"},
+				Patches: []Patch{
+					{Unit: "add", Range: "/a/-", Content: "4"},
+					{Unit: "replace", Range: "/b", Content: `"x"`},
+					{Unit: "remove", Range: "/c", Content: ""},
+				},
+			},
+		},
+		{
+			name: "framing in content",
+			update: Update{
+				Version: []string{"v2"},
+				Body:    "line\r\n\r\nVersion: \"fake\"\r\n\r\n\r\n\r\n\r\n",
+			},
+		},
+		{
+			name: "framing in patches",
+			update: Update{
+				Version: []string{"v2"},
+				Patches: []Patch{
+					{Unit: "text", Range: "[0:0]", Content: "a\r\n\r\n"},
+					{Unit: "text", Range: "[1:1]", Content: "\n\nPatches: 3\n\n"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded := roundTrip(t, NewEncoder, test.update)
+			if len(decoded) != 1 || !reflect.DeepEqual(decoded[0], test.update) {
+				t.Errorf("decoded %+v, want %+v", decoded, test.update)
+			}
+		})
+	}
+}
+
+func TestRoundTripCompressed(t *testing.T) {
+	gzipEncoder := func(w io.Writer) *Encoder {
+		e := NewEncoder(w)
+		e.ContentEncoding = "gzip"
+		e.CompressMinSize = 16
+		return e
+	}
+	updates := []Update{
+		{Version: []string{"v1"}, Body: strings.Repeat("compressed body ", 100)},
+		{Version: []string{"v2"}, Parents: []string{"v1"}, Body: "short"},
+		{Version: []string{"v3"}, Parents: []string{"v2"}, Patches: []Patch{
+			{Unit: "replace", Range: "/a", Content: strings.Repeat("x", 64)},
+			{Unit: "replace", Range: "/b", Content: "1"},
+		}},
+	}
+
+	decoded := roundTrip(t, gzipEncoder, updates...)
+	if !reflect.DeepEqual(decoded, updates) {
+		t.Errorf("decoded %+v, want %+v", decoded, updates)
+	}
+}
+
+func TestRoundTripStream(t *testing.T) {
+	updates := []Update{
+		{Version: []string{"v1"}, Body: "{}"},
+		{Version: []string{"v2"}, Parents: []string{"v1"}, Patches: []Patch{{Unit: "json", Range: ".a", Content: "1"}}},
+		{Version: []string{"v3"}, Parents: []string{"v2"}},
+		{Version: []string{"v4"}, Parents: []string{"v3"}, Patches: []Patch{
+			{Unit: "json", Range: ".a", Content: "2"},
+			{Unit: "json", Range: ".b", Content: "3"},
+		}},
+	}
+
+	decoded := roundTrip(t, NewEncoder, updates...)
+	if !reflect.DeepEqual(decoded, updates) {
+		t.Errorf("decoded %+v, want %+v", decoded, updates)
+	}
+}
+
+func TestDecodeFraming(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []Update
+	}{
+		{
+			name:   "bare LF",
+			stream: "Version: \"v1\"\nContent-Length: 2\n\n{}\n\nVersion: \"v2\"\nParents: \"v1\"\nContent-Length: 3\n\n[1]",
+			want: []Update{
+				{Version: []string{"v1"}, Body: "{}"},
+				{Version: []string{"v2"}, Parents: []string{"v1"}, Body: "[1]"},
+			},
+		},
+		{
+			name:   "no separators",
+			stream: "Version: \"v1\"\r\nContent-Length: 1\r\n\r\naVersion: \"v2\"\r\nContent-Length: 1\r\n\r\nb",
+			want: []Update{
+				{Version: []string{"v1"}, Body: "a"},
+				{Version: []string{"v2"}, Body: "b"},
+			},
+		},
+		{
+			name:   "leading blank lines",
+			stream: "\r\n\r\n\nVersion: \"v1\"\r\nContent-Length: 0\r\n\r\n",
+			want:   []Update{{Version: []string{"v1"}}},
+		},
+		{
+			name:   "content length counts bytes",
+			stream: "Version: \"v1\"\r\nContent-Length: 6\r\n\r\nhéé\r\n",
+			want:   []Update{{Version: []string{"v1"}, Body: "héé\r"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var decoded []Update
+			for update, err := range NewDecoder(strings.NewReader(test.stream)).All() {
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				decoded = append(decoded, update)
+			}
+			if !reflect.DeepEqual(decoded, test.want) {
+				t.Errorf("decoded %+v, want %+v", decoded, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   error
+	}{
+		{name: "truncated headers", stream: "Version: \"v1\"\r\nContent-Length: 1", want: io.ErrUnexpectedEOF},
+		{name: "truncated body", stream: "Version: \"v1\"\r\nContent-Length: 10\r\n\r\nabc", want: io.ErrUnexpectedEOF},
+		{name: "truncated patches", stream: "Version: \"v1\"\r\nPatches: 2\r\n\r\nContent-Length: 1\r\nContent-Range: json .a\r\n\r\n1", want: io.ErrUnexpectedEOF},
+		{name: "missing content length", stream: "Version: \"v1\"\r\n\r\n"},
+		{name: "negative content length", stream: "Version: \"v1\"\r\nContent-Length: -1\r\n\r\n"},
+		{name: "invalid patches", stream: "Version: \"v1\"\r\nPatches: many\r\n\r\n"},
+		{name: "unknown encoding", stream: "Version: \"v1\"\r\nContent-Encoding: br\r\nContent-Length: 0\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewDecoder(strings.NewReader(test.stream)).Decode()
+			if err == nil {
+				t.Fatal("Decode succeeded, want an error")
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("Decode error %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	if _, err := NewDecoder(strings.NewReader("\r\n\r\n")).Decode(); err != io.EOF {
+		t.Errorf("Decode of an empty stream returned %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeWarning(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	update := Update{Version: []string{"v1"}, Body: "{}"}
+	if err := e.Encode(update); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.EncodeWarning(`Subscriber too slow, "disconnecting"`); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Warning: 199 - \"Subscriber too slow, \\\"disconnecting\\\"\"\r\n") {
+		t.Errorf("warning frame not quoted as expected: %q", buf.String())
+	}
+
+	d := NewDecoder(&buf)
+	decoded, err := d.Decode()
+	if err != nil || !reflect.DeepEqual(decoded, update) {
+		t.Fatalf("Decode returned %+v, %v, want %+v", decoded, err, update)
+	}
+
+	// The warning frame is returned as an error, not an empty update
+	_, err = d.Decode()
+	var warning *WarningError
+	if !errors.As(err, &warning) {
+		t.Fatalf("Decode of warning returned %v, want a *WarningError", err)
+	}
+	if warning.Message != `Subscriber too slow, "disconnecting"` {
+		t.Errorf("warning message %q", warning.Message)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Decode after warning returned %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeMaxFrameSize(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Encode(Update{Version: []string{"v1"}, Body: strings.Repeat("a", 100)})
+	e.Encode(Update{Version: []string{"v2"}, Patches: []Patch{
+		{Unit: "json", Range: ".a", Content: strings.Repeat("b", 60)},
+		{Unit: "json", Range: ".b", Content: strings.Repeat("c", 60)},
+	}})
+	e.ContentEncoding = "gzip"
+	e.Encode(Update{Version: []string{"v3"}, Body: strings.Repeat("d", 1000)})
+	stream := buf.String()
+
+	tests := []struct {
+		name    string
+		maxSize int
+		want    []error
+	}{
+		{name: "default", maxSize: DefaultMaxFrameSize, want: []error{nil, nil, nil}},
+		{name: "disabled", maxSize: 0, want: []error{nil, nil, nil}},
+		{name: "exact", maxSize: 100, want: []error{nil, ErrFrameTooLarge}},
+		{name: "body over", maxSize: 99, want: []error{ErrFrameTooLarge}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(stream))
+			d.MaxFrameSize = test.maxSize
+			for i, want := range test.want {
+				_, err := d.Decode()
+				if !errors.Is(err, want) {
+					t.Fatalf("update %d: Decode error %v, want %v", i, err, want)
+				}
+			}
+		})
+	}
+
+	// Compressed content is limited by its decompressed size
+	d := NewDecoder(strings.NewReader(stream))
+	d.MaxFrameSize = 500
+	d.Decode()
+	d.Decode()
+	if _, err := d.Decode(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Decode of a compressed body over the limit returned %v, want ErrFrameTooLarge", err)
+	}
+
+	// Oversized frames are refused before their content is allocated
+	huge := "Version: \"v1\"\r\nContent-Length: 1099511627776\r\n\r\n"
+	if _, err := NewDecoder(strings.NewReader(huge)).Decode(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Decode of a huge Content-Length returned %v, want ErrFrameTooLarge", err)
+	}
+}
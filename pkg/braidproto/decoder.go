@@ -0,0 +1,245 @@
+package braidproto
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxFrameSize is the default limit on the content of a single update
+const DefaultMaxFrameSize = 64 << 20
+
+// ErrFrameTooLarge is returned when an update's content exceeds the
+// decoder's MaxFrameSize
+var ErrFrameTooLarge = errors.New("update exceeds maximum frame size")
+
+// WarningError is returned for a frame carrying only a Warning header, which
+// servers send to tell a client why they are closing the stream
+type WarningError struct {
+	Message string
+}
+
+func (e *WarningError) Error() string {
+	return "server warning: " + e.Message
+}
+
+// Decoder reads Updates from a Braid subscription stream
+type Decoder struct {
+	r *bufio.Reader
+
+	// MaxFrameSize limits the bytes of body and patch content of a single
+	// update, before and after decompression, so a misbehaving server can't
+	// make the decoder allocate without bound. Zero or less disables it.
+	MaxFrameSize int
+
+	frameLeft int // Bytes left of MaxFrameSize for the update being decoded
+}
+
+// NewDecoder creates a new Decoder reading from r, limiting updates to
+// DefaultMaxFrameSize
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), MaxFrameSize: DefaultMaxFrameSize}
+}
+
+// Decode reads the next Update from the stream. It returns io.EOF when the
+// stream ends cleanly between updates, io.ErrUnexpectedEOF when it ends in
+// the middle of one, and a *WarningError for a warning frame, after which
+// the stream may still continue.
+func (d *Decoder) Decode() (Update, error) {
+	var update Update
+
+	header, err := d.readHeaderBlock()
+	if err != nil {
+		return update, err
+	}
+	d.frameLeft = d.MaxFrameSize
+
+	// Warning frames carry no update, only the reason the stream is closing
+	if warning := header.Get("Warning"); warning != "" && header.Get("Version") == "" {
+		if _, err := d.readBody(header); err != nil {
+			return update, err
+		}
+		return update, &WarningError{Message: parseWarning(warning)}
+	}
+
+	update.URL = header.Get("Content-Location")
+	update.MergeType = header.Get("Merge-Type")
+	if status := header.Get("Status"); status != "" {
+		if update.Status, err = strconv.Atoi(status); err != nil {
+			return update, fmt.Errorf("invalid Status header: %q", status)
+		}
+	}
+	if sequence := header.Get("Sequence"); sequence != "" {
+		if update.Sequence, err = strconv.ParseUint(sequence, 10, 64); err != nil {
+			return update, fmt.Errorf("invalid Sequence header: %q", sequence)
+		}
+	}
+	if update.Version, err = ParseVersions(header.Get("Version")); err != nil {
+		return update, err
+	}
+	if update.Parents, err = ParseVersions(header.Get("Parents")); err != nil {
+		return update, err
+	}
+
+	// Multiple patches are announced with a Patches header, each with its own header block
+	if count := header.Get("Patches"); count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return update, fmt.Errorf("invalid Patches header: %q", count)
+		}
+
+		for i := 0; i < n; i++ {
+			patchHeader, err := d.readHeaderBlock()
+			if err != nil {
+				return update, unexpectedEOF(err)
+			}
+
+			patch, err := d.readPatch(patchHeader)
+			if err != nil {
+				return update, err
+			}
+			update.Patches = append(update.Patches, patch)
+		}
+		return update, nil
+	}
+
+	// A single patch shares the update's header block
+	if header.Get("Content-Range") != "" {
+		patch, err := d.readPatch(header)
+		if err != nil {
+			return update, err
+		}
+		update.Patches = []Patch{patch}
+		return update, nil
+	}
+
+	body, err := d.readBody(header)
+	if err != nil {
+		return update, err
+	}
+	update.Body = string(body)
+
+	return update, nil
+}
+
+// All returns an iterator over the remaining updates in the stream. Iteration
+// stops after the first error, which is yielded unless it is a clean io.EOF.
+func (d *Decoder) All() iter.Seq2[Update, error] {
+	return func(yield func(Update, error) bool) {
+		for {
+			update, err := d.Decode()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if !yield(update, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readHeaderBlock reads a block of headers, skipping any blank separator lines before it
+func (d *Decoder) readHeaderBlock() (textproto.MIMEHeader, error) {
+	// Skip separators between updates
+	for {
+		b, err := d.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		d.r.ReadByte()
+	}
+
+	header, err := textproto.NewReader(d.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return header, nil
+}
+
+// readPatch reads the content of a patch described by the given headers
+func (d *Decoder) readPatch(header textproto.MIMEHeader) (Patch, error) {
+	unit, rng, _ := strings.Cut(header.Get("Content-Range"), " ")
+
+	content, err := d.readBody(header)
+	if err != nil {
+		return Patch{}, err
+	}
+
+	return Patch{
+		Unit:    unit,
+		Range:   rng,
+		Content: string(content),
+	}, nil
+}
+
+// readBody reads exactly Content-Length bytes from the stream and decodes
+// them, failing if that takes the update over the maximum frame size
+func (d *Decoder) readBody(header textproto.MIMEHeader) ([]byte, error) {
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header: %q", header.Get("Content-Length"))
+	}
+	if err := d.take(length); err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(d.r, body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	// Bodies and patches may be compressed individually
+	switch encoding := header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip content: %w", err)
+		}
+		defer zr.Close()
+
+		// The decompressed content counts instead of the compressed one
+		d.frameLeft += length
+		if d.MaxFrameSize <= 0 {
+			return io.ReadAll(zr)
+		}
+		decompressed, err := io.ReadAll(io.LimitReader(zr, int64(d.frameLeft)+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip content: %w", err)
+		}
+		return decompressed, d.take(len(decompressed))
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding: %q", encoding)
+	}
+}
+
+// take counts n more bytes of content against the maximum frame size of the
+// update being decoded
+func (d *Decoder) take(n int) error {
+	if d.MaxFrameSize <= 0 {
+		return nil
+	}
+	if d.frameLeft -= n; d.frameLeft < 0 {
+		return fmt.Errorf("%w of %d bytes", ErrFrameTooLarge, d.MaxFrameSize)
+	}
+	return nil
+}
+
+// unexpectedEOF converts an EOF in the middle of an update into io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package braidproto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// updateSeparator terminates every update written to a subscription stream
+const updateSeparator = "\r\n\r\n\r\n\r\n\r\n"
+
+// Encoder writes Updates to a Braid subscription stream
+type Encoder struct {
+	w io.Writer
+
+	// ContentEncoding compresses bodies and patch contents of at least
+	// CompressMinSize bytes, marking them with a Content-Encoding header.
+	// Only "gzip" is supported, and the empty string disables compression.
+	ContentEncoding string
+	CompressMinSize int
+}
+
+// NewEncoder creates a new Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes a single Update to the stream. Updates without patches are
+// written as a full body; a single patch shares the update's header block and
+// multiple patches are announced with a Patches header.
+func (e *Encoder) Encode(update Update) error {
+	var buf bytes.Buffer
+
+	if update.URL != "" {
+		fmt.Fprintf(&buf, "Content-Location: %s\r\n", update.URL)
+	}
+	if update.Status != 0 {
+		fmt.Fprintf(&buf, "Status: %d\r\n", update.Status)
+	}
+	fmt.Fprintf(&buf, "Version: %s\r\n", FormatVersions(update.Version))
+	fmt.Fprintf(&buf, "Parents: %s\r\n", FormatVersions(update.Parents))
+	if update.Sequence != 0 {
+		fmt.Fprintf(&buf, "Sequence: %d\r\n", update.Sequence)
+	}
+	if update.MergeType != "" {
+		fmt.Fprintf(&buf, "Merge-Type: %s\r\n", update.MergeType)
+	}
+
+	switch len(update.Patches) {
+	case 0:
+		body, encoded, err := e.encodeContent(update.Body)
+		if err != nil {
+			return err
+		}
+		if encoded {
+			fmt.Fprintf(&buf, "Content-Encoding: %s\r\n", e.ContentEncoding)
+		}
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n", len(body))
+		fmt.Fprintf(&buf, "\r\n")
+		buf.Write(body)
+	case 1:
+		if err := e.writePatch(&buf, update.Patches[0]); err != nil {
+			return err
+		}
+	default:
+		fmt.Fprintf(&buf, "Patches: %d\r\n\r\n", len(update.Patches))
+		for i, patch := range update.Patches {
+			if i > 0 {
+				fmt.Fprintf(&buf, "\r\n\r\n")
+			}
+			if err := e.writePatch(&buf, patch); err != nil {
+				return err
+			}
+		}
+	}
+
+	buf.WriteString(updateSeparator)
+
+	// Write the frame in a single call so it reaches the stream in one piece
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// EncodeWarning writes a frame carrying only a Warning header, e.g. to tell
+// a client why the server is about to close the stream
+func (e *Encoder) EncodeWarning(message string) error {
+	frame := fmt.Sprintf("Warning: 199 - %s\r\nContent-Length: 0\r\n\r\n%s", quoteString(message), updateSeparator)
+	_, err := io.WriteString(e.w, frame)
+	return err
+}
+
+// writePatch writes the headers and content of a single patch
+func (e *Encoder) writePatch(buf *bytes.Buffer, patch Patch) error {
+	content, encoded, err := e.encodeContent(patch.Content)
+	if err != nil {
+		return err
+	}
+
+	if encoded {
+		fmt.Fprintf(buf, "Content-Encoding: %s\r\n", e.ContentEncoding)
+	}
+	fmt.Fprintf(buf, "Content-Length: %d\r\n", len(content))
+	if patch.Range == "" {
+		// Patches in formats like JSON Patch cover the whole resource
+		fmt.Fprintf(buf, "Content-Range: %s\r\n", patch.Unit)
+	} else {
+		fmt.Fprintf(buf, "Content-Range: %s %s\r\n", patch.Unit, patch.Range)
+	}
+	fmt.Fprintf(buf, "\r\n")
+	buf.Write(content)
+	return nil
+}
+
+// encodeContent compresses content with the encoder's content encoding if it
+// is large enough, and reports whether it did
+func (e *Encoder) encodeContent(content string) ([]byte, bool, error) {
+	if e.ContentEncoding == "" || len(content) < e.CompressMinSize {
+		return []byte(content), false, nil
+	}
+	if e.ContentEncoding != "gzip" {
+		return nil, false, fmt.Errorf("unsupported content encoding: %s", e.ContentEncoding)
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		return nil, false, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, false, err
+	}
+	return compressed.Bytes(), true, nil
+}
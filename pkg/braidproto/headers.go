@@ -0,0 +1,116 @@
+package braidproto
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FormatVersions formats version identifiers as a Structured Field list of
+// strings, e.g. `"a", "b"`, for use in Version and Parents headers
+func FormatVersions(versions []string) string {
+	items := make([]string, len(versions))
+	for i, version := range versions {
+		items[i] = quoteString(version)
+	}
+	return strings.Join(items, ", ")
+}
+
+// ParseVersions parses a Version or Parents header value formatted as a
+// Structured Field list of strings. Bare tokens are accepted for
+// compatibility with clients that don't quote their versions.
+func ParseVersions(value string) ([]string, error) {
+	var versions []string
+
+	s := strings.TrimSpace(value)
+	for len(s) > 0 {
+		var item string
+		var err error
+
+		if s[0] == '"' {
+			item, s, err = parseString(s)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			end := strings.IndexAny(s, ",;")
+			if end < 0 {
+				end = len(s)
+			}
+			item = strings.TrimSpace(s[:end])
+			s = s[end:]
+			if item == "" || strings.ContainsAny(item, "\" \t") {
+				return nil, fmt.Errorf("invalid version list: %q", value)
+			}
+		}
+		versions = append(versions, item)
+
+		// Parameters carry no meaning for versions and are skipped
+		if strings.HasPrefix(s, ";") {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			s = s[end:]
+		}
+
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		if s[0] != ',' {
+			return nil, fmt.Errorf("invalid version list: %q", value)
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		if s == "" {
+			return nil, fmt.Errorf("invalid version list: %q", value)
+		}
+	}
+
+	return versions, nil
+}
+
+// quoteString formats s as a Structured Field string
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// parseWarning returns the text of a Warning header such as
+// `199 - "Subscriber too slow"`, or the whole value if it has none
+func parseWarning(value string) string {
+	if i := strings.IndexByte(value, '"'); i >= 0 {
+		if text, _, err := parseString(value[i:]); err == nil {
+			return text
+		}
+	}
+	return value
+}
+
+// parseString parses a Structured Field string at the start of s and returns
+// its value along with the rest of the input
+func parseString(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i >= len(s) || (s[i] != '"' && s[i] != '\\') {
+				return "", "", fmt.Errorf("invalid escape in string: %q", s)
+			}
+			b.WriteByte(s[i])
+		case '"':
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string: %q", s)
+}
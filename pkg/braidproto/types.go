@@ -9,8 +9,12 @@ type Patch struct {
 
 // Update represents a Braid protocol update with version, parents, and either patches or a full body
 type Update struct {
-	Version []string `json:"version"`           // Version identifiers for this update
-	Parents []string `json:"parents"`           // Parent versions this update is based on
-	Patches []Patch  `json:"patches,omitempty"` // Optional list of patches
-	Body    string   `json:"body,omitempty"`    // Optional full body content
+	URL       string   `json:"url,omitempty"`        // Optional URL of the resource, set on streams carrying several resources
+	Version   []string `json:"version"`              // Version identifiers for this update
+	Parents   []string `json:"parents"`              // Parent versions this update is based on
+	MergeType string   `json:"merge_type,omitempty"` // Optional merge algorithm for resolving concurrent updates, e.g. "sync9"
+	Patches   []Patch  `json:"patches,omitempty"`    // Optional list of patches
+	Body      string   `json:"body,omitempty"`       // Optional full body content
+	Status    int      `json:"status,omitempty"`     // Optional status of the resource as of this update, e.g. 410 once it is deleted
+	Sequence  uint64   `json:"sequence,omitempty"`   // Optional number of the change among the changes of the resource, counting up from 1
 }